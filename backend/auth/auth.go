@@ -0,0 +1,216 @@
+package auth
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"profanity.com/config"
+	"profanity.com/invite"
+)
+
+var (
+	ErrMissingToken = errors.New("missing access token")
+	ErrInvalidToken = errors.New("invalid access token")
+	ErrOtherTenant  = errors.New("the invite belongs to another tenant")
+	ErrOtherHost    = errors.New("the host token belongs to another user")
+)
+
+var (
+	// Users authenticates the callers of /create, /join and /ws, authentication is disabled when nil
+	Users Authenticator
+
+	// allowedOrigins are the origins of the browsers allowed to call the server, every origin when nil
+	allowedOrigins map[string]bool
+)
+
+// LoadConfig reads the verification of the access tokens and the allowed origins from the environment.
+// The tokens are signed either with the shared secret AUTH_JWT_SECRET, or with the keys of the JWKS file AUTH_JWKS_FILE.
+func LoadConfig() error {
+	secret := config.String("AUTH_JWT_SECRET", "")
+	jwksFile := config.String("AUTH_JWKS_FILE", "")
+	options := Options{
+		Issuer:      config.String("AUTH_ISSUER", ""),
+		Audience:    config.String("AUTH_AUDIENCE", ""),
+		UserClaim:   config.String("AUTH_USER_CLAIM", DEFAULT_USER_CLAIM),
+		TenantClaim: config.String("AUTH_TENANT_CLAIM", DEFAULT_TENANT_CLAIM),
+		RoleClaim:   config.String("AUTH_ROLE_CLAIM", DEFAULT_ROLE_CLAIM),
+	}
+
+	switch {
+	case secret != "" && jwksFile != "":
+		return errors.New("AUTH_JWT_SECRET and AUTH_JWKS_FILE are exclusive")
+	case secret != "":
+		Users = NewSecretAuthenticator([]byte(secret), options)
+	case jwksFile != "":
+		authenticator, err := NewJWKSAuthenticator(jwksFile, options)
+		if err != nil {
+			return err
+		}
+		Users = authenticator
+	default:
+		slog.Warn("AUTH_JWT_SECRET and AUTH_JWKS_FILE are not set, the callers are not authenticated")
+		Users = nil
+	}
+
+	SetAllowedOrigins(config.String("ALLOWED_ORIGINS", ""))
+	return nil
+}
+
+// SetAllowedOrigins restricts the browsers to the comma separated origins, or allows them all when empty
+func SetAllowedOrigins(origins string) {
+	allowedOrigins = nil
+	for _, origin := range strings.Split(origins, ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			if allowedOrigins == nil {
+				allowedOrigins = make(map[string]bool)
+			}
+			allowedOrigins[strings.TrimSuffix(origin, "/")] = true
+		}
+	}
+	if allowedOrigins == nil {
+		slog.Warn("ALLOWED_ORIGINS is not set, every origin is allowed")
+	}
+}
+
+// CheckOrigin returns true when the browser origin of the request is allowed.
+// The clients which are not browsers send no origin.
+func CheckOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	return origin == "" || allowedOrigins == nil || allowedOrigins[origin]
+}
+
+// AllowCORS sets the CORS headers for an allowed origin, and returns true when it answered a preflight request
+func AllowCORS(w http.ResponseWriter, r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	switch {
+	case allowedOrigins == nil:
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	case allowedOrigins[origin]:
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+
+	if r.Method != http.MethodOptions {
+		return false
+	}
+	w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PATCH, DELETE, OPTIONS")
+	w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, "+invite.HEADER_INVITE_TOKEN)
+	w.WriteHeader(http.StatusNoContent)
+	return true
+}
+
+// Require returns the identity of the caller, and answers 401 when authentication is enabled and fails.
+// The identity is empty when authentication is disabled.
+func Require(w http.ResponseWriter, r *http.Request) (Identity, bool) {
+	if Users == nil {
+		return Identity{}, true
+	}
+	identity, err := Users.Authenticate(r)
+	if err != nil {
+		slog.Info("Authentication refused", "reason", err)
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return Identity{}, false
+	}
+	return identity, true
+}
+
+// Apply replaces the user of the invite by the authenticated identity, and refuses the invites of another tenant.
+// A host token is only accepted from the user it was signed for.
+func (i Identity) Apply(claims invite.Claims) (invite.Claims, error) {
+	if i.UserID == "" {
+		// Authentication is disabled, the invite decides the user
+		return claims, nil
+	}
+	if claims.Tenant != "" && claims.Tenant != i.Tenant {
+		return claims, ErrOtherTenant
+	}
+	if claims.Role == invite.ROLE_HOST && claims.UserID != i.UserID {
+		return claims, ErrOtherHost
+	}
+	claims.UserID = i.UserID
+	return claims, nil
+}
+
+// NewSecretAuthenticator returns an authenticator of the tokens signed with the HMAC secret
+func NewSecretAuthenticator(secret []byte, options Options) *JWTAuthenticator {
+	return &JWTAuthenticator{
+		key:     func(*jwt.Token) (interface{}, error) { return secret, nil },
+		methods: []string{"HS256", "HS384", "HS512"},
+		options: options,
+	}
+}
+
+// NewJWKSAuthenticator returns an authenticator of the tokens signed with the keys of the JWKS file
+func NewJWKSAuthenticator(path string, options Options) (*JWTAuthenticator, error) {
+	keys, err := LoadJWKS(path)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("JWKS loaded", "path", path, "keys", len(keys))
+	return &JWTAuthenticator{
+		key: func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			if key, ok := keys[kid]; ok {
+				return key, nil
+			}
+			// A set of a single key may sign tokens without a key ID
+			if kid == "" && len(keys) == 1 {
+				for _, key := range keys {
+					return key, nil
+				}
+			}
+			return nil, fmt.Errorf("unknown key %q", kid)
+		},
+		methods: []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"},
+		options: options,
+	}, nil
+}
+
+// Authenticate verifies the access token of the request, from the access_token query parameter or the Authorization header
+func (a *JWTAuthenticator) Authenticate(r *http.Request) (Identity, error) {
+	token := r.URL.Query().Get(ACCESS_TOKEN_PARAM)
+	if token == "" {
+		token, _ = strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	}
+	if token == "" {
+		return Identity{}, ErrMissingToken
+	}
+
+	parserOptions := []jwt.ParserOption{
+		jwt.WithValidMethods(a.methods),
+		jwt.WithExpirationRequired(),
+		jwt.WithLeeway(DEFAULT_LEEWAY),
+	}
+	if a.options.Issuer != "" {
+		parserOptions = append(parserOptions, jwt.WithIssuer(a.options.Issuer))
+	}
+	if a.options.Audience != "" {
+		parserOptions = append(parserOptions, jwt.WithAudience(a.options.Audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(token, claims, a.key, parserOptions...); err != nil {
+		return Identity{}, fmt.Errorf("%w: %v", ErrInvalidToken, err)
+	}
+
+	identity := Identity{
+		UserID: stringClaim(claims, a.options.UserClaim),
+		Tenant: stringClaim(claims, a.options.TenantClaim),
+		Role:   stringClaim(claims, a.options.RoleClaim),
+	}
+	if identity.UserID == "" {
+		return Identity{}, fmt.Errorf("%w: no %s claim", ErrInvalidToken, a.options.UserClaim)
+	}
+	return identity, nil
+}
+
+// stringClaim returns the claim when it is a string
+func stringClaim(claims jwt.MapClaims, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
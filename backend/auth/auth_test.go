@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// request returns a request carrying the access token in the Authorization header
+func request(token string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "/join", nil)
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	return r
+}
+
+// TestSecretAuthenticator tests that only unexpired tokens signed with the secret are accepted, and mapped to the identity
+func TestSecretAuthenticator(t *testing.T) {
+	authenticator := NewSecretAuthenticator([]byte("secret"), Options{
+		Issuer: "idp", UserClaim: "email", TenantClaim: DEFAULT_TENANT_CLAIM, RoleClaim: DEFAULT_ROLE_CLAIM,
+	})
+	sign := func(method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+		token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	valid := jwt.MapClaims{"iss": "idp", "email": "alice@example.com", "tenant": "acme", "role": "guest", "exp": time.Now().Add(time.Hour).Unix()}
+
+	identity, err := authenticator.Authenticate(request(sign(jwt.SigningMethodHS256, []byte("secret"), valid)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != (Identity{UserID: "alice@example.com", Tenant: "acme", Role: "guest"}) {
+		t.Errorf("unexpected identity %+v", identity)
+	}
+
+	// The websockets send the token in the query
+	r := httptest.NewRequest(http.MethodGet, "/join?access_token="+sign(jwt.SigningMethodHS256, []byte("secret"), valid), nil)
+	if _, err := authenticator.Authenticate(r); err != nil {
+		t.Errorf("expected the token of the query to be accepted, got %v", err)
+	}
+
+	with := func(key string, value interface{}) jwt.MapClaims {
+		claims := jwt.MapClaims{}
+		for k, v := range valid {
+			claims[k] = v
+		}
+		claims[key] = value
+		return claims
+	}
+	tests := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{"missing", "", ErrMissingToken},
+		{"other secret", sign(jwt.SigningMethodHS256, []byte("other"), valid), ErrInvalidToken},
+		{"unsigned", sign(jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), ErrInvalidToken},
+		{"expired", sign(jwt.SigningMethodHS256, []byte("secret"), with("exp", time.Now().Add(-time.Hour).Unix())), ErrInvalidToken},
+		{"other issuer", sign(jwt.SigningMethodHS256, []byte("secret"), with("iss", "other")), ErrInvalidToken},
+		{"no user", sign(jwt.SigningMethodHS256, []byte("secret"), with("email", "")), ErrInvalidToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := authenticator.Authenticate(request(tt.token)); !errors.Is(err, tt.expected) {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+}
+
+// TestJWKSAuthenticator tests that the tokens are verified with the key of the JWKS named by their key ID
+func TestJWKSAuthenticator(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := jwks{Keys: []jwk{{
+		Kty: "RSA",
+		Kid: "key-1",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	data, _ := json.Marshal(set)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	authenticator, err := NewJWKSAuthenticator(path, Options{UserClaim: DEFAULT_USER_CLAIM})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sign := func(kid string) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{"sub": "bob", "exp": time.Now().Add(time.Hour).Unix()})
+		token.Header["kid"] = kid
+		signed, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	if identity, err := authenticator.Authenticate(request(sign("key-1"))); err != nil || identity.UserID != "bob" {
+		t.Errorf("expected bob to be authenticated, got %+v %v", identity, err)
+	}
+	if _, err := authenticator.Authenticate(request(sign("key-2"))); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("expected an unknown key to be refused, got %v", err)
+	}
+}
+
+// TestCheckOrigin tests that only the configured origins are allowed, and every origin without configuration
+func TestCheckOrigin(t *testing.T) {
+	t.Cleanup(func() { SetAllowedOrigins("") })
+
+	fromOrigin := func(origin string) *http.Request {
+		r := httptest.NewRequest(http.MethodGet, "/join", nil)
+		if origin != "" {
+			r.Header.Set("Origin", origin)
+		}
+		return r
+	}
+
+	SetAllowedOrigins("")
+	if !CheckOrigin(fromOrigin("https://evil.example")) {
+		t.Error("expected every origin to be allowed without configuration")
+	}
+
+	SetAllowedOrigins("https://chat.example, https://admin.example/")
+	for origin, expected := range map[string]bool{
+		"https://chat.example":  true,
+		"https://admin.example": true,
+		"https://evil.example":  false,
+		"":                      true,
+	} {
+		if allowed := CheckOrigin(fromOrigin(origin)); allowed != expected {
+			t.Errorf("origin %q: expected %v, got %v", origin, expected, allowed)
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package auth
+
+import "time"
+
+// Claims of the access tokens mapped to the identity, unless configured otherwise
+const (
+	DEFAULT_USER_CLAIM   = "sub"
+	DEFAULT_TENANT_CLAIM = "tenant"
+	DEFAULT_ROLE_CLAIM   = "role"
+)
+
+const (
+	// Query parameter of the access token, browsers cannot set headers on a websocket
+	ACCESS_TOKEN_PARAM = "access_token"
+
+	// Clock skew tolerated on the expiry and the not-before of the access tokens
+	DEFAULT_LEEWAY = 30 * time.Second
+)
@@ -0,0 +1,51 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Identity is the verified caller of a request
+type Identity struct {
+	UserID string
+	Tenant string
+	Role   string
+}
+
+// Authenticator verifies the caller of a request
+type Authenticator interface {
+	Authenticate(r *http.Request) (Identity, error)
+}
+
+// Options of the verification of the access tokens, and the claims mapped to the identity
+type Options struct {
+	Issuer      string
+	Audience    string
+	UserClaim   string
+	TenantClaim string
+	RoleClaim   string
+}
+
+// JWTAuthenticator verifies the access tokens signed with a shared secret or with the keys of a JWKS
+type JWTAuthenticator struct {
+	key     jwt.Keyfunc
+	methods []string
+	options Options
+}
+
+// jwk is a public key of a JSON Web Key Set
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwks struct {
+	Keys []jwk `json:"keys"`
+}
@@ -0,0 +1,127 @@
+package classifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"profanity.com/config"
+	"profanity.com/metrics"
+	"profanity.com/tracing"
+)
+
+// ProfanityClassifier scores the profanity of a text, between 0 and 1
+type ProfanityClassifier interface {
+	Classify(ctx context.Context, text string) (float64, error)
+}
+
+// Profanity is the classifier shared by the transcription and the chat
+var Profanity ProfanityClassifier = NewHTTPClassifier(DEFAULT_URL, DEFAULT_TIMEOUT)
+
+// LoadConfig reads the address of the profanity service from the environment
+func LoadConfig() {
+	c := NewHTTPClassifier(
+		config.String("PROFANITY_URL", DEFAULT_URL),
+		config.Duration("PROFANITY_TIMEOUT", DEFAULT_TIMEOUT),
+	)
+	c.healthURL = config.String("PROFANITY_HEALTH_URL", c.healthURL)
+	Profanity = c
+}
+
+// Check returns an error when the backend of the classifier does not respond
+func Check(ctx context.Context) error {
+	if p, ok := Profanity.(Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+// IsFlagged returns true if the score is high enough for the text to be flagged
+func IsFlagged(score float64) bool {
+	return score > FLAG_THRESHOLD
+}
+
+// NewHTTPClassifier returns a classifier calling the profanity service at url
+func NewHTTPClassifier(url string, timeout time.Duration) *HTTPClassifier {
+	return &HTTPClassifier{url: url, healthURL: healthURL(url), client: &http.Client{Timeout: timeout}}
+}
+
+// healthURL returns the health endpoint served next to the scoring endpoint
+func healthURL(scoreURL string) string {
+	u, err := url.Parse(scoreURL)
+	if err != nil {
+		return scoreURL
+	}
+	u.Path = HEALTH_PATH
+	return u.String()
+}
+
+// Ping calls the health endpoint of the profanity service
+func (c *HTTPClassifier) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.healthURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("profanity service answered %s", resp.Status)
+	}
+	return nil
+}
+
+// Classify sends the text to the profanity service and returns its score
+func (c *HTTPClassifier) Classify(ctx context.Context, text string) (float64, error) {
+	ctx, span := tracing.Tracer.Start(ctx, tracing.SPAN_CLASSIFY, trace.WithSpanKind(trace.SpanKindClient))
+	start := time.Now()
+	score, err := c.classify(ctx, text)
+	metrics.ClassifierDuration.Observe(time.Since(start).Seconds())
+	if err != nil {
+		metrics.ClassifierErrors.Inc()
+	}
+	span.SetAttributes(attribute.String("server.url", c.url), attribute.Float64("profanity.score", score))
+	tracing.End(span, err)
+	return score, err
+}
+
+// classify calls the profanity service
+func (c *HTTPClassifier) classify(ctx context.Context, text string) (float64, error) {
+	jsonData, err := json.Marshal(PostData{Text: text})
+	if err != nil {
+		return 0, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.url, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// The profanity service continues the trace of the utterance
+	tracing.Inject(ctx, req.Header)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return 0, fmt.Errorf("profanity service answered %s", resp.Status)
+	}
+
+	var responseData PostResponse
+	if err := json.NewDecoder(resp.Body).Decode(&responseData); err != nil {
+		return 0, err
+	}
+	return responseData.ProfanityScore, nil
+}
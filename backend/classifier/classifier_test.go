@@ -0,0 +1,53 @@
+package classifier
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"profanity.com/tracing"
+)
+
+// TestTracePropagation tests that the classifier call is traced, and continued by the profanity service
+func TestTracePropagation(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { provider.Shutdown(context.Background()) })
+
+	traceparent := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		traceparent <- r.Header.Get("traceparent")
+		json.NewEncoder(w).Encode(PostResponse{ProfanityScore: 0.5})
+	}))
+	defer srv.Close()
+
+	ctx, utterance := tracing.Tracer.Start(context.Background(), tracing.SPAN_UTTERANCE)
+	if _, err := NewHTTPClassifier(srv.URL, time.Second).Classify(ctx, "hello"); err != nil {
+		t.Fatal(err)
+	}
+	utterance.End()
+
+	spans := recorder.Ended()
+	if len(spans) != 2 || spans[0].Name() != tracing.SPAN_CLASSIFY {
+		t.Fatalf("expected the classifier span then the utterance, got %d spans", len(spans))
+	}
+	classify := spans[0].SpanContext()
+	if classify.TraceID() != utterance.SpanContext().TraceID() {
+		t.Error("expected the classifier span in the trace of the utterance")
+	}
+
+	// traceparent is "00-<trace id>-<parent span id>-<flags>"
+	expected := "00-" + classify.TraceID().String() + "-" + classify.SpanID().String() + "-01"
+	if header := <-traceparent; header != expected {
+		t.Errorf("expected the traceparent %s, got %q", expected, header)
+	}
+}
@@ -0,0 +1,13 @@
+package classifier
+
+import "time"
+
+const (
+	// Profanity service scoring the texts
+	DEFAULT_URL     = "http://profanity:8080/profanity"
+	DEFAULT_TIMEOUT = 5 * time.Second
+	HEALTH_PATH     = "/health"
+
+	// A text scoring over the threshold is flagged
+	FLAG_THRESHOLD = 0.9
+)
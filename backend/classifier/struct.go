@@ -0,0 +1,26 @@
+package classifier
+
+import (
+	"context"
+	"net/http"
+)
+
+// HTTPClassifier scores the texts with the profanity service
+type HTTPClassifier struct {
+	url       string
+	healthURL string
+	client    *http.Client
+}
+
+// Pinger is implemented by the classifiers with a backend to check
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
+type PostData struct {
+	Text string `json:"text"`
+}
+
+type PostResponse struct {
+	ProfanityScore float64 `json:"profanity_score"`
+}
@@ -0,0 +1,86 @@
+package cluster
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"os"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"profanity.com/config"
+)
+
+var ErrRoomExists = errors.New("room already exists")
+
+// Bus relays the room messages between the replicas and tracks the rooms and their members
+type Bus interface {
+	// Node returns the name of this replica
+	Node() string
+
+	// Publish sends the message to the other replicas serving the room
+	Publish(ctx context.Context, msg Message) error
+	// Subscribe calls the handler with the messages of the room published by the other replicas.
+	// The returned function unsubscribes.
+	Subscribe(roomID string, handler func(Message)) (func(), error)
+
+	// CreateRoom registers the room, it returns ErrRoomExists if the roomID is taken
+	CreateRoom(ctx context.Context, roomID string, info RoomInfo) error
+	// GetRoom returns the room and false if nobody created it or it was deleted
+	GetRoom(ctx context.Context, roomID string) (RoomInfo, bool, error)
+	DeleteRoom(ctx context.Context, roomID string) error
+
+	// Join and Leave track the presence of the participants, whatever their replica
+	Join(ctx context.Context, roomID string, userID string) error
+	Leave(ctx context.Context, roomID string, userID string) error
+	Members(ctx context.Context, roomID string) ([]string, error)
+
+	// SetFlag and Ban share the moderation state of the room, enforced by every replica on the joins
+	SetFlag(ctx context.Context, roomID string, flag string, enabled bool) error
+	Ban(ctx context.Context, roomID string, userID string) error
+	State(ctx context.Context, roomID string) (RoomState, error)
+
+	Close() error
+}
+
+// Rooms is the bus of the server, in process until LoadConfig selects another one
+var Rooms Bus = NewLocal()
+
+// LoadConfig selects the bus from the environment. With BUS=redis, the rooms are shared through REDIS_URL.
+func LoadConfig() error {
+	switch kind := config.String("BUS", BUS_LOCAL); kind {
+	case BUS_LOCAL:
+		Rooms = NewLocal()
+
+	case BUS_REDIS:
+		options, err := redis.ParseURL(config.String("REDIS_URL", "redis://localhost:6379/0"))
+		if err != nil {
+			return err
+		}
+
+		bus := NewRedis(redis.NewClient(options), nodeName(), config.Duration("BUS_KEY_TTL", DEFAULT_KEY_TTL))
+		ctx, cancel := context.WithTimeout(context.Background(), OPERATION_TIMEOUT)
+		defer cancel()
+		if err := bus.client.Ping(ctx).Err(); err != nil {
+			return err
+		}
+		Rooms = bus
+
+	default:
+		return errors.New("unknown bus " + kind)
+	}
+
+	slog.Info("Room bus selected", "bus", config.String("BUS", BUS_LOCAL), "node", Rooms.Node())
+	return nil
+}
+
+// nodeName returns the name of this replica, NODE_NAME or the hostname
+func nodeName() string {
+	if name := config.String("NODE_NAME", ""); name != "" {
+		return name
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname + "-" + uuid.New().String()[:8]
+	}
+	return uuid.New().String()
+}
@@ -0,0 +1,26 @@
+package cluster
+
+import "time"
+
+// Implementations of the bus
+const (
+	BUS_LOCAL = "local"
+	BUS_REDIS = "redis"
+)
+
+// Redis keys of a room: its marker, its members, its moderation flags and bans, and its message channel
+const (
+	ROOM_KEY_PREFIX   = "room:"
+	MEMBERS_SUFFIX    = ":members"
+	FLAGS_SUFFIX      = ":flags"
+	BANNED_SUFFIX     = ":banned"
+	MESSAGES_SUFFIX   = ":messages"
+	DEFAULT_KEY_TTL   = 24 * time.Hour
+	OPERATION_TIMEOUT = 2 * time.Second
+)
+
+// Moderation flags of a room set by its hosts
+const (
+	FLAG_LOCKED       = "locked"
+	FLAG_WAITING_ROOM = "waitingRoom"
+)
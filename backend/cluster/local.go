@@ -0,0 +1,145 @@
+package cluster
+
+import (
+	"context"
+	"sort"
+)
+
+// NewLocal returns a bus for a single replica, which has nobody to relay the messages to
+func NewLocal() *Local {
+	return &Local{
+		rooms:   make(map[string]RoomInfo),
+		members: make(map[string]map[string]bool),
+		flags:   make(map[string]map[string]bool),
+		banned:  make(map[string]map[string]bool),
+	}
+}
+
+// Node returns the name of this replica
+func (l *Local) Node() string {
+	return BUS_LOCAL
+}
+
+// Publish has no other replica to reach
+func (l *Local) Publish(ctx context.Context, msg Message) error {
+	return nil
+}
+
+// Subscribe never calls the handler, every participant is served by this replica
+func (l *Local) Subscribe(roomID string, handler func(Message)) (func(), error) {
+	return func() {}, nil
+}
+
+// CreateRoom registers the room unless the roomID is taken
+func (l *Local) CreateRoom(ctx context.Context, roomID string, info RoomInfo) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if _, ok := l.rooms[roomID]; ok {
+		return ErrRoomExists
+	}
+	info.Node = BUS_LOCAL
+	l.rooms[roomID] = info
+	return nil
+}
+
+// GetRoom returns the room if it was created and not deleted
+func (l *Local) GetRoom(ctx context.Context, roomID string) (RoomInfo, bool, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	info, ok := l.rooms[roomID]
+	return info, ok, nil
+}
+
+// DeleteRoom removes the room, its members and its moderation state
+func (l *Local) DeleteRoom(ctx context.Context, roomID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.rooms, roomID)
+	delete(l.members, roomID)
+	delete(l.flags, roomID)
+	delete(l.banned, roomID)
+	return nil
+}
+
+// Join adds the user to the members of the room
+func (l *Local) Join(ctx context.Context, roomID string, userID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.members[roomID] == nil {
+		l.members[roomID] = make(map[string]bool)
+	}
+	l.members[roomID][userID] = true
+	return nil
+}
+
+// Leave removes the user from the members of the room
+func (l *Local) Leave(ctx context.Context, roomID string, userID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	delete(l.members[roomID], userID)
+	return nil
+}
+
+// Members returns the users present in the room
+func (l *Local) Members(ctx context.Context, roomID string) ([]string, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	members := []string{}
+	for userID := range l.members[roomID] {
+		members = append(members, userID)
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// SetFlag sets the moderation flag of the room
+func (l *Local) SetFlag(ctx context.Context, roomID string, flag string, enabled bool) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.flags[roomID] == nil {
+		l.flags[roomID] = make(map[string]bool)
+	}
+	l.flags[roomID][flag] = enabled
+	return nil
+}
+
+// Ban adds the user to the banned users of the room
+func (l *Local) Ban(ctx context.Context, roomID string, userID string) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.banned[roomID] == nil {
+		l.banned[roomID] = make(map[string]bool)
+	}
+	l.banned[roomID][userID] = true
+	return nil
+}
+
+// State returns the moderation flags and the banned users of the room
+func (l *Local) State(ctx context.Context, roomID string) (RoomState, error) {
+	l.mutex.RLock()
+	defer l.mutex.RUnlock()
+
+	state := RoomState{
+		Locked:      l.flags[roomID][FLAG_LOCKED],
+		WaitingRoom: l.flags[roomID][FLAG_WAITING_ROOM],
+		Banned:      []string{},
+	}
+	for userID := range l.banned[roomID] {
+		state.Banned = append(state.Banned, userID)
+	}
+	sort.Strings(state.Banned)
+	return state, nil
+}
+
+// Close has nothing to release
+func (l *Local) Close() error {
+	return nil
+}
@@ -0,0 +1,181 @@
+package cluster
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// NewRedis returns a bus sharing the rooms through the Redis client. The keys of a room live for ttl after its last change.
+func NewRedis(client *redis.Client, node string, ttl time.Duration) *Redis {
+	return &Redis{node: node, client: client, ttl: ttl}
+}
+
+// Node returns the name of this replica
+func (r *Redis) Node() string {
+	return r.node
+}
+
+// roomKey returns the key marking the room as created
+func roomKey(roomID string) string {
+	return ROOM_KEY_PREFIX + roomID
+}
+
+// Publish sends the message on the channel of the room
+func (r *Redis) Publish(ctx context.Context, msg Message) error {
+	msg.Node = r.node
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	return r.client.Publish(ctx, roomKey(msg.RoomID)+MESSAGES_SUFFIX, data).Err()
+}
+
+// Subscribe calls the handler with the messages published on the channel of the room by the other replicas
+func (r *Redis) Subscribe(roomID string, handler func(Message)) (func(), error) {
+	ctx, cancel := context.WithTimeout(context.Background(), OPERATION_TIMEOUT)
+	defer cancel()
+
+	pubsub := r.client.Subscribe(context.Background(), roomKey(roomID)+MESSAGES_SUFFIX)
+	// Wait for the subscription to be confirmed, so no message published afterwards is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return nil, err
+	}
+
+	go func() {
+		for payload := range pubsub.Channel() {
+			var msg Message
+			if err := json.Unmarshal([]byte(payload.Payload), &msg); err != nil {
+				slog.Error("Invalid message on the bus", "roomID", roomID, "err", err)
+				continue
+			}
+			if msg.Node == r.node {
+				continue
+			}
+			handler(msg)
+		}
+	}()
+
+	return func() { pubsub.Close() }, nil
+}
+
+// CreateRoom registers the room unless another replica already did
+func (r *Redis) CreateRoom(ctx context.Context, roomID string, info RoomInfo) error {
+	info.Node = r.node
+	data, err := json.Marshal(info)
+	if err != nil {
+		return err
+	}
+
+	created, err := r.client.SetNX(ctx, roomKey(roomID), data, r.ttl).Result()
+	if err != nil {
+		return err
+	}
+	if !created {
+		return ErrRoomExists
+	}
+	return nil
+}
+
+// GetRoom returns the room if a replica created it and nobody closed it
+func (r *Redis) GetRoom(ctx context.Context, roomID string) (RoomInfo, bool, error) {
+	var info RoomInfo
+
+	data, err := r.client.Get(ctx, roomKey(roomID)).Bytes()
+	if err == redis.Nil {
+		return info, false, nil
+	}
+	if err != nil {
+		return info, false, err
+	}
+	if err := json.Unmarshal(data, &info); err != nil {
+		return info, false, err
+	}
+	return info, true, nil
+}
+
+// DeleteRoom removes the room, its members and its moderation state
+func (r *Redis) DeleteRoom(ctx context.Context, roomID string) error {
+	key := roomKey(roomID)
+	return r.client.Del(ctx, key, key+MEMBERS_SUFFIX, key+FLAGS_SUFFIX, key+BANNED_SUFFIX).Err()
+}
+
+// Join adds the user to the members of the room and extends the life of the room and of its state
+func (r *Redis) Join(ctx context.Context, roomID string, userID string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, roomKey(roomID)+MEMBERS_SUFFIX, userID)
+		pipe.Expire(ctx, roomKey(roomID)+MEMBERS_SUFFIX, r.ttl)
+		pipe.Expire(ctx, roomKey(roomID)+FLAGS_SUFFIX, r.ttl)
+		pipe.Expire(ctx, roomKey(roomID)+BANNED_SUFFIX, r.ttl)
+		pipe.Expire(ctx, roomKey(roomID), r.ttl)
+		return nil
+	})
+	return err
+}
+
+// Leave removes the user from the members of the room
+func (r *Redis) Leave(ctx context.Context, roomID string, userID string) error {
+	return r.client.SRem(ctx, roomKey(roomID)+MEMBERS_SUFFIX, userID).Err()
+}
+
+// Members returns the users present in the room, on any replica
+func (r *Redis) Members(ctx context.Context, roomID string) ([]string, error) {
+	members, err := r.client.SMembers(ctx, roomKey(roomID)+MEMBERS_SUFFIX).Result()
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// SetFlag sets the moderation flag of the room, it lives as long as the room
+func (r *Redis) SetFlag(ctx context.Context, roomID string, flag string, enabled bool) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.HSet(ctx, roomKey(roomID)+FLAGS_SUFFIX, flag, enabled)
+		pipe.Expire(ctx, roomKey(roomID)+FLAGS_SUFFIX, r.ttl)
+		return nil
+	})
+	return err
+}
+
+// Ban adds the user to the banned users of the room, they live as long as the room
+func (r *Redis) Ban(ctx context.Context, roomID string, userID string) error {
+	_, err := r.client.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.SAdd(ctx, roomKey(roomID)+BANNED_SUFFIX, userID)
+		pipe.Expire(ctx, roomKey(roomID)+BANNED_SUFFIX, r.ttl)
+		return nil
+	})
+	return err
+}
+
+// State returns the moderation flags and the banned users of the room, set from any replica
+func (r *Redis) State(ctx context.Context, roomID string) (RoomState, error) {
+	var flags *redis.MapStringStringCmd
+	var banned *redis.StringSliceCmd
+	_, err := r.client.Pipelined(ctx, func(pipe redis.Pipeliner) error {
+		flags = pipe.HGetAll(ctx, roomKey(roomID)+FLAGS_SUFFIX)
+		banned = pipe.SMembers(ctx, roomKey(roomID)+BANNED_SUFFIX)
+		return nil
+	})
+	if err != nil {
+		return RoomState{}, err
+	}
+
+	state := RoomState{
+		Locked:      flags.Val()[FLAG_LOCKED] == "1",
+		WaitingRoom: flags.Val()[FLAG_WAITING_ROOM] == "1",
+		Banned:      banned.Val(),
+	}
+	sort.Strings(state.Banned)
+	return state, nil
+}
+
+// Close closes the connection to Redis
+func (r *Redis) Close() error {
+	return r.client.Close()
+}
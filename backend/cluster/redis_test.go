@@ -0,0 +1,117 @@
+package cluster
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+// newTestRedis returns two replicas sharing a local stand-in Redis server
+func newTestRedis(t *testing.T) (*Redis, *Redis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	nodeA := NewRedis(redis.NewClient(&redis.Options{Addr: server.Addr()}), "a", time.Hour)
+	nodeB := NewRedis(redis.NewClient(&redis.Options{Addr: server.Addr()}), "b", time.Hour)
+	t.Cleanup(func() {
+		nodeA.Close()
+		nodeB.Close()
+	})
+	return nodeA, nodeB
+}
+
+// TestRedisRooms tests that a room created on a replica can be joined from another one
+func TestRedisRooms(t *testing.T) {
+	nodeA, nodeB := newTestRedis(t)
+	ctx := context.Background()
+
+	if err := nodeA.CreateRoom(ctx, "abc-defg-hij", RoomInfo{PasswordHash: []byte("hash")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := nodeB.CreateRoom(ctx, "abc-defg-hij", RoomInfo{}); err != ErrRoomExists {
+		t.Errorf("expected the roomID to be taken, got %v", err)
+	}
+	info, exists, err := nodeB.GetRoom(ctx, "abc-defg-hij")
+	if err != nil || !exists || info.Node != "a" || string(info.PasswordHash) != "hash" {
+		t.Errorf("expected the room of a to be shared, got %+v %v %v", info, exists, err)
+	}
+
+	nodeA.Join(ctx, "abc-defg-hij", "alice")
+	nodeB.Join(ctx, "abc-defg-hij", "bob")
+	members, err := nodeA.Members(ctx, "abc-defg-hij")
+	if err != nil || len(members) != 2 || members[0] != "alice" || members[1] != "bob" {
+		t.Errorf("expected alice and bob, got %v %v", members, err)
+	}
+
+	nodeB.Leave(ctx, "abc-defg-hij", "bob")
+	if members, _ := nodeA.Members(ctx, "abc-defg-hij"); len(members) != 1 {
+		t.Errorf("expected bob to have left, got %v", members)
+	}
+
+	nodeA.DeleteRoom(ctx, "abc-defg-hij")
+	if _, exists, _ := nodeB.GetRoom(ctx, "abc-defg-hij"); exists {
+		t.Error("expected the room to be deleted")
+	}
+}
+
+// TestRedisPublish tests that the messages reach the other replicas and never come back to their publisher
+func TestRedisPublish(t *testing.T) {
+	nodeA, nodeB := newTestRedis(t)
+
+	receivedA := make(chan Message, 1)
+	receivedB := make(chan Message, 1)
+	unsubscribeA, err := nodeA.Subscribe("abc-defg-hij", func(msg Message) { receivedA <- msg })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribeA()
+	unsubscribeB, err := nodeB.Subscribe("abc-defg-hij", func(msg Message) { receivedB <- msg })
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unsubscribeB()
+
+	err = nodeA.Publish(context.Background(), Message{RoomID: "abc-defg-hij", From: "alice", Payload: []byte(`{"type":"chat"}`)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case msg := <-receivedB:
+		if msg.Node != "a" || msg.From != "alice" || string(msg.Payload) != `{"type":"chat"}` {
+			t.Errorf("unexpected message %+v", msg)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("the other replica never received the message")
+	}
+
+	select {
+	case msg := <-receivedA:
+		t.Errorf("the publisher received its own message %+v", msg)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// TestRedisState tests that the lock, the waiting room and the bans set on a replica are read by the others
+func TestRedisState(t *testing.T) {
+	nodeA, nodeB := newTestRedis(t)
+	ctx := context.Background()
+
+	nodeA.SetFlag(ctx, "abc-defg-hij", FLAG_LOCKED, true)
+	nodeA.SetFlag(ctx, "abc-defg-hij", FLAG_WAITING_ROOM, true)
+	nodeA.Ban(ctx, "abc-defg-hij", "eve")
+	nodeB.SetFlag(ctx, "abc-defg-hij", FLAG_WAITING_ROOM, false)
+
+	state, err := nodeB.State(ctx, "abc-defg-hij")
+	if err != nil || !state.Locked || state.WaitingRoom || len(state.Banned) != 1 || state.Banned[0] != "eve" {
+		t.Errorf("expected the room locked with eve banned, got %+v %v", state, err)
+	}
+
+	nodeB.DeleteRoom(ctx, "abc-defg-hij")
+	if state, _ := nodeA.State(ctx, "abc-defg-hij"); state.Locked || len(state.Banned) != 0 {
+		t.Errorf("expected the state to be deleted with the room, got %+v", state)
+	}
+}
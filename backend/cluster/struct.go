@@ -0,0 +1,50 @@
+package cluster
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Message is a room message relayed to the other replicas
+type Message struct {
+	// Node is the replica which published the message
+	Node   string `json:"node"`
+	RoomID string `json:"roomID"`
+	// From is the sender, which does not receive the message, and To restricts it to a single participant
+	From    string          `json:"from,omitempty"`
+	To      string          `json:"to,omitempty"`
+	Payload json.RawMessage `json:"payload"`
+}
+
+// RoomInfo is what the replicas share about a room
+type RoomInfo struct {
+	// Node is the replica which created the room
+	Node         string `json:"node"`
+	PasswordHash []byte `json:"passwordHash,omitempty"`
+}
+
+// RoomState is the moderation state of a room shared by the replicas
+type RoomState struct {
+	Locked      bool
+	WaitingRoom bool
+	Banned      []string
+}
+
+// Local keeps the rooms of a single replica in memory
+type Local struct {
+	mutex   sync.RWMutex
+	rooms   map[string]RoomInfo
+	members map[string]map[string]bool
+	flags   map[string]map[string]bool
+	banned  map[string]map[string]bool
+}
+
+// Redis shares the rooms between the replicas through a Redis server
+type Redis struct {
+	node   string
+	client *redis.Client
+	ttl    time.Duration
+}
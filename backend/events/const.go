@@ -0,0 +1,24 @@
+package events
+
+// Event types
+const (
+	ROOM_CREATED = "room.created"
+	ROOM_EXPIRED = "room.expired"
+	ROOM_CLOSED  = "room.closed"
+
+	// A host applied a moderation command
+	ROOM_MODERATED = "room.moderated"
+
+	// A participant was admitted in a room, or left it
+	PARTICIPANT_JOINED = "participant.joined"
+	PARTICIPANT_LEFT   = "participant.left"
+
+	// A transcription or a chat message of a participant went over the profanity threshold
+	USER_FLAGGED = "user.flagged"
+)
+
+// Sources of a flag
+const (
+	SOURCE_TRANSCRIPTION = "transcription"
+	SOURCE_CHAT          = "chat"
+)
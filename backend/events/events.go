@@ -0,0 +1,74 @@
+package events
+
+import (
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Internal is the event bus shared by the server and the transcription
+var Internal = NewBus()
+
+// NewBus returns a bus without subscribers
+func NewBus() *Bus {
+	return &Bus{subscribers: make(map[*subscriber]struct{})}
+}
+
+// Subscribe returns a channel receiving the events of the given types, or every event when none is given.
+// The returned function unsubscribes and closes the channel.
+func (b *Bus) Subscribe(buffer int, types ...string) (<-chan Event, func()) {
+	s := &subscriber{events: make(chan Event, buffer)}
+	if len(types) > 0 {
+		s.types = make(map[string]bool, len(types))
+		for _, t := range types {
+			s.types[t] = true
+		}
+	}
+
+	b.mutex.Lock()
+	b.subscribers[s] = struct{}{}
+	b.mutex.Unlock()
+
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+
+		if _, ok := b.subscribers[s]; ok {
+			delete(b.subscribers, s)
+			close(s.events)
+		}
+	}
+	return s.events, unsubscribe
+}
+
+// Publish sends the event to the subscribers without blocking.
+// A subscriber too slow to keep up misses the event.
+func (b *Bus) Publish(event Event) {
+	if event.ID == "" {
+		event.ID = uuid.New().String()
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now()
+	}
+
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+
+	for s := range b.subscribers {
+		if s.types != nil && !s.types[event.Type] {
+			continue
+		}
+
+		select {
+		case s.events <- event:
+		default:
+			slog.Warn("Event subscriber is full, dropping event", "type", event.Type, "roomID", event.RoomID)
+		}
+	}
+}
+
+// Publish sends an event on the internal bus
+func Publish(eventType string, roomID string, userID string, data map[string]interface{}) {
+	Internal.Publish(Event{Type: eventType, RoomID: roomID, UserID: userID, Data: data})
+}
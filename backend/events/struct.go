@@ -0,0 +1,26 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something that happened in a room, published on the internal bus
+type Event struct {
+	ID        string                 `json:"id"`
+	Type      string                 `json:"type"`
+	RoomID    string                 `json:"room_id,omitempty"`
+	UserID    string                 `json:"user_id,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+type subscriber struct {
+	events chan Event
+	types  map[string]bool
+}
+
+type Bus struct {
+	mutex       sync.RWMutex
+	subscribers map[*subscriber]struct{}
+}
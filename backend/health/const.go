@@ -0,0 +1,18 @@
+package health
+
+import "time"
+
+// Status of a component, and of the instance
+const (
+	STATUS_OK        = "ok"
+	STATUS_FAILED    = "failed"
+	STATUS_READY     = "ready"
+	STATUS_DEGRADED  = "degraded"
+	STATUS_NOT_READY = "not_ready"
+)
+
+const (
+	// Results of the checks calling a backend are reused for the TTL, the probes must not flood the backends
+	DEFAULT_CACHE_TTL     = 30 * time.Second
+	DEFAULT_CHECK_TIMEOUT = 3 * time.Second
+)
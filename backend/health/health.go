@@ -0,0 +1,123 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"profanity.com/config"
+)
+
+// Checks are the components checked by the readiness probe
+var Checks = NewRegistry(DEFAULT_CACHE_TTL, DEFAULT_CHECK_TIMEOUT)
+
+// LoadConfig reads the cache TTL and the timeout of the checks from the environment
+func LoadConfig() {
+	Checks.mutex.Lock()
+	defer Checks.mutex.Unlock()
+
+	Checks.cacheTTL = config.Duration("HEALTH_CACHE_TTL", DEFAULT_CACHE_TTL)
+	Checks.timeout = config.Duration("HEALTH_CHECK_TIMEOUT", DEFAULT_CHECK_TIMEOUT)
+}
+
+// NewRegistry returns a registry without checks
+func NewRegistry(cacheTTL time.Duration, timeout time.Duration) *Registry {
+	return &Registry{cacheTTL: cacheTTL, timeout: timeout}
+}
+
+// Register adds the check of a backend, its result is cached. The instance serves without the backend, its
+// failure only degrades the instance.
+func (r *Registry) Register(name string, check Check) {
+	r.add(&component{name: name, check: check, cached: true})
+}
+
+// RegisterLocal adds the check of a local component, run on every probe. The instance cannot serve without it.
+func (r *Registry) RegisterLocal(name string, check Check) {
+	r.add(&component{name: name, check: check, critical: true})
+}
+
+func (r *Registry) add(c *component) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.components = append(r.components, c)
+}
+
+// Ready runs the checks concurrently, and returns the readiness of the instance
+func (r *Registry) Ready(ctx context.Context) Report {
+	r.mutex.RLock()
+	components := r.components
+	cacheTTL, timeout := r.cacheTTL, r.timeout
+	r.mutex.RUnlock()
+
+	statuses := make([]ComponentStatus, len(components))
+	var wg sync.WaitGroup
+	for i, c := range components {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = c.status(ctx, cacheTTL, timeout)
+		}()
+	}
+	wg.Wait()
+
+	report := Report{Status: STATUS_READY, Components: make(map[string]ComponentStatus, len(components))}
+	for i, c := range components {
+		report.Components[c.name] = statuses[i]
+		switch {
+		case statuses[i].Status == STATUS_OK:
+		case c.critical:
+			report.Status = STATUS_NOT_READY
+		case report.Status == STATUS_READY:
+			report.Status = STATUS_DEGRADED
+		}
+	}
+	return report
+}
+
+// status returns the cached result of the check, or runs it
+func (c *component) status(ctx context.Context, cacheTTL time.Duration, timeout time.Duration) ComponentStatus {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.cached && !c.last.CheckedAt.IsZero() && time.Since(c.last.CheckedAt) < cacheTTL {
+		return c.last
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.check(ctx)
+	c.last = ComponentStatus{Status: STATUS_OK, CheckedAt: start, LatencyMs: time.Since(start).Milliseconds()}
+	if err != nil {
+		c.last.Status = STATUS_FAILED
+		c.last.Error = err.Error()
+	}
+	return c.last
+}
+
+// LivezHandler answers while the process serves requests
+func LivezHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": STATUS_OK})
+}
+
+// ReadyzHandler answers 200 when the local components are usable, even with a backend down, and 503 otherwise,
+// with the status of the components
+func ReadyzHandler(w http.ResponseWriter, r *http.Request) {
+	report := Checks.Ready(r.Context())
+	status := http.StatusOK
+	if report.Status == STATUS_NOT_READY {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(w, status, report)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
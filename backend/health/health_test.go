@@ -0,0 +1,68 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestReadiness tests that a failing local component makes the instance not ready, a failing backend only degraded,
+// and that the backend checks are cached
+func TestReadiness(t *testing.T) {
+	previous := Checks
+	Checks = NewRegistry(time.Minute, time.Second)
+	t.Cleanup(func() { Checks = previous })
+
+	backendCalls := 0
+	var backendErr error
+	Checks.Register("classifier", func(ctx context.Context) error {
+		backendCalls++
+		return backendErr
+	})
+	var localErr error
+	Checks.RegisterLocal("recognizer", func(ctx context.Context) error { return localErr })
+
+	probe := func() (int, Report) {
+		rec := httptest.NewRecorder()
+		ReadyzHandler(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+		var report Report
+		json.NewDecoder(rec.Body).Decode(&report)
+		return rec.Code, report
+	}
+
+	if code, report := probe(); code != http.StatusOK || report.Status != STATUS_READY {
+		t.Fatalf("expected the instance to be ready, got %d %+v", code, report)
+	}
+
+	localErr = errors.New("model not loaded")
+	code, report := probe()
+	if code != http.StatusServiceUnavailable || report.Status != STATUS_NOT_READY {
+		t.Fatalf("expected the instance not to be ready, got %d %+v", code, report)
+	}
+	if recognizer := report.Components["recognizer"]; recognizer.Status != STATUS_FAILED || recognizer.Error != "model not loaded" {
+		t.Errorf("expected the recognizer to fail with its error, got %+v", recognizer)
+	}
+	if classifier := report.Components["classifier"]; classifier.Status != STATUS_OK {
+		t.Errorf("expected the classifier to be ok, got %+v", classifier)
+	}
+	if backendCalls != 1 {
+		t.Errorf("expected the classifier check to be cached, got %d calls", backendCalls)
+	}
+
+	// A backend down degrades the instance, it still receives traffic
+	Checks = NewRegistry(0, time.Second)
+	Checks.Register("classifier", func(ctx context.Context) error { return backendErr })
+	Checks.RegisterLocal("recognizer", func(ctx context.Context) error { return nil })
+	backendErr = errors.New("classifier unreachable")
+	code, report = probe()
+	if code != http.StatusOK || report.Status != STATUS_DEGRADED {
+		t.Errorf("expected the instance to be degraded, got %d %+v", code, report)
+	}
+	if classifier := report.Components["classifier"]; classifier.Status != STATUS_FAILED {
+		t.Errorf("expected the classifier to fail, got %+v", classifier)
+	}
+}
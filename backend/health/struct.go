@@ -0,0 +1,44 @@
+package health
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Check returns an error when the component is not usable
+type Check func(ctx context.Context) error
+
+// ComponentStatus is the last result of the check of a component
+type ComponentStatus struct {
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	LatencyMs int64     `json:"latency_ms"`
+}
+
+// Report is the readiness of the instance, with the status of every component
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentStatus `json:"components"`
+}
+
+type component struct {
+	name   string
+	check  Check
+	cached bool
+	// A failed critical component makes the instance not ready, the others degrade it
+	critical bool
+
+	// Held while checking, the concurrent probes wait for the same result
+	mutex sync.Mutex
+	last  ComponentStatus
+}
+
+// Registry holds the checks of the components the instance needs to serve
+type Registry struct {
+	mutex      sync.RWMutex
+	components []*component
+	cacheTTL   time.Duration
+	timeout    time.Duration
+}
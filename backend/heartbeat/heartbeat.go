@@ -0,0 +1,27 @@
+package heartbeat
+
+import (
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ExpectPongs makes the reads of the connection fail when the client stops answering the pings.
+// Every pong postpones the deadline by the timeout, every message too with Extend. A zero timeout disables it.
+func ExpectPongs(conn *websocket.Conn, timeout time.Duration) {
+	if timeout <= 0 {
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(timeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(timeout))
+	})
+}
+
+// Extend postpones the read deadline after a message from the client
+func Extend(conn *websocket.Conn, timeout time.Duration) {
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+	}
+}
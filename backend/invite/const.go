@@ -0,0 +1,15 @@
+package invite
+
+import "time"
+
+// Roles granted by an invite token
+const (
+	ROLE_HOST  = "host"
+	ROLE_GUEST = "guest"
+)
+
+// Default validity of an invite token
+const DEFAULT_TTL = 24 * time.Hour
+
+// Header carrying the invite token, the Authorization header is left to the access token of the user
+const HEADER_INVITE_TOKEN = "X-Invite-Token"
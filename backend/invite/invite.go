@@ -0,0 +1,130 @@
+package invite
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"profanity.com/config"
+)
+
+var (
+	ErrInvalidToken = errors.New("invalid invite token")
+	ErrExpiredToken = errors.New("expired invite token")
+	ErrSharedInvite = errors.New("the invite must be redeemed first")
+)
+
+// Tokens signs and verifies the invite tokens of the server
+var Tokens = NewSigner(randomSecret(), DEFAULT_TTL)
+
+// NewSigner returns a signer using the HMAC secret, issuing tokens valid for ttl
+func NewSigner(secret []byte, ttl time.Duration) *Signer {
+	return &Signer{secret: secret, ttl: ttl}
+}
+
+// LoadConfig reads the invite secret and validity from the environment.
+// Without INVITE_SECRET the tokens are signed with a random secret and do not survive a restart.
+func LoadConfig() {
+	secret := []byte(config.String("INVITE_SECRET", ""))
+	if len(secret) == 0 {
+		slog.Warn("INVITE_SECRET is not set, invite tokens will be invalidated on restart")
+		secret = randomSecret()
+	}
+	Tokens = NewSigner(secret, config.Duration("INVITE_TTL", DEFAULT_TTL))
+}
+
+// randomSecret generates a random HMAC secret
+func randomSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("invite: unable to generate a secret: " + err.Error())
+	}
+	return secret
+}
+
+// Sign issues a token granting the role in the room to the user
+func (s *Signer) Sign(roomID string, userID string, role string) (string, Claims, error) {
+	return s.SignInTenant(roomID, userID, role, "")
+}
+
+// SignInTenant issues a token granting the role in the room of the tenant, only its users can use it
+func (s *Signer) SignInTenant(roomID string, userID string, role string, tenant string) (string, Claims, error) {
+	return s.sign(Claims{RoomID: roomID, UserID: userID, Role: role, Tenant: tenant})
+}
+
+// SignInvite issues an invite shared with the guests of the room of the tenant.
+// It does not join the room, each guest redeems it for a token of its own.
+func (s *Signer) SignInvite(roomID string, tenant string) (string, Claims, error) {
+	return s.sign(Claims{RoomID: roomID, Role: ROLE_GUEST, Tenant: tenant, Shared: true})
+}
+
+// sign sets the expiry of the claims and returns the token carrying them
+func (s *Signer) sign(claims Claims) (string, Claims, error) {
+	claims.ExpiresAt = time.Now().Add(s.ttl).Unix()
+
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", claims, err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + s.signature(encoded), claims, nil
+}
+
+// Verify checks the signature and the expiry of the token and returns its claims
+func (s *Signer) Verify(token string) (Claims, error) {
+	var claims Claims
+
+	encoded, signature, found := strings.Cut(token, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(s.signature(encoded))) {
+		return claims, ErrInvalidToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+
+	if claims.RoomID == "" || (claims.UserID == "" && !claims.Shared) || (claims.Role != ROLE_HOST && claims.Role != ROLE_GUEST) {
+		return claims, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return claims, ErrExpiredToken
+	}
+	return claims, nil
+}
+
+// VerifyPersonal verifies the token like Verify, and refuses the invites shared with the guests
+func (s *Signer) VerifyPersonal(token string) (Claims, error) {
+	claims, err := s.Verify(token)
+	if err == nil && claims.Shared {
+		return claims, ErrSharedInvite
+	}
+	return claims, err
+}
+
+// signature returns the HMAC of the encoded claims
+func (s *Signer) signature(encoded string) string {
+	mac := hmac.New(sha256.New, s.secret)
+	mac.Write([]byte(encoded))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// FromRequest returns the invite token of the request, from the "token" query parameter
+// used by the websockets or from the X-Invite-Token header
+func FromRequest(r *http.Request) string {
+	if token := r.URL.Query().Get("token"); token != "" {
+		return token
+	}
+	return r.Header.Get(HEADER_INVITE_TOKEN)
+}
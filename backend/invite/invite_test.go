@@ -0,0 +1,71 @@
+package invite
+
+import (
+	"testing"
+	"time"
+)
+
+// TestVerify tests that only untampered and unexpired tokens are accepted
+func TestVerify(t *testing.T) {
+	signer := NewSigner([]byte("secret"), time.Hour)
+	token, _, err := signer.Sign("abc-defg-hij", "user", ROLE_GUEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims, err := signer.Verify(token)
+	if err != nil {
+		t.Fatalf("expected a valid token, got %v", err)
+	}
+	if claims.RoomID != "abc-defg-hij" || claims.UserID != "user" || claims.Role != ROLE_GUEST {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+
+	otherSigner := NewSigner([]byte("other"), time.Hour)
+	expiredSigner := NewSigner([]byte("secret"), -time.Minute)
+	expired, _, _ := expiredSigner.Sign("abc-defg-hij", "user", ROLE_GUEST)
+
+	tests := []struct {
+		name     string
+		token    string
+		expected error
+	}{
+		{"empty", "", ErrInvalidToken},
+		{"no signature", token[:len(token)-44], ErrInvalidToken},
+		{"tampered claims", "x" + token[1:], ErrInvalidToken},
+		{"tampered signature", token[:len(token)-1] + "x", ErrInvalidToken},
+		{"expired", expired, ErrExpiredToken},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := signer.Verify(tt.token); err != tt.expected {
+				t.Errorf("expected %v, got %v", tt.expected, err)
+			}
+		})
+	}
+
+	if _, err := otherSigner.Verify(token); err != ErrInvalidToken {
+		t.Errorf("expected a token signed with another secret to be invalid, got %v", err)
+	}
+}
+
+// TestVerifyPersonal tests that the invite shared with the guests is refused until it is redeemed
+func TestVerifyPersonal(t *testing.T) {
+	signer := NewSigner([]byte("secret"), time.Hour)
+	shared, _, err := signer.SignInvite("abc-defg-hij", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if claims, err := signer.Verify(shared); err != nil || !claims.Shared || claims.UserID != "" {
+		t.Errorf("expected a valid shared invite, got %+v, %v", claims, err)
+	}
+	if _, err := signer.VerifyPersonal(shared); err != ErrSharedInvite {
+		t.Errorf("expected the shared invite to be refused, got %v", err)
+	}
+
+	personal, _, _ := signer.Sign("abc-defg-hij", "user", ROLE_GUEST)
+	if _, err := signer.VerifyPersonal(personal); err != nil {
+		t.Errorf("expected the personal token to be accepted, got %v", err)
+	}
+}
@@ -0,0 +1,19 @@
+package invite
+
+import "time"
+
+// Claims are the signed content of an invite token
+type Claims struct {
+	RoomID string `json:"room"`
+	UserID string `json:"sub"`
+	Role   string `json:"role"`
+	Tenant string `json:"tenant,omitempty"`
+	// Shared is set on the invites handed to every guest, redeemed for a token of their own
+	Shared    bool  `json:"shared,omitempty"`
+	ExpiresAt int64 `json:"exp"`
+}
+
+type Signer struct {
+	secret []byte
+	ttl    time.Duration
+}
@@ -1,18 +1,42 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/joho/godotenv"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"profanity.com/auth"
+	"profanity.com/classifier"
+	"profanity.com/cluster"
+	"profanity.com/config"
+	"profanity.com/events"
+	"profanity.com/health"
+	"profanity.com/invite"
+	"profanity.com/report"
 	server "profanity.com/server"
+	"profanity.com/tracing"
+	"profanity.com/webhooks"
 	webrtcServer "profanity.com/webrtcServer"
 )
 
-// health is a simple health check handler
-func health(w http.ResponseWriter, r *http.Request) {
+const (
+	// Time given to the calls in progress to drain when the server stops
+	DEFAULT_SHUTDOWN_TIMEOUT = 20 * time.Second
+	// Internal port of the metrics, not routed by the reverse proxy
+	DEFAULT_METRICS_PORT = "9090"
+)
+
+// healthCheck is a simple health check handler
+func healthCheck(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprintln(w, "Health Check")
 }
 
@@ -22,23 +46,117 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
+	server.LoadConfig()
+	invite.LoadConfig()
+	if err := auth.LoadConfig(); err != nil {
+		log.Fatal("Error loading the authentication: ", err)
+	}
+	webrtcServer.LoadConfig()
+	classifier.LoadConfig()
+	health.LoadConfig()
+	if err := cluster.LoadConfig(); err != nil {
+		log.Fatal("Error connecting to the room bus: ", err)
+	}
+	if err := webhooks.LoadConfig(); err != nil {
+		log.Fatal("Error loading the webhooks: ", err)
+	}
+	webhooks.Outbox.Start(events.Internal)
+	if err := tracing.LoadConfig(context.Background()); err != nil {
+		log.Fatal("Error loading the tracing exporter: ", err)
+	}
 	server.AllRooms.Init()
+	server.AllRooms.StartJanitor(context.Background())
+
+	// Components checked by /readyz, the backends are called at most once per HEALTH_CACHE_TTL and only degrade
+	// the instance when they fail
+	health.Checks.RegisterLocal("server", server.CheckAccepting)
+	health.Checks.RegisterLocal("recognizer", webrtcServer.CheckRecognizer)
+	health.Checks.Register("classifier", classifier.Check)
+	health.Checks.Register("llm", report.Meetings.CheckLLM)
 
 	port := os.Getenv("PORT")
 	if port == "" {
 		port = "8080"
 	}
 
-	http.HandleFunc("/health", health)
+	// Kept for the existing probes, /livez and /readyz tell whether the instance can serve
+	http.HandleFunc("/health", healthCheck)
+	http.HandleFunc("GET /livez", health.LivezHandler)
+	http.HandleFunc("GET /readyz", health.ReadyzHandler)
 	http.HandleFunc("/create", server.CreateRoomRequestHandler)
 	http.HandleFunc("/join", server.JoinRoomRequestHandler)
+	http.HandleFunc("OPTIONS /v1/rooms/{id}/invites", server.InviteRequestHandler)
+	http.HandleFunc("POST /v1/rooms/{id}/invites", server.InviteRequestHandler)
+	http.HandleFunc("OPTIONS /v1/rooms/{id}/redeem", server.RedeemInviteRequestHandler)
+	http.HandleFunc("POST /v1/rooms/{id}/redeem", server.RedeemInviteRequestHandler)
+	http.HandleFunc("OPTIONS /v1/rooms/{id}/report", server.RoomReportRequestHandler)
+	http.HandleFunc("GET /v1/rooms/{id}/report", server.RoomReportRequestHandler)
+
+	// Room inspection, reserved to the holders of the admin token
+	http.HandleFunc("GET /v1/admin/rooms", server.RequireAdmin(server.AdminRoomsHandler))
+	http.HandleFunc("GET /v1/admin/rooms/{id}", server.RequireAdmin(server.AdminRoomHandler))
+	http.HandleFunc("DELETE /v1/admin/rooms/{id}", server.RequireAdmin(server.AdminCloseRoomHandler))
+	http.HandleFunc("GET /v1/admin/rooms/{id}/participants/{userID}", server.RequireAdmin(server.AdminParticipantHandler))
+	http.HandleFunc("DELETE /v1/admin/rooms/{id}/participants/{userID}", server.RequireAdmin(server.AdminKickHandler))
 
 	// Add the WebRTC handle for transcription
 	webrtcServer.AddWebRTCHandle()
 
-	log.Println("Starting server on port " + port)
-	err = http.ListenAndServe(":"+port, nil)
-	if err != nil {
-		log.Fatal((err))
+	httpServer := &http.Server{Addr: ":" + port}
+	go func() {
+		log.Println("Starting server on port " + port)
+		if err := httpServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// The metrics have their own listener, the public port does not expose the counters of the rooms
+	metricsPort := config.String("METRICS_PORT", DEFAULT_METRICS_PORT)
+	metricsMux := http.NewServeMux()
+	metricsMux.Handle("GET /metrics", promhttp.Handler())
+	metricsServer := &http.Server{Addr: ":" + metricsPort, Handler: metricsMux}
+	go func() {
+		log.Println("Starting metrics server on port " + metricsPort)
+		if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal(err)
+		}
+	}()
+
+	// A deploy sends SIGTERM, the calls in progress are drained before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+	defer stop()
+	<-ctx.Done()
+	shutdown(httpServer, metricsServer)
+}
+
+// shutdown drains the rooms and the transcriptions within SHUTDOWN_TIMEOUT, the metrics are served until the end
+func shutdown(httpServer *http.Server, metricsServer *http.Server) {
+	timeout := config.Duration("SHUTDOWN_TIMEOUT", DEFAULT_SHUTDOWN_TIMEOUT)
+	slog.Info("Shutting down", "timeout", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	server.AllRooms.Shutdown()
+	if err := webrtcServer.Shutdown(ctx); err != nil {
+		slog.Error("Transcriptions were not drained", "err", err)
+	}
+	if err := httpServer.Shutdown(ctx); err != nil {
+		slog.Error("HTTP server was not shut down", "err", err)
+	}
+	if err := server.AllRooms.CloseAll(ctx); err != nil {
+		slog.Error("Rooms were not closed", "err", err)
+	}
+	if err := webhooks.Outbox.Stop(ctx); err != nil {
+		slog.Error("Webhooks were not delivered", "err", err)
+	}
+	if err := cluster.Rooms.Close(); err != nil {
+		slog.Error("Room bus was not closed", "err", err)
+	}
+	if err := tracing.Shutdown(ctx); err != nil {
+		slog.Error("Spans were not exported", "err", err)
+	}
+	if err := metricsServer.Shutdown(ctx); err != nil {
+		slog.Error("Metrics server was not shut down", "err", err)
 	}
+	slog.Info("Server stopped")
 }
@@ -0,0 +1,35 @@
+package metrics
+
+// Namespace of every series
+const NAMESPACE = "clean_chat"
+
+// Severities of a flag, from the profanity score
+const (
+	SEVERITY_SEVERE   = "severe"
+	SEVERITY_HIGH     = "high"
+	SEVERITY_MODERATE = "moderate"
+
+	SEVERE_THRESHOLD = 0.98
+	HIGH_THRESHOLD   = 0.95
+)
+
+// Outcomes of a received RTP packet
+const (
+	RTP_RECEIVED = "received"
+	RTP_DECODED  = "decoded"
+	RTP_FAILED   = "failed"
+)
+
+// Callers of the LLM
+const (
+	LLM_MODERATION = "moderation"
+	LLM_SUMMARY    = "summary"
+)
+
+// Endpoints writing to the clients, the results of the WHIP and RTP sessions go through server-sent events
+const (
+	ENDPOINT_JOIN          = "join"
+	ENDPOINT_TRANSCRIPTION = "transcription"
+	ENDPOINT_EVENTS        = "events"
+	ENDPOINT_TELEPHONY     = "telephony"
+)
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"profanity.com/transcription"
+)
+
+// The labels are bounded sets, never room or user IDs, for the series to stay few whatever the number of rooms
+var (
+	PeerConnections = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: NAMESPACE,
+		Name:      "peer_connections_active",
+		Help:      "Transcription peer connections open on this replica.",
+	}, func() float64 { return float64(transcription.Connections.Count()) })
+
+	RTPPackets = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rtp_packets_total",
+		Help:      "RTP packets of the transcribed tracks, by outcome: received, decoded or failed.",
+	}, []string{"outcome"})
+
+	RecognizerDecode = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "recognizer_decode_seconds",
+		Help:      "Time spent by the recognizer decoding the audio of a packet.",
+		Buckets:   prometheus.ExponentialBuckets(0.0005, 2, 12),
+	})
+
+	RecognizerRealTimeFactor = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "recognizer_real_time_factor",
+		Help:      "Decoding time divided by the duration of the audio of an utterance.",
+		Buckets:   []float64{0.01, 0.02, 0.05, 0.1, 0.2, 0.5, 1, 2},
+	})
+
+	ClassifierDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "classifier_duration_seconds",
+		Help:      "Latency of the profanity classifier.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	ClassifierErrors = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "classifier_errors_total",
+		Help:      "Texts the profanity classifier failed to score.",
+	})
+
+	LLMDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: NAMESPACE,
+		Name:      "llm_duration_seconds",
+		Help:      "Latency of the LLM completions, by caller: moderation or summary.",
+		Buckets:   prometheus.ExponentialBuckets(0.25, 2, 8),
+	}, []string{"caller"})
+
+	LLMErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "llm_errors_total",
+		Help:      "Failed LLM completions, by caller: moderation or summary.",
+	}, []string{"caller"})
+
+	Flags = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "flags_total",
+		Help:      "Flagged utterances and chat messages, by source and severity.",
+	}, []string{"source", "severity"})
+
+	WebSocketWriteErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "websocket_write_errors_total",
+		Help:      "Failed writes to the clients, by endpoint: join, transcription, events or telephony.",
+	}, []string{"endpoint"})
+
+	DataChannelFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "data_channel_fallbacks_total",
+		Help:      "Results sent on the websocket while the data channel is congested, or after a failed send on it.",
+	})
+
+	RateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rate_limited_total",
+		Help:      "Requests and messages rejected by the rate limits, by bucket.",
+	}, []string{"bucket"})
+
+	RateLimitDisconnects = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: NAMESPACE,
+		Name:      "rate_limit_disconnects_total",
+		Help:      "Clients disconnected for flooding.",
+	})
+)
+
+// Severity returns the severity of a flagged profanity score
+func Severity(score float64) string {
+	switch {
+	case score > SEVERE_THRESHOLD:
+		return SEVERITY_SEVERE
+	case score > HIGH_THRESHOLD:
+		return SEVERITY_HIGH
+	}
+	return SEVERITY_MODERATE
+}
+
+// Flag counts a flag of the source
+func Flag(source string, score float64) {
+	Flags.WithLabelValues(source, Severity(score)).Inc()
+}
+
+// ObserveLLM records the latency and the outcome of a completion of the caller started at start
+func ObserveLLM(caller string, start time.Time, err error) {
+	LLMDuration.WithLabelValues(caller).Observe(time.Since(start).Seconds())
+	if err != nil {
+		LLMErrors.WithLabelValues(caller).Inc()
+	}
+}
@@ -0,0 +1,20 @@
+package metrics
+
+import "testing"
+
+// TestSeverity tests that the flags are sorted into the severities by score
+func TestSeverity(t *testing.T) {
+	cases := map[float64]string{
+		0.91:  SEVERITY_MODERATE,
+		0.95:  SEVERITY_MODERATE,
+		0.96:  SEVERITY_HIGH,
+		0.99:  SEVERITY_SEVERE,
+		1.0:   SEVERITY_SEVERE,
+		0.985: SEVERITY_SEVERE,
+	}
+	for score, expected := range cases {
+		if severity := Severity(score); severity != expected {
+			t.Errorf("score %v: expected %s, got %s", score, expected, severity)
+		}
+	}
+}
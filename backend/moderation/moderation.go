@@ -0,0 +1,96 @@
+package moderation
+
+import "sync"
+
+type key struct {
+	roomID string
+	userID string
+}
+
+type state struct {
+	muted               bool
+	transcriptionPaused bool
+	kicked              bool
+}
+
+// Registry holds the moderation state set by the hosts, enforced by the server and the transcription
+type Registry struct {
+	mutex  sync.RWMutex
+	states map[key]*state
+}
+
+// Participants is the moderation state of every participant
+var Participants = NewRegistry()
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{states: make(map[key]*state)}
+}
+
+// update applies the change to the state of the participant, creating it if needed
+func (r *Registry) update(roomID string, userID string, change func(s *state)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	k := key{roomID, userID}
+	s, ok := r.states[k]
+	if !ok {
+		s = &state{}
+		r.states[k] = s
+	}
+	change(s)
+}
+
+// SetMuted mutes or unmutes the participant
+func (r *Registry) SetMuted(roomID string, userID string, muted bool) {
+	r.update(roomID, userID, func(s *state) { s.muted = muted })
+}
+
+// SetTranscription turns the transcription of the participant on or off
+func (r *Registry) SetTranscription(roomID string, userID string, enabled bool) {
+	r.update(roomID, userID, func(s *state) { s.transcriptionPaused = !enabled })
+}
+
+// Kick marks the participant as removed from the room
+func (r *Registry) Kick(roomID string, userID string) {
+	r.update(roomID, userID, func(s *state) { s.kicked = true })
+}
+
+// IsMuted returns true if a host muted the participant
+func (r *Registry) IsMuted(roomID string, userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s, ok := r.states[key{roomID, userID}]
+	return ok && s.muted
+}
+
+// IsKicked returns true if a host removed the participant from the room
+func (r *Registry) IsKicked(roomID string, userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s, ok := r.states[key{roomID, userID}]
+	return ok && s.kicked
+}
+
+// TranscriptionAllowed returns true if the audio of the participant can be transcribed
+func (r *Registry) TranscriptionAllowed(roomID string, userID string) bool {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	s, ok := r.states[key{roomID, userID}]
+	return !ok || (!s.muted && !s.transcriptionPaused && !s.kicked)
+}
+
+// ClearRoom forgets the state of every participant of the room
+func (r *Registry) ClearRoom(roomID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	for k := range r.states {
+		if k.roomID == roomID {
+			delete(r.states, k)
+		}
+	}
+}
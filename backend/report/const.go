@@ -0,0 +1,19 @@
+package report
+
+import "time"
+
+const (
+	// How long a final report stays available after the room closed
+	REPORT_RETENTION = 24 * time.Hour
+
+	// Maximum time spent waiting on the LLM for the meeting summary
+	SUMMARY_TIMEOUT = 30 * time.Second
+)
+
+const SUMMARY_PROMPT = `
+You are a helpful assistant writing the minutes of an online meeting.
+The provided text is the transcript of the meeting, one line per utterance, prefixed by the speaker.
+
+In under 100 words, summarize the topics discussed and the decisions taken.
+Do not quote profanity and do not comment on the behavior of the participants.
+`
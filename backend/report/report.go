@@ -0,0 +1,248 @@
+package report
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Meetings records what happens in every room until its report is generated
+var Meetings = NewRecorder()
+
+// NewRecorder returns an empty recorder
+func NewRecorder() *Recorder {
+	return &Recorder{
+		meetings: make(map[string]*meeting),
+		closing:  make(map[string]*meeting),
+		reports:  make(map[string]*Report),
+	}
+}
+
+// getMeeting returns the meeting of the room, starting it if needed. The caller must hold the lock.
+func (r *Recorder) getMeeting(roomID string) *meeting {
+	m, ok := r.meetings[roomID]
+	if !ok {
+		m = &meeting{
+			startedAt:    time.Now(),
+			participants: make(map[string]*participantLog),
+		}
+		r.meetings[roomID] = m
+	}
+	return m
+}
+
+// getParticipant returns the participant log of the user, creating it if needed. The caller must hold the lock.
+func (m *meeting) getParticipant(userID string) *participantLog {
+	p, ok := m.participants[userID]
+	if !ok {
+		p = &participantLog{joinedAt: time.Now()}
+		m.participants[userID] = p
+		m.userIDs = append(m.userIDs, userID)
+	}
+	return p
+}
+
+// Join records a participant joining the room
+func (r *Recorder) Join(roomID string, userID string) {
+	if roomID == "" || userID == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	p := r.getMeeting(roomID).getParticipant(userID)
+	p.leftAt = time.Time{}
+}
+
+// Leave records a participant leaving the room
+func (r *Recorder) Leave(roomID string, userID string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m, ok := r.meetings[roomID]
+	if !ok {
+		return
+	}
+	if p, ok := m.participants[userID]; ok {
+		p.leftAt = time.Now()
+	}
+}
+
+// AddTalkTime adds speaking time to the participant
+func (r *Recorder) AddTalkTime(roomID string, userID string, d time.Duration) {
+	if roomID == "" || userID == "" || d <= 0 {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.getMeeting(roomID).getParticipant(userID).talkTime += d
+}
+
+// AddUtterance records a transcribed utterance and its profanity score on the timeline
+func (r *Recorder) AddUtterance(roomID string, userID string, text string, profanityScore float64) {
+	if roomID == "" || userID == "" {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m := r.getMeeting(roomID)
+	m.getParticipant(userID)
+
+	now := time.Now()
+	m.utterances = append(m.utterances, utterance{userID: userID, text: text, timestamp: now})
+	m.timeline = append(m.timeline, TimelinePoint{UserID: userID, ProfanityScore: profanityScore, Timestamp: now})
+}
+
+// AddFlag records a flagged utterance and returns its ID, used to attach the explanation later on
+func (r *Recorder) AddFlag(roomID string, userID string, text string, profanityScore float64) string {
+	if roomID == "" || userID == "" {
+		return ""
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m := r.getMeeting(roomID)
+	m.getParticipant(userID)
+
+	flag := Flag{
+		ID:             uuid.New().String(),
+		UserID:         userID,
+		Text:           text,
+		ProfanityScore: profanityScore,
+		Timestamp:      time.Now(),
+	}
+	m.flags = append(m.flags, flag)
+	return flag.ID
+}
+
+// Explain attaches the LLM explanation to a flagged utterance
+func (r *Recorder) Explain(roomID string, flagID string, explanation string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m, ok := r.meetings[roomID]
+	if !ok {
+		return
+	}
+	for i := range m.flags {
+		if m.flags[i].ID == flagID {
+			m.flags[i].Explanation = explanation
+			return
+		}
+	}
+}
+
+// build creates the report of the meeting as of now. The caller must hold the lock.
+func (m *meeting) build(roomID string) *Report {
+	now := time.Now()
+	report := &Report{
+		RoomID:          roomID,
+		StartedAt:       m.startedAt,
+		EndedAt:         now,
+		DurationSeconds: now.Sub(m.startedAt).Seconds(),
+		Participants:    make([]ParticipantReport, 0, len(m.userIDs)),
+		Flags:           append([]Flag{}, m.flags...),
+		Timeline:        append([]TimelinePoint{}, m.timeline...),
+	}
+
+	for _, userID := range m.userIDs {
+		p := m.participants[userID]
+		report.Participants = append(report.Participants, ParticipantReport{
+			UserID:          userID,
+			JoinedAt:        p.joinedAt,
+			LeftAt:          p.leftAt,
+			TalkTimeSeconds: p.talkTime.Seconds(),
+		})
+	}
+	return report
+}
+
+// Snapshot returns the report of a meeting still in progress
+func (r *Recorder) Snapshot(roomID string) (*Report, bool) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	m, ok := r.meetings[roomID]
+	if !ok {
+		return nil, false
+	}
+
+	report := m.build(roomID)
+	report.InProgress = true
+	return report, true
+}
+
+// Get returns the final report of the room if it was generated, or the snapshot of the meeting in progress
+func (r *Recorder) Get(roomID string) (*Report, bool) {
+	r.mutex.Lock()
+	report, ok := r.reports[roomID]
+	r.mutex.Unlock()
+
+	if ok {
+		return report, true
+	}
+	return r.Snapshot(roomID)
+}
+
+// Close ends the meeting of the room, writes its summary and stores the final report.
+// The callers closing the same meeting wait for a single report. It returns nil if there was no meeting to close.
+func (r *Recorder) Close(ctx context.Context, roomID string) *Report {
+	r.mutex.Lock()
+	m, ok := r.meetings[roomID]
+	if ok {
+		// The activity from now on belongs to a new meeting
+		delete(r.meetings, roomID)
+		r.closing[roomID] = m
+	} else if m, ok = r.closing[roomID]; !ok {
+		report := r.reports[roomID]
+		r.mutex.Unlock()
+		return report
+	}
+	r.mutex.Unlock()
+
+	m.closeOnce.Do(func() { m.report = r.generate(ctx, roomID, m) })
+	return m.report
+}
+
+// generate builds the final report of the meeting and stores it
+func (r *Recorder) generate(ctx context.Context, roomID string, m *meeting) *Report {
+	r.mutex.Lock()
+	report := m.build(roomID)
+	for i, p := range report.Participants {
+		if p.LeftAt.IsZero() {
+			report.Participants[i].LeftAt = report.EndedAt
+		}
+	}
+	r.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(ctx, SUMMARY_TIMEOUT)
+	defer cancel()
+
+	summary, err := r.summarize(ctx, m.utterances)
+	if err != nil {
+		slog.Error("Error summarizing the meeting", "roomID", roomID, "err", err)
+	}
+	report.Summary = summary
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	delete(r.closing, roomID)
+	r.pruneReports()
+	r.reports[roomID] = report
+	slog.Info("Meeting report generated", "roomID", roomID, "flags", len(report.Flags))
+	return report
+}
+
+// pruneReports removes the reports older than REPORT_RETENTION. The caller must hold the lock.
+func (r *Recorder) pruneReports() {
+	for roomID, report := range r.reports {
+		if time.Since(report.EndedAt) > REPORT_RETENTION {
+			delete(r.reports, roomID)
+		}
+	}
+}
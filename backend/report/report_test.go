@@ -0,0 +1,40 @@
+package report
+
+import (
+	"context"
+	"sync"
+	"testing"
+)
+
+// TestCloseOnce tests that the callers closing a meeting together get the same final report
+func TestCloseOnce(t *testing.T) {
+	recorder := NewRecorder()
+	recorder.Join("room", "alice")
+	recorder.AddFlag("room", "alice", "bad words", 0.99)
+
+	var wg sync.WaitGroup
+	reports := make([]*Report, 8)
+	for i := range reports {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			reports[i] = recorder.Close(context.Background(), "room")
+		}()
+	}
+	wg.Wait()
+
+	for i, report := range reports {
+		if report == nil || report != reports[0] {
+			t.Fatalf("expected every caller to get the same report, got %p for caller %d", report, i)
+		}
+	}
+	if reports[0].InProgress || len(reports[0].Flags) != 1 || len(reports[0].Participants) != 1 {
+		t.Errorf("expected the final report with its flag and participant, got %+v", reports[0])
+	}
+	if report := recorder.Close(context.Background(), "room"); report != reports[0] {
+		t.Error("expected a late close to return the stored report")
+	}
+	if report, ok := recorder.Get("room"); !ok || report != reports[0] {
+		t.Error("expected the stored report to be served")
+	}
+}
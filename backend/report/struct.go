@@ -0,0 +1,78 @@
+package report
+
+import (
+	"sync"
+	"time"
+
+	"github.com/openai/openai-go"
+)
+
+// Report is the end-of-meeting summary and conduct report of a room
+type Report struct {
+	RoomID          string              `json:"room_id"`
+	StartedAt       time.Time           `json:"started_at"`
+	EndedAt         time.Time           `json:"ended_at"`
+	DurationSeconds float64             `json:"duration_seconds"`
+	InProgress      bool                `json:"in_progress"`
+	Participants    []ParticipantReport `json:"participants"`
+	Flags           []Flag              `json:"flags"`
+	Timeline        []TimelinePoint     `json:"timeline"`
+	Summary         string              `json:"summary"`
+}
+
+type ParticipantReport struct {
+	UserID          string    `json:"user_id"`
+	JoinedAt        time.Time `json:"joined_at"`
+	LeftAt          time.Time `json:"left_at,omitempty"`
+	TalkTimeSeconds float64   `json:"talk_time_seconds"`
+}
+
+// Flag is an utterance that went over the profanity threshold
+type Flag struct {
+	ID             string    `json:"id"`
+	UserID         string    `json:"user_id"`
+	Text           string    `json:"text"`
+	ProfanityScore float64   `json:"profanity_score"`
+	Explanation    string    `json:"explanation"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+type TimelinePoint struct {
+	UserID         string    `json:"user_id"`
+	ProfanityScore float64   `json:"profanity_score"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+type utterance struct {
+	userID    string
+	text      string
+	timestamp time.Time
+}
+
+type participantLog struct {
+	joinedAt time.Time
+	leftAt   time.Time
+	talkTime time.Duration
+}
+
+type meeting struct {
+	startedAt    time.Time
+	userIDs      []string
+	participants map[string]*participantLog
+	utterances   []utterance
+	flags        []Flag
+	timeline     []TimelinePoint
+
+	// The final report is generated once, by the first of the callers closing the meeting
+	closeOnce sync.Once
+	report    *Report
+}
+
+type Recorder struct {
+	mutex    sync.Mutex
+	meetings map[string]*meeting
+	// Meetings whose final report is being generated
+	closing map[string]*meeting
+	reports map[string]*Report
+	client  *openai.Client
+}
@@ -0,0 +1,70 @@
+package report
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/attribute"
+	"profanity.com/metrics"
+	"profanity.com/tracing"
+)
+
+// errNoAPIKey is the error of the LLM check when the key of the provider is not configured
+var errNoAPIKey = errors.New("OPENAI_API_KEY is not set")
+
+// llmClient returns the client of the LLM, created on first use
+func (r *Recorder) llmClient() *openai.Client {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.client == nil {
+		r.client = openai.NewClient()
+	}
+	return r.client
+}
+
+// CheckLLM returns an error when the LLM provider does not answer for the model of the summaries and the moderation
+func (r *Recorder) CheckLLM(ctx context.Context) error {
+	if os.Getenv("OPENAI_API_KEY") == "" {
+		return errNoAPIKey
+	}
+	_, err := r.llmClient().Models.Get(ctx, openai.ChatModelGPT4oMini)
+	return err
+}
+
+// summarize asks the LLM to write the meeting summary from the transcript
+func (r *Recorder) summarize(ctx context.Context, utterances []utterance) (string, error) {
+	if len(utterances) == 0 {
+		return "", nil
+	}
+
+	var transcript strings.Builder
+	for _, u := range utterances {
+		transcript.WriteString(u.userID + ": " + u.text + "\n")
+	}
+
+	client := r.llmClient()
+	ctx, span := tracing.Tracer.Start(ctx, tracing.SPAN_LLM_COMPLETION)
+	span.SetAttributes(attribute.String("llm.caller", metrics.LLM_SUMMARY))
+	start := time.Now()
+	completion, err := client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
+			openai.SystemMessage(SUMMARY_PROMPT),
+			openai.UserMessage(transcript.String()),
+		}),
+		Model: openai.F(openai.ChatModelGPT4oMini),
+	})
+	metrics.ObserveLLM(metrics.LLM_SUMMARY, start, err)
+	tracing.End(span, err)
+	if err != nil {
+		return "", err
+	}
+	if len(completion.Choices) == 0 {
+		return "", nil
+	}
+	return completion.Choices[0].Message.Content, nil
+}
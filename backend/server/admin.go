@@ -0,0 +1,235 @@
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"sort"
+	"strings"
+
+	"profanity.com/events"
+	"profanity.com/moderation"
+	"profanity.com/transcription"
+)
+
+// RequireAdmin rejects the requests without the admin token.
+// The admin API only sees the rooms and the participants of this replica.
+func RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if settings.AdminToken == "" {
+			http.Error(w, "Admin API is disabled", http.StatusForbidden)
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(settings.AdminToken)) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "An admin token is required", http.StatusUnauthorized)
+			return
+		}
+		next(w, r)
+	}
+}
+
+// writeJSON writes the value as the JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// adminParticipant describes the participant for the admin API. The caller must hold the lock.
+func (room *Room) adminParticipant(p Participant) AdminParticipant {
+	_, suspended := room.suspended[p.UserID]
+	participant := AdminParticipant{
+		UserID:           p.UserID,
+		Host:             p.Host,
+		JoinedAt:         p.JoinedAt,
+		Connected:        !suspended,
+		Muted:            room.muted[p.UserID],
+		TranscriptionOff: room.transcriptionOff[p.UserID],
+	}
+	if status, ok := transcription.Connections.Get(room.ID, p.UserID); ok {
+		participant.Transcription = &status
+	}
+	return participant
+}
+
+// adminRoom describes the room and its participants for the admin API. The caller must hold the lock.
+func (room *Room) adminRoom() AdminRoom {
+	details := AdminRoom{
+		ID:           room.ID,
+		CreatedAt:    room.CreatedAt,
+		LastActivity: room.LastActivity,
+		Locked:       room.Locked,
+		WaitingRoom:  room.WaitingRoom,
+		ChatPolicy:   room.ChatPolicy,
+		Participants: []AdminParticipant{},
+		Waiting:      []AdminParticipant{},
+	}
+	for _, p := range room.Participants {
+		details.Participants = append(details.Participants, room.adminParticipant(p))
+	}
+	for _, p := range room.Waiting {
+		details.Waiting = append(details.Waiting, room.adminParticipant(p))
+	}
+	return details
+}
+
+// Close removes the room and disconnects all its participants
+func (r *RoomMap) Close(roomID string) bool {
+	r.Mutex.Lock()
+	room, ok := r.Map[roomID]
+	if !ok {
+		r.Mutex.Unlock()
+		return false
+	}
+	delete(r.Map, roomID)
+	for userID := range room.suspended {
+		room.endSuspension(userID)
+	}
+	r.Mutex.Unlock()
+
+	slog.Info("Room closed by an admin", "roomID", roomID, "participants", len(room.Participants))
+	closed, _ := newEnvelope(MESSAGE_ROOM_CLOSED, nil)
+	closed.From = ADMIN_USER
+
+	// Closing the writers ends the read loops of the participants
+	for _, p := range room.Participants {
+		p.writer.sendAndClose(closed)
+		leaveShared(roomID, p.UserID)
+	}
+	for _, p := range room.Waiting {
+		p.writer.sendAndClose(closed)
+	}
+	transcription.Connections.CloseRoom(roomID)
+	r.release(room, events.ROOM_CLOSED)
+	return true
+}
+
+// Kick removes the participant from the room and bans it, as a host would.
+// It returns the participant and whether its connection had already dropped.
+func (r *RoomMap) Kick(roomID string, userID string) (Participant, bool, error) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	room, ok := r.Map[roomID]
+	if !ok {
+		return Participant{}, false, ErrRoomNotFound
+	}
+	p, _, found := room.find(userID)
+	if !found {
+		return Participant{}, false, ErrParticipantNotFound
+	}
+
+	room.banned[userID] = true
+	moderation.Participants.Kick(roomID, userID)
+	_, suspended := room.suspended[userID]
+	return p, suspended, nil
+}
+
+// AdminRoomsHandler lists the rooms
+func AdminRoomsHandler(w http.ResponseWriter, r *http.Request) {
+	AllRooms.Mutex.RLock()
+	rooms := []AdminRoomSummary{}
+	for _, room := range AllRooms.Map {
+		rooms = append(rooms, AdminRoomSummary{
+			ID:           room.ID,
+			CreatedAt:    room.CreatedAt,
+			LastActivity: room.LastActivity,
+			Participants: len(room.Participants),
+			Waiting:      len(room.Waiting),
+			Locked:       room.Locked,
+		})
+	}
+	AllRooms.Mutex.RUnlock()
+
+	sort.Slice(rooms, func(i, j int) bool { return rooms[i].CreatedAt.Before(rooms[j].CreatedAt) })
+	writeJSON(w, http.StatusOK, rooms)
+}
+
+// AdminRoomHandler returns a room with its participants
+func AdminRoomHandler(w http.ResponseWriter, r *http.Request) {
+	AllRooms.Mutex.RLock()
+	room, ok := AllRooms.Map[r.PathValue("id")]
+	var details AdminRoom
+	if ok {
+		details = room.adminRoom()
+	}
+	AllRooms.Mutex.RUnlock()
+
+	if !ok {
+		http.Error(w, ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, details)
+}
+
+// AdminParticipantHandler returns a participant with the status and the stats of its transcription
+func AdminParticipantHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, userID := r.PathValue("id"), r.PathValue("userID")
+
+	AllRooms.Mutex.RLock()
+	room, ok := AllRooms.Map[roomID]
+	var participant AdminParticipant
+	var found bool
+	if ok {
+		var p Participant
+		if p, _, found = room.find(userID); found {
+			participant = room.adminParticipant(p)
+		}
+	}
+	AllRooms.Mutex.RUnlock()
+
+	switch {
+	case !ok:
+		http.Error(w, ErrRoomNotFound.Error(), http.StatusNotFound)
+	case !found:
+		http.Error(w, ErrParticipantNotFound.Error(), http.StatusNotFound)
+	default:
+		writeJSON(w, http.StatusOK, participant)
+	}
+}
+
+// AdminCloseRoomHandler closes a room and disconnects its participants
+func AdminCloseRoomHandler(w http.ResponseWriter, r *http.Request) {
+	if !AllRooms.Close(r.PathValue("id")) {
+		http.Error(w, ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// AdminKickHandler removes a participant from a room and stops its transcription
+func AdminKickHandler(w http.ResponseWriter, r *http.Request) {
+	roomID, userID := r.PathValue("id"), r.PathValue("userID")
+
+	p, suspended, err := AllRooms.Kick(roomID, userID)
+	if errors.Is(err, ErrRoomNotFound) || errors.Is(err, ErrParticipantNotFound) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	// The ban is enforced by every replica, the participant cannot rejoin through another one
+	shareBan(roomID, userID)
+	slog.Info("Participant kicked by an admin", "roomID", roomID, "userID", userID)
+	events.Publish(events.ROOM_MODERATED, roomID, ADMIN_USER, map[string]interface{}{
+		"command": MESSAGE_KICK,
+		"target":  userID,
+	})
+
+	kicked, _ := newEnvelope(MESSAGE_KICKED, nil)
+	kicked.From = ADMIN_USER
+	p.writer.sendAndClose(kicked)
+	transcription.Connections.Close(roomID, userID)
+
+	// A dropped participant has no read loop left to remove it
+	if suspended {
+		leaveRoom(roomID, userID, p.Conn)
+	} else {
+		broadcastState(roomID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
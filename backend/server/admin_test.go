@@ -0,0 +1,105 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"profanity.com/invite"
+)
+
+// adminRequest sends a request to the admin API with the given token
+func adminRequest(t *testing.T, method string, url string, token string) *http.Response {
+	t.Helper()
+
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// TestAdminAPI tests that the admin token protects the admin API, and that an admin can inspect rooms and kick participants
+func TestAdminAPI(t *testing.T) {
+	AllRooms.Init()
+	previous := settings
+	settings.AdminToken = "secret"
+	t.Cleanup(func() { settings = previous })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/join", JoinRoomRequestHandler)
+	mux.HandleFunc("GET /v1/admin/rooms", RequireAdmin(AdminRoomsHandler))
+	mux.HandleFunc("GET /v1/admin/rooms/{id}", RequireAdmin(AdminRoomHandler))
+	mux.HandleFunc("DELETE /v1/admin/rooms/{id}", RequireAdmin(AdminCloseRoomHandler))
+	mux.HandleFunc("DELETE /v1/admin/rooms/{id}/participants/{userID}", RequireAdmin(AdminKickHandler))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := dial(t, srv.URL, roomID, "alice", invite.ROLE_HOST)
+	bob := dial(t, srv.URL, roomID, "bob", invite.ROLE_GUEST)
+	expectMessage(t, alice, MESSAGE_PARTICIPANT_JOINED)
+
+	for _, token := range []string{"", "wrong"} {
+		if resp := adminRequest(t, http.MethodGet, srv.URL+"/v1/admin/rooms", token); resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected 401 with token %q, got %d", token, resp.StatusCode)
+		}
+	}
+
+	var rooms []AdminRoomSummary
+	json.NewDecoder(adminRequest(t, http.MethodGet, srv.URL+"/v1/admin/rooms", "secret").Body).Decode(&rooms)
+	if len(rooms) != 1 || rooms[0].ID != roomID || rooms[0].Participants != 2 {
+		t.Errorf("expected the room with 2 participants, got %+v", rooms)
+	}
+
+	var room AdminRoom
+	json.NewDecoder(adminRequest(t, http.MethodGet, srv.URL+"/v1/admin/rooms/"+roomID, "secret").Body).Decode(&room)
+	if len(room.Participants) != 2 || !room.Participants[0].Host || room.Participants[1].JoinedAt.IsZero() {
+		t.Errorf("expected alice hosting and bob with a join time, got %+v", room.Participants)
+	}
+
+	resp := adminRequest(t, http.MethodDelete, srv.URL+"/v1/admin/rooms/"+roomID+"/participants/bob", "secret")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the kick to succeed, got %d", resp.StatusCode)
+	}
+	if kicked := expectMessage(t, bob, MESSAGE_KICKED); kicked.From != ADMIN_USER {
+		t.Errorf("expected the kick to come from the admin, got %+v", kicked)
+	}
+	expectMessage(t, alice, MESSAGE_PARTICIPANT_LEFT)
+	if err := AllRooms.CanJoin(roomID, "bob", false); err != ErrBanned {
+		t.Errorf("expected bob to be banned, got %v", err)
+	}
+	if state, err := bus.State(context.Background(), roomID); err != nil || !slices.Contains(state.Banned, "bob") {
+		t.Errorf("expected the ban of bob to be shared with the other replicas, got %+v", state)
+	}
+
+	resp = adminRequest(t, http.MethodDelete, srv.URL+"/v1/admin/rooms/"+roomID, "secret")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected the room to close, got %d", resp.StatusCode)
+	}
+	expectMessage(t, alice, MESSAGE_ROOM_CLOSED)
+	if resp := adminRequest(t, http.MethodGet, srv.URL+"/v1/admin/rooms/"+roomID, "secret"); resp.StatusCode != http.StatusNotFound {
+		t.Errorf("expected the closed room to be gone, got %d", resp.StatusCode)
+	}
+
+	// The connection of alice is closed by the server
+	alice.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := alice.ReadMessage(); err == nil {
+		t.Error("expected the connection of alice to be closed")
+	}
+}
@@ -0,0 +1,147 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/gorilla/websocket"
+	"profanity.com/auth"
+	"profanity.com/invite"
+)
+
+// accessToken signs an access token of the user in the tenant
+func accessToken(t *testing.T, userID string, tenant string, role string) string {
+	t.Helper()
+
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
+		"sub":    userID,
+		"tenant": tenant,
+		"role":   role,
+		"exp":    time.Now().Add(time.Hour).Unix(),
+	}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// TestAuthentication tests that the rooms are created and joined under the verified identities, within their tenant,
+// and that the host token is only accepted from its holder
+func TestAuthentication(t *testing.T) {
+	AllRooms.Init()
+	creationLimits = &ipLimiters{limiters: make(map[string]*ipLimiter)}
+	auth.Users = auth.NewSecretAuthenticator([]byte("secret"), auth.Options{
+		UserClaim:   auth.DEFAULT_USER_CLAIM,
+		TenantClaim: auth.DEFAULT_TENANT_CLAIM,
+		RoleClaim:   auth.DEFAULT_ROLE_CLAIM,
+	})
+	t.Cleanup(func() { auth.Users = nil })
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", CreateRoomRequestHandler)
+	mux.HandleFunc("/join", JoinRoomRequestHandler)
+	mux.HandleFunc("POST /v1/rooms/{id}/redeem", RedeemInviteRequestHandler)
+	mux.HandleFunc("GET /v1/rooms/{id}/report", RoomReportRequestHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	create := func(token string) *http.Response {
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/create", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+	if resp := create(""); resp.StatusCode != http.StatusUnauthorized {
+		t.Errorf("expected an anonymous creation to be refused, got %d", resp.StatusCode)
+	}
+	if resp := create(accessToken(t, "guest", "acme", "guest")); resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected a guest creation to be refused, got %d", resp.StatusCode)
+	}
+
+	var room RoomCreationResponse
+	json.NewDecoder(create(accessToken(t, "alice", "acme", "member")).Body).Decode(&room)
+	if room.UserID != "alice" {
+		t.Fatalf("expected alice to host the room, got %+v", room)
+	}
+
+	join := func(inviteToken string, accessToken string) (*websocket.Conn, int) {
+		url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/join?token=" + inviteToken
+		if accessToken != "" {
+			url += "&access_token=" + accessToken
+		}
+		conn, resp, err := websocket.DefaultDialer.Dial(url, nil)
+		if err != nil {
+			return nil, resp.StatusCode
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn, http.StatusSwitchingProtocols
+	}
+
+	redeem := func(accessToken string) (InviteResponse, int) {
+		var guest InviteResponse
+		req, _ := http.NewRequest(http.MethodPost, srv.URL+"/v1/rooms/"+room.RoomID+"/redeem?token="+room.InviteToken, nil)
+		req.Header.Set("Authorization", "Bearer "+accessToken)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&guest)
+		return guest, resp.StatusCode
+	}
+
+	if _, status := join(room.HostToken, ""); status != http.StatusUnauthorized {
+		t.Errorf("expected an anonymous join to be refused before the upgrade, got %d", status)
+	}
+	if _, status := join(room.InviteToken, accessToken(t, "bob", "acme", "guest")); status != http.StatusUnauthorized {
+		t.Errorf("expected the shared invite to be refused before its redemption, got %d", status)
+	}
+	if _, status := redeem(accessToken(t, "eve", "other", "member")); status != http.StatusForbidden {
+		t.Errorf("expected a user of another tenant to be refused, got %d", status)
+	}
+
+	// The host token of alice is hers only, a user of the same tenant cannot host with it or read the report
+	mallory := accessToken(t, "mallory", "acme", "member")
+	if _, status := join(room.HostToken, mallory); status != http.StatusForbidden {
+		t.Errorf("expected the host token of another user to be refused, got %d", status)
+	}
+	req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/rooms/"+room.RoomID+"/report", nil)
+	req.Header.Set("Authorization", "Bearer "+mallory)
+	req.Header.Set(invite.HEADER_INVITE_TOKEN, room.HostToken)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Errorf("expected the report to be refused with the host token of another user, got %d", resp.StatusCode)
+	}
+
+	alice, _ := join(room.HostToken, accessToken(t, "alice", "acme", "member"))
+	if alice == nil {
+		t.Fatal("expected alice to join")
+	}
+	guest, status := redeem(accessToken(t, "bob", "acme", "guest"))
+	if status != http.StatusCreated {
+		t.Fatalf("expected bob to redeem the invite, got %d", status)
+	}
+	if bob, _ := join(guest.Token, accessToken(t, "bob", "acme", "guest")); bob == nil {
+		t.Fatal("expected bob to join")
+	}
+
+	// The participant is the user of the access token, not the user of the invite
+	if joined := expectMessage(t, alice, MESSAGE_PARTICIPANT_JOINED); joined.From != "bob" {
+		t.Errorf("expected bob to join under the verified identity, got %q", joined.From)
+	}
+}
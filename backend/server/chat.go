@@ -0,0 +1,209 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"profanity.com/classifier"
+	"profanity.com/events"
+	"profanity.com/metrics"
+	"profanity.com/report"
+)
+
+// validChatPolicy returns true if the policy is known
+func validChatPolicy(policy string) bool {
+	return policy == CHAT_POLICY_ALLOW || policy == CHAT_POLICY_REDACT || policy == CHAT_POLICY_HOLD
+}
+
+// keepChat adds a delivered chat message to the chat history of the room. The caller must hold the lock.
+func (room *Room) keepChat(message Envelope) {
+	var chat ChatMessage
+	if err := json.Unmarshal(message.Payload, &chat); err != nil {
+		slog.Error("Invalid chat message", "roomID", room.ID, "err", err)
+		return
+	}
+
+	room.chat = append(room.chat, chat)
+	if overflow := len(room.chat) - MAX_CHAT_HISTORY; overflow > 0 {
+		room.chat = room.chat[overflow:]
+	}
+}
+
+// ChatPolicy returns what happens to the flagged chat messages of the room
+func (r *RoomMap) ChatPolicy(roomID string) string {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	if room, ok := r.Map[roomID]; ok {
+		return room.ChatPolicy
+	}
+	return settings.ChatPolicy
+}
+
+// ChatHistory returns the chat messages delivered in the room
+func (r *RoomMap) ChatHistory(roomID string) []ChatMessage {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	room, ok := r.Map[roomID]
+	if !ok {
+		return []ChatMessage{}
+	}
+	return append([]ChatMessage{}, room.chat...)
+}
+
+// Hold keeps a flagged chat message until a host releases or rejects it.
+// It returns false if the room is gone, or has too many messages waiting for a host already.
+func (r *RoomMap) Hold(roomID string, chat ChatMessage) bool {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	room, ok := r.Map[roomID]
+	if !ok || len(room.held) >= MAX_HELD_CHATS {
+		return false
+	}
+	room.held[chat.ID] = chat
+	return true
+}
+
+// newChatQueue starts the classification of the chat messages of the participant
+func newChatQueue(roomID string, userID string) *chatQueue {
+	q := &chatQueue{
+		roomID:  roomID,
+		userID:  userID,
+		pending: make(chan pendingChat, CHAT_QUEUE_SIZE),
+		done:    make(chan struct{}),
+	}
+	go q.run()
+	return q
+}
+
+// push queues the chat message, it returns false when the participant has too many messages waiting already
+func (q *chatQueue) push(chat pendingChat) bool {
+	select {
+	case q.pending <- chat:
+		return true
+	default:
+		return false
+	}
+}
+
+// close returns once the queued messages are delivered, the last words of a leaving participant are not lost
+func (q *chatQueue) close() {
+	close(q.pending)
+	<-q.done
+}
+
+// run classifies the queued messages one at a time, they are delivered in the order they were sent
+func (q *chatQueue) run() {
+	defer close(q.done)
+	for chat := range q.pending {
+		moderateChat(q.roomID, q.userID, chat)
+	}
+}
+
+// handleChat checks a chat message and queues it for its classification, off the read loop of the sender
+func handleChat(roomID string, userID string, message Envelope, chats *chatQueue) *protocolError {
+	var payload ChatPayload
+	if err := decodePayload(message.Payload, &payload); err != nil {
+		return err
+	}
+	if !chats.push(pendingChat{id: message.ID, text: payload.Text}) {
+		return newProtocolError(ERROR_RATE_LIMITED, "too many chat messages waiting for moderation, slow down")
+	}
+	return nil
+}
+
+// moderateChat classifies a chat message and applies the chat policy of the room before delivering it.
+// The message goes to every participant, the sender included, as the server may have redacted it.
+func moderateChat(roomID string, userID string, pending pendingChat) {
+	chat := ChatMessage{
+		ID:     uuid.New().String(),
+		From:   userID,
+		Text:   pending.text,
+		SentAt: time.Now(),
+	}
+	policy := AllRooms.ChatPolicy(roomID)
+
+	score, err := classifier.Profanity.Classify(context.Background(), pending.text)
+	flagged := classifier.IsFlagged(score)
+	if err != nil {
+		// A message that could not be scored goes through, unless a host reviews the doubtful messages anyway
+		slog.Error("Error classifying the chat message", "roomID", roomID, "userID", userID, "err", err)
+		flagged = policy == CHAT_POLICY_HOLD
+	} else if flagged {
+		flagID := report.Meetings.AddFlag(roomID, userID, pending.text, score)
+		metrics.Flag(events.SOURCE_CHAT, score)
+		events.Publish(events.USER_FLAGGED, roomID, userID, map[string]interface{}{
+			"source":    events.SOURCE_CHAT,
+			"flagID":    flagID,
+			"score":     score,
+			"policy":    policy,
+			"messageID": chat.ID,
+		})
+	}
+	chat.ProfanityScore = score
+
+	switch {
+	case !flagged || policy == CHAT_POLICY_ALLOW:
+		deliverChat(roomID, pending.id, chat)
+
+	case policy == CHAT_POLICY_REDACT:
+		chat.Text = REDACTED_TEXT
+		chat.Redacted = true
+		deliverChat(roomID, pending.id, chat)
+
+	case policy == CHAT_POLICY_HOLD:
+		if !AllRooms.Hold(roomID, chat) {
+			slog.Warn("Chat message rejected, too many messages held", "roomID", roomID, "userID", userID, "messageID", chat.ID)
+			rejected, _ := newEnvelope(MESSAGE_CHAT_REJECTED, ChatMessage{ID: chat.ID, From: userID})
+			rejected.ID = pending.id
+			AllRooms.SendTo(roomID, userID, rejected)
+			return
+		}
+		slog.Info("Chat message held", "roomID", roomID, "userID", userID, "messageID", chat.ID, "score", score)
+
+		held, err := newEnvelope(MESSAGE_CHAT_HELD, chat)
+		if err != nil {
+			slog.Error("Error marshaling held chat", "err", err)
+			return
+		}
+		held.ID = pending.id
+		held.From = userID
+		for _, p := range AllRooms.Get(roomID) {
+			if p.Host || p.UserID == userID {
+				p.writer.send(held)
+			}
+		}
+	}
+}
+
+// deliverChat sends the chat message to every participant of the room and keeps it in the history
+func deliverChat(roomID string, id string, chat ChatMessage) {
+	message, err := newEnvelope(MESSAGE_CHAT, chat)
+	if err != nil {
+		slog.Error("Error marshaling chat", "err", err)
+		return
+	}
+	message.ID = id
+	message.From = chat.From
+	AllRooms.Broadcast(broadcastMsg{Message: message, RoomID: roomID})
+}
+
+// sendChatHistory sends the chat of the room to a participant joining late
+func sendChatHistory(roomID string, userID string) {
+	history := AllRooms.ChatHistory(roomID)
+	if len(history) == 0 {
+		return
+	}
+
+	message, err := newEnvelope(MESSAGE_CHAT_HISTORY, ChatHistoryPayload{Messages: history})
+	if err != nil {
+		slog.Error("Error marshaling chat history", "err", err)
+		return
+	}
+	AllRooms.SendTo(roomID, userID, message)
+}
@@ -0,0 +1,97 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/invite"
+)
+
+// expectChat reads until a chat message of the given type arrives
+func expectChat(t *testing.T, conn *websocket.Conn, msgType string) ChatMessage {
+	t.Helper()
+
+	var chat ChatMessage
+	if err := json.Unmarshal(expectMessage(t, conn, msgType).Payload, &chat); err != nil {
+		t.Fatal(err)
+	}
+	return chat
+}
+
+// TestChatPolicy tests that flagged chat messages are redacted or held, and that late joiners receive the chat
+func TestChatPolicy(t *testing.T) {
+	AllRooms.Init()
+	srv := httptest.NewServer(http.HandlerFunc(JoinRoomRequestHandler))
+	defer srv.Close()
+
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := dial(t, srv.URL, roomID, "host", invite.ROLE_HOST)
+	guest := dial(t, srv.URL, roomID, "guest", invite.ROLE_GUEST)
+	expectMessage(t, host, MESSAGE_PARTICIPANT_JOINED)
+
+	// The default policy redacts the flagged messages, for the sender too
+	guest.WriteJSON(Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"darn it"}`)})
+	chat := expectChat(t, host, MESSAGE_CHAT)
+	if !chat.Redacted || chat.Text != REDACTED_TEXT || chat.From != "guest" {
+		t.Errorf("expected a redacted message from guest, got %+v", chat)
+	}
+	expectMessage(t, guest, MESSAGE_CHAT)
+
+	guest.WriteJSON(Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"hello"}`)})
+	chat = expectChat(t, host, MESSAGE_CHAT)
+	if chat.Redacted || chat.Text != "hello" {
+		t.Errorf("expected a clean message to be delivered as is, got %+v", chat)
+	}
+
+	// A message the classifier could not score goes through
+	guest.WriteJSON(Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"unscored"}`)})
+	if chat = expectChat(t, host, MESSAGE_CHAT); chat.Redacted || chat.Text != "unscored" {
+		t.Errorf("expected the unscored message to be delivered as is, got %+v", chat)
+	}
+
+	late := dial(t, srv.URL, roomID, "late", invite.ROLE_GUEST)
+	var history ChatHistoryPayload
+	json.Unmarshal(expectMessage(t, late, MESSAGE_CHAT_HISTORY).Payload, &history)
+	if len(history.Messages) != 3 || history.Messages[0].Text != REDACTED_TEXT || history.Messages[1].Text != "hello" {
+		t.Errorf("expected the late joiner to receive the chat, got %+v", history.Messages)
+	}
+
+	// Under the hold policy a host decides
+	host.WriteJSON(Envelope{Type: MESSAGE_SET_CHAT_POLICY, Payload: []byte(`{"policy":"hold"}`)})
+	expectMessage(t, guest, MESSAGE_ROOM_STATE)
+	guest.WriteJSON(Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"darn"}`)})
+	chat = expectChat(t, host, MESSAGE_CHAT_HELD)
+	expectMessage(t, guest, MESSAGE_CHAT_HELD)
+
+	host.WriteJSON(Envelope{Type: MESSAGE_RELEASE_CHAT, Payload: []byte(`{"messageID":"` + chat.ID + `","enabled":true}`)})
+	chat = expectChat(t, late, MESSAGE_CHAT)
+	if chat.Text != "darn" || chat.Redacted {
+		t.Errorf("expected the released message to be delivered, got %+v", chat)
+	}
+}
+
+// TestHoldLimit tests that a room keeps a bounded number of messages waiting for a host
+func TestHoldLimit(t *testing.T) {
+	AllRooms.Init()
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < MAX_HELD_CHATS; i++ {
+		if !AllRooms.Hold(roomID, ChatMessage{ID: strconv.Itoa(i)}) {
+			t.Fatalf("expected message %d to be held", i)
+		}
+	}
+	if AllRooms.Hold(roomID, ChatMessage{ID: "overflow"}) {
+		t.Error("expected the room to refuse a message over the limit")
+	}
+}
@@ -0,0 +1,300 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"maps"
+	"slices"
+	"sync"
+
+	"profanity.com/cluster"
+	"profanity.com/events"
+	"profanity.com/moderation"
+	"profanity.com/report"
+)
+
+var (
+	// outbox queues the messages relayed to the other replicas, published by a single goroutine
+	outbox      = make(chan cluster.Message, RELAY_QUEUE_SIZE)
+	startOutbox sync.Once
+)
+
+// sharedContext bounds the calls to the bus shared by the replicas
+func sharedContext() (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.Background(), cluster.OPERATION_TIMEOUT)
+}
+
+// Open makes the room available on this replica, when it was created on another one
+func (r *RoomMap) Open(roomID string) error {
+	r.Mutex.RLock()
+	_, ok := r.Map[roomID]
+	r.Mutex.RUnlock()
+	if ok {
+		return nil
+	}
+
+	ctx, cancel := sharedContext()
+	defer cancel()
+	info, exists, err := cluster.Rooms.GetRoom(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrRoomNotFound
+	}
+
+	r.Mutex.Lock()
+	if _, ok := r.Map[roomID]; ok {
+		r.Mutex.Unlock()
+		return nil
+	}
+	room := r.newRoom(roomID)
+	room.passwordHash = info.PasswordHash
+	room.joined = true
+	r.Mutex.Unlock()
+
+	slog.Info("Room opened from another replica", "roomID", roomID)
+	return r.subscribe(roomID)
+}
+
+// subscribe relays the messages published by the other replicas to the participants of this one
+func (r *RoomMap) subscribe(roomID string) error {
+	unsubscribe, err := cluster.Rooms.Subscribe(roomID, r.relay)
+	if err != nil {
+		slog.Error("Subscription to the room failed", "roomID", roomID, "err", err)
+		return err
+	}
+
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	room, ok := r.Map[roomID]
+	if !ok {
+		// The room closed in the meantime
+		unsubscribe()
+		return nil
+	}
+	room.unsubscribe = unsubscribe
+	return nil
+}
+
+// relay delivers a message published by another replica to the local participants
+func (r *RoomMap) relay(msg cluster.Message) {
+	var message Envelope
+	if err := json.Unmarshal(msg.Payload, &message); err != nil {
+		slog.Error("Invalid relayed message", "roomID", msg.RoomID, "err", err)
+		return
+	}
+
+	// The arrivals and departures on the other replicas keep the known members up to date
+	switch message.Type {
+	case MESSAGE_PARTICIPANT_JOINED:
+		r.setMember(msg.RoomID, msg.From, true)
+	case MESSAGE_PARTICIPANT_LEFT:
+		r.setMember(msg.RoomID, msg.From, false)
+	}
+
+	r.Broadcast(broadcastMsg{
+		Message: message,
+		RoomID:  msg.RoomID,
+		UserID:  msg.From,
+		To:      msg.To,
+		Local:   true,
+	})
+}
+
+// publish queues the message for the participants of the other replicas, the sender does not wait for the bus
+func publish(msg broadcastMsg) {
+	payload, err := json.Marshal(msg.Message)
+	if err != nil {
+		slog.Error("Error marshaling relayed message", "err", err)
+		return
+	}
+
+	startOutbox.Do(func() { go runOutbox() })
+	select {
+	case outbox <- cluster.Message{RoomID: msg.RoomID, From: msg.UserID, To: msg.To, Payload: payload}:
+	default:
+		slog.Error("Relay queue is full, the message does not reach the other replicas", "roomID", msg.RoomID)
+	}
+}
+
+// runOutbox publishes the queued messages one at a time, in the order they were sent
+func runOutbox() {
+	for message := range outbox {
+		ctx, cancel := sharedContext()
+		if err := cluster.Rooms.Publish(ctx, message); err != nil {
+			slog.Error("Message could not reach the other replicas", "roomID", message.RoomID, "err", err)
+		}
+		cancel()
+	}
+}
+
+// Members returns the participants of the room on every replica, or on this one when the bus is unreachable.
+// It reads the bus, the known members of the room are refreshed with its answer.
+func (r *RoomMap) Members(roomID string) []string {
+	ctx, cancel := sharedContext()
+	defer cancel()
+
+	members, err := cluster.Rooms.Members(ctx, roomID)
+	if err != nil {
+		slog.Error("Members of the room are unavailable", "roomID", roomID, "err", err)
+		return r.Participants(roomID)
+	}
+
+	r.Mutex.Lock()
+	if room, ok := r.Map[roomID]; ok {
+		room.members = make(map[string]bool, len(members))
+		for _, userID := range members {
+			room.members[userID] = true
+		}
+	}
+	r.Mutex.Unlock()
+	return members
+}
+
+// knownMembers returns the participants of the room on every replica as last known, without waiting for the bus.
+// The bus is only read the first time.
+func (r *RoomMap) knownMembers(roomID string) []string {
+	r.Mutex.RLock()
+	room, ok := r.Map[roomID]
+	if !ok || room.members == nil {
+		r.Mutex.RUnlock()
+		return r.Members(roomID)
+	}
+
+	known := maps.Clone(room.members)
+	for _, p := range room.Participants {
+		known[p.UserID] = true
+	}
+	r.Mutex.RUnlock()
+	return sortedKeys(known)
+}
+
+// setMember records an arrival or a departure in the known members of the room
+func (r *RoomMap) setMember(roomID string, userID string, present bool) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	room, ok := r.Map[roomID]
+	if !ok || room.members == nil {
+		return
+	}
+	if present {
+		room.members[userID] = true
+	} else {
+		delete(room.members, userID)
+	}
+}
+
+// IsMember returns true if the user is a participant of the room, on any replica.
+// The bus is read only for a user unknown here, who may have just joined another replica.
+func (r *RoomMap) IsMember(roomID string, userID string) bool {
+	if slices.Contains(r.knownMembers(roomID), userID) {
+		return true
+	}
+	return slices.Contains(r.Members(roomID), userID)
+}
+
+// syncState applies the lock, the waiting room and the bans shared by the replicas to the local room
+func (r *RoomMap) syncState(roomID string) error {
+	ctx, cancel := sharedContext()
+	defer cancel()
+
+	state, err := cluster.Rooms.State(ctx, roomID)
+	if err != nil {
+		return err
+	}
+
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	room, ok := r.Map[roomID]
+	if !ok {
+		return nil
+	}
+	room.Locked = state.Locked
+	room.WaitingRoom = state.WaitingRoom
+	for _, userID := range state.Banned {
+		room.banned[userID] = true
+	}
+	return nil
+}
+
+// shareFlag saves the moderation flag of the room for the other replicas
+func shareFlag(roomID string, flag string, enabled bool) error {
+	ctx, cancel := sharedContext()
+	defer cancel()
+
+	return cluster.Rooms.SetFlag(ctx, roomID, flag, enabled)
+}
+
+// shareBan saves the ban of the user for the other replicas
+func shareBan(roomID string, userID string) {
+	ctx, cancel := sharedContext()
+	defer cancel()
+
+	if err := cluster.Rooms.Ban(ctx, roomID, userID); err != nil {
+		slog.Error("Ban could not be shared", "roomID", roomID, "userID", userID, "err", err)
+	}
+}
+
+// joinShared records the presence of the user for the other replicas
+func joinShared(roomID string, userID string) {
+	ctx, cancel := sharedContext()
+	defer cancel()
+
+	if err := cluster.Rooms.Join(ctx, roomID, userID); err != nil {
+		slog.Error("Presence could not be shared", "roomID", roomID, "userID", userID, "err", err)
+	}
+	AllRooms.setMember(roomID, userID, true)
+}
+
+// leaveShared removes the presence of the user for the other replicas
+func leaveShared(roomID string, userID string) {
+	ctx, cancel := sharedContext()
+	defer cancel()
+
+	if err := cluster.Rooms.Leave(ctx, roomID, userID); err != nil {
+		slog.Error("Departure could not be shared", "roomID", roomID, "userID", userID, "err", err)
+	}
+	AllRooms.setMember(roomID, userID, false)
+}
+
+// release lets go of a room nobody uses on this replica anymore.
+// The room is closed once no participant is left on any replica.
+func (r *RoomMap) release(room *Room, eventType string) {
+	if room.unsubscribe != nil {
+		room.unsubscribe()
+	}
+	moderation.Participants.ClearRoom(room.ID)
+
+	ctx, cancel := sharedContext()
+	defer cancel()
+
+	// Without the members, the room may still be used elsewhere. It is kept, and expires with its keys.
+	members, err := cluster.Rooms.Members(ctx, room.ID)
+	if err != nil {
+		slog.Error("Members of the room are unavailable, keeping it", "roomID", room.ID, "err", err)
+		return
+	}
+	if len(members) > 0 {
+		slog.Info("Room is still open on another replica", "roomID", room.ID, "members", len(members))
+		return
+	}
+
+	if err := cluster.Rooms.DeleteRoom(ctx, room.ID); err != nil {
+		slog.Error("Room could not be deleted from the bus", "roomID", room.ID, "err", err)
+	}
+	events.Publish(eventType, room.ID, "", map[string]interface{}{"participants": len(room.Participants)})
+
+	// Generate the meeting report in the background, the summary may take a while
+	if room.joined {
+		reports.Add(1)
+		go func() {
+			defer reports.Done()
+			report.Meetings.Close(context.Background(), room.ID)
+		}()
+	}
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"profanity.com/cluster"
+	"profanity.com/events"
+)
+
+// testBus is the bus of the tests, set once in TestMain. Its members can be made unreachable, like behind a
+// network split.
+type testBus struct {
+	*cluster.Local
+	unreachable atomic.Bool
+}
+
+var bus = &testBus{Local: cluster.NewLocal()}
+
+func (b *testBus) Members(ctx context.Context, roomID string) ([]string, error) {
+	if b.unreachable.Load() {
+		return nil, errors.New("bus unreachable")
+	}
+	return b.Local.Members(ctx, roomID)
+}
+
+func (b *testBus) Subscribe(roomID string, handler func(cluster.Message)) (func(), error) {
+	if b.unreachable.Load() {
+		return nil, errors.New("bus unreachable")
+	}
+	return b.Local.Subscribe(roomID, handler)
+}
+
+// TestCreateUnsubscribedRoom tests that a room which cannot receive the messages of the other replicas is
+// not left behind
+func TestCreateUnsubscribedRoom(t *testing.T) {
+	AllRooms.Init()
+	bus.unreachable.Store(true)
+	t.Cleanup(func() { bus.unreachable.Store(false) })
+
+	if _, err := AllRooms.CreateRoom(""); err == nil {
+		t.Fatal("expected the creation to fail")
+	}
+	AllRooms.Mutex.RLock()
+	defer AllRooms.Mutex.RUnlock()
+	if len(AllRooms.Map) != 0 {
+		t.Errorf("expected no room left behind, got %d", len(AllRooms.Map))
+	}
+}
+
+// TestSharedModeration tests that the bans of another replica are enforced here, and that a room whose members
+// cannot be read is not deleted for the other replicas
+func TestSharedModeration(t *testing.T) {
+	AllRooms.Init()
+	ctx := context.Background()
+
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Ban(ctx, roomID, "eve")
+	bus.SetFlag(ctx, roomID, cluster.FLAG_WAITING_ROOM, true)
+	if err := AllRooms.CanJoin(roomID, "eve", false); err != ErrBanned {
+		t.Errorf("expected the ban of the other replica to be enforced, got %v", err)
+	}
+	if state, _ := AllRooms.State(roomID); !state.WaitingRoom {
+		t.Error("expected the waiting room of the other replica to be enabled")
+	}
+
+	bus.unreachable.Store(true)
+	t.Cleanup(func() { bus.unreachable.Store(false) })
+	AllRooms.Mutex.Lock()
+	room := AllRooms.Map[roomID]
+	delete(AllRooms.Map, roomID)
+	AllRooms.Mutex.Unlock()
+	AllRooms.release(room, events.ROOM_CLOSED)
+	if _, exists, _ := bus.GetRoom(ctx, roomID); !exists {
+		t.Error("expected the room to be kept while its members are unknown")
+	}
+}
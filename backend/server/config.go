@@ -0,0 +1,178 @@
+package server
+
+import (
+	"log/slog"
+	"time"
+
+	"profanity.com/config"
+)
+
+type Config struct {
+	// Number of messages waiting to be written to a participant
+	OutboundQueueSize int
+	// What to do when the outbound queue of a participant is full: "drop" or "disconnect"
+	OutboundQueuePolicy string
+	// Maximum time spent writing a message to a participant
+	WriteTimeout time.Duration
+	// Interval between two pings sent to a participant (0 disables them)
+	PingInterval time.Duration
+	// Time without any pong or message after which a participant is considered dead
+	PongTimeout time.Duration
+
+	// Maximum number of participants in a room
+	MaxParticipants int
+	// Time after which a room nobody joined expires
+	RoomTTL time.Duration
+	// Time after which a room without any activity expires, even with participants
+	RoomIdleTimeout time.Duration
+	// Interval between two passes of the janitor
+	JanitorInterval time.Duration
+
+	// What to do when a user connects twice to a room: "reject" the new connection or "replace" the old one
+	DuplicateSessionPolicy string
+	// What happens to the flagged chat messages of a new room: "allow", "redact" or "hold"
+	ChatPolicy string
+
+	// Time during which the slot of a dropped participant is kept for it to resume
+	ResumeGracePeriod time.Duration
+	// Number of room messages kept to be replayed on resume, below OutboundQueueSize
+	ResumeHistorySize int
+
+	// Bearer token of the admin API, which is disabled when empty
+	AdminToken string
+
+	// Token buckets: sustained rate per second (0 disables the limit) and burst
+	CreateRate   float64
+	CreateBurst  int
+	MessageRate  float64
+	MessageBurst int
+	ChatRate     float64
+	ChatBurst    int
+	EmojiRate    float64
+	EmojiBurst   int
+	// Number of rate limited messages within the strike window after which a client is disconnected
+	RateLimitStrikes      int
+	RateLimitStrikeWindow time.Duration
+	// Whether the client IP is read from the X-Forwarded-For header set by the reverse proxy
+	TrustForwardedFor bool
+
+	// Delay the participants wait for before reconnecting when the server shuts down
+	ShutdownReconnectDelay time.Duration
+}
+
+var settings = defaultConfig()
+
+// defaultConfig returns the configuration used when the environment sets nothing
+func defaultConfig() Config {
+	return Config{
+		OutboundQueueSize:   64,
+		OutboundQueuePolicy: QUEUE_POLICY_DISCONNECT,
+		WriteTimeout:        5 * time.Second,
+		PingInterval:        15 * time.Second,
+		PongTimeout:         45 * time.Second,
+
+		MaxParticipants: 8,
+		RoomTTL:         time.Hour,
+		RoomIdleTimeout: 12 * time.Hour,
+		JanitorInterval: time.Minute,
+
+		DuplicateSessionPolicy: DUPLICATE_POLICY_REPLACE,
+		ChatPolicy:             CHAT_POLICY_REDACT,
+
+		ResumeGracePeriod: 30 * time.Second,
+		ResumeHistorySize: 32,
+
+		CreateRate:            0.2,
+		CreateBurst:           5,
+		MessageRate:           20,
+		MessageBurst:          100,
+		ChatRate:              1,
+		ChatBurst:             5,
+		EmojiRate:             2,
+		EmojiBurst:            10,
+		RateLimitStrikes:      20,
+		RateLimitStrikeWindow: time.Minute,
+
+		ShutdownReconnectDelay: 2 * time.Second,
+	}
+}
+
+// LoadConfig reads the server configuration from the environment
+func LoadConfig() {
+	defaults := defaultConfig()
+
+	settings = Config{
+		OutboundQueueSize:   config.Int("OUTBOUND_QUEUE_SIZE", defaults.OutboundQueueSize),
+		OutboundQueuePolicy: config.String("OUTBOUND_QUEUE_POLICY", defaults.OutboundQueuePolicy),
+		WriteTimeout:        config.Duration("WRITE_TIMEOUT", defaults.WriteTimeout),
+		PingInterval:        config.Duration("PING_INTERVAL", defaults.PingInterval),
+		PongTimeout:         config.Duration("PONG_TIMEOUT", defaults.PongTimeout),
+
+		MaxParticipants: config.Int("MAX_PARTICIPANTS", defaults.MaxParticipants),
+		RoomTTL:         config.Duration("ROOM_TTL", defaults.RoomTTL),
+		RoomIdleTimeout: config.Duration("ROOM_IDLE_TIMEOUT", defaults.RoomIdleTimeout),
+		JanitorInterval: config.Duration("JANITOR_INTERVAL", defaults.JanitorInterval),
+
+		DuplicateSessionPolicy: config.String("DUPLICATE_SESSION_POLICY", defaults.DuplicateSessionPolicy),
+		ChatPolicy:             config.String("CHAT_POLICY", defaults.ChatPolicy),
+
+		ResumeGracePeriod: config.Duration("RESUME_GRACE_PERIOD", defaults.ResumeGracePeriod),
+		ResumeHistorySize: config.Int("RESUME_HISTORY_SIZE", defaults.ResumeHistorySize),
+
+		AdminToken: config.String("ADMIN_TOKEN", defaults.AdminToken),
+
+		CreateRate:            config.Float("CREATE_RATE", defaults.CreateRate),
+		CreateBurst:           config.Int("CREATE_BURST", defaults.CreateBurst),
+		MessageRate:           config.Float("MESSAGE_RATE", defaults.MessageRate),
+		MessageBurst:          config.Int("MESSAGE_BURST", defaults.MessageBurst),
+		ChatRate:              config.Float("CHAT_RATE", defaults.ChatRate),
+		ChatBurst:             config.Int("CHAT_BURST", defaults.ChatBurst),
+		EmojiRate:             config.Float("EMOJI_RATE", defaults.EmojiRate),
+		EmojiBurst:            config.Int("EMOJI_BURST", defaults.EmojiBurst),
+		RateLimitStrikes:      config.Int("RATE_LIMIT_STRIKES", defaults.RateLimitStrikes),
+		RateLimitStrikeWindow: config.Duration("RATE_LIMIT_STRIKE_WINDOW", defaults.RateLimitStrikeWindow),
+		TrustForwardedFor:     config.Bool("TRUST_FORWARDED_FOR", defaults.TrustForwardedFor),
+
+		ShutdownReconnectDelay: config.Duration("SHUTDOWN_RECONNECT_DELAY", defaults.ShutdownReconnectDelay),
+	}
+
+	if settings.OutboundQueuePolicy != QUEUE_POLICY_DROP && settings.OutboundQueuePolicy != QUEUE_POLICY_DISCONNECT {
+		slog.Warn("Unknown outbound queue policy, using default", "policy", settings.OutboundQueuePolicy)
+		settings.OutboundQueuePolicy = defaults.OutboundQueuePolicy
+	}
+	if settings.DuplicateSessionPolicy != DUPLICATE_POLICY_REJECT && settings.DuplicateSessionPolicy != DUPLICATE_POLICY_REPLACE {
+		slog.Warn("Unknown duplicate session policy, using default", "policy", settings.DuplicateSessionPolicy)
+		settings.DuplicateSessionPolicy = defaults.DuplicateSessionPolicy
+	}
+	if !validChatPolicy(settings.ChatPolicy) {
+		slog.Warn("Unknown chat policy, using default", "policy", settings.ChatPolicy)
+		settings.ChatPolicy = defaults.ChatPolicy
+	}
+	if settings.OutboundQueueSize <= 0 {
+		settings.OutboundQueueSize = defaults.OutboundQueueSize
+	}
+	if settings.PingInterval > 0 && settings.PongTimeout <= settings.PingInterval {
+		slog.Warn("Pong timeout must be longer than the ping interval, using defaults", "ping", settings.PingInterval, "pong", settings.PongTimeout)
+		settings.PingInterval = defaults.PingInterval
+		settings.PongTimeout = defaults.PongTimeout
+	}
+	if settings.PingInterval <= 0 {
+		// Without pings the clients have nothing to answer, the reads have no deadline
+		settings.PongTimeout = 0
+	}
+	if settings.MaxParticipants <= 0 {
+		settings.MaxParticipants = defaults.MaxParticipants
+	}
+	if settings.ResumeHistorySize >= settings.OutboundQueueSize {
+		// The replay is queued at once, it must not overflow the queue of the resuming participant
+		slog.Warn("Resume history does not fit in the outbound queue, shrinking it", "size", settings.ResumeHistorySize)
+		settings.ResumeHistorySize = settings.OutboundQueueSize - 1
+	}
+	if settings.JanitorInterval <= 0 {
+		settings.JanitorInterval = defaults.JanitorInterval
+	}
+	if settings.RateLimitStrikeWindow <= 0 {
+		settings.RateLimitStrikeWindow = defaults.RateLimitStrikeWindow
+	}
+	exportRateLimits()
+}
@@ -0,0 +1,130 @@
+package server
+
+import "time"
+
+const (
+	// Version of the signaling envelope, clients omitting it are assumed to speak the current one
+	PROTOCOL_VERSION = 1
+
+	// Size limits in bytes. A frame over MAX_FRAME_SIZE closes the connection,
+	// a message over MAX_MESSAGE_SIZE is answered with an error frame.
+	MAX_FRAME_SIZE   = 1 << 20
+	MAX_MESSAGE_SIZE = 64 << 10
+	MAX_ID_SIZE      = 64
+	MAX_SDP_SIZE     = 32 << 10
+	MAX_CANDIDATE    = 1024
+	MAX_EMOJI_SIZE   = 32
+	MAX_CHAT_SIZE    = 2000
+)
+
+// Alphabet of the room IDs, without the ambiguous "o"
+const ROOM_ID_LETTERS = "abcdefghijklmnpqrstuvwxyz"
+
+// Policies applied when the outbound queue of a participant is full
+const (
+	QUEUE_POLICY_DROP       = "drop"
+	QUEUE_POLICY_DISCONNECT = "disconnect"
+)
+
+// Policies applied when a user connects twice to the same room
+const (
+	DUPLICATE_POLICY_REJECT  = "reject"
+	DUPLICATE_POLICY_REPLACE = "replace"
+)
+
+// Maximum length of a room password
+const MAX_PASSWORD_SIZE = 72
+
+// Messages waiting to be relayed to the other replicas, the next ones are dropped
+const RELAY_QUEUE_SIZE = 1024
+
+// What happens to a flagged chat message: delivered as is, redacted, or held until a host releases it
+const (
+	CHAT_POLICY_ALLOW  = "allow"
+	CHAT_POLICY_REDACT = "redact"
+	CHAT_POLICY_HOLD   = "hold"
+)
+
+const (
+	// Text replacing a redacted chat message
+	REDACTED_TEXT = "[message removed]"
+	// Number of chat messages kept for the late joiners
+	MAX_CHAT_HISTORY = 500
+	// Number of flagged chat messages of a room waiting for a host, the next ones are rejected
+	MAX_HELD_CHATS = 100
+	// Number of chat messages of a participant waiting for their classification
+	CHAT_QUEUE_SIZE = 16
+)
+
+// Buckets of the rate limits: the room creations per IP, and the messages per connection
+const (
+	LIMIT_CREATE  = "create"
+	LIMIT_MESSAGE = "message"
+	LIMIT_CHAT    = "chat"
+	LIMIT_EMOJI   = "emoji"
+)
+
+// Time after which the creation limiter of an IP without requests is forgotten
+const IP_LIMITER_TTL = 10 * time.Minute
+
+// Sender of the messages triggered through the admin API
+const ADMIN_USER = "admin"
+
+// Signaling message types
+const (
+	// Sent by the clients
+	MESSAGE_OFFER         = "offer"
+	MESSAGE_ANSWER        = "answer"
+	MESSAGE_ICE_CANDIDATE = "iceCandidate"
+	MESSAGE_HANG_UP       = "hangUp"
+	MESSAGE_EMOJI         = "emoji"
+	MESSAGE_CHAT          = "chat"
+
+	// Sent by the hosts
+	MESSAGE_KICK              = "kick"
+	MESSAGE_MUTE              = "mute"
+	MESSAGE_LOCK_ROOM         = "lockRoom"
+	MESSAGE_SET_WAITING_ROOM  = "setWaitingRoom"
+	MESSAGE_SET_TRANSCRIPTION = "setTranscription"
+	MESSAGE_ADMIT             = "admit"
+	MESSAGE_SET_CHAT_POLICY   = "setChatPolicy"
+	MESSAGE_RELEASE_CHAT      = "releaseChat"
+
+	// Sent by the server
+	MESSAGE_ERROR              = "error"
+	MESSAGE_REPORT             = "report"
+	MESSAGE_PARTICIPANTS       = "participants"
+	MESSAGE_PARTICIPANT_JOINED = "participantJoined"
+	MESSAGE_PARTICIPANT_LEFT   = "participantLeft"
+	MESSAGE_ROOM_STATE         = "roomState"
+	MESSAGE_WAITING            = "waiting"
+	MESSAGE_KNOCK              = "knock"
+	MESSAGE_KICKED             = "kicked"
+	MESSAGE_DENIED             = "denied"
+	MESSAGE_REPLACED           = "replaced"
+	MESSAGE_SESSION            = "session"
+	MESSAGE_CHAT_HISTORY       = "chatHistory"
+	MESSAGE_CHAT_HELD          = "chatHeld"
+	MESSAGE_CHAT_REJECTED      = "chatRejected"
+	MESSAGE_ROOM_CLOSED        = "roomClosed"
+	MESSAGE_SERVER_SHUTDOWN    = "serverShutdown"
+)
+
+// Error codes of the error frames
+const (
+	ERROR_INVALID_MESSAGE     = "invalidMessage"
+	ERROR_UNSUPPORTED_VERSION = "unsupportedVersion"
+	ERROR_UNKNOWN_TYPE        = "unknownType"
+	ERROR_INVALID_PAYLOAD     = "invalidPayload"
+	ERROR_MESSAGE_TOO_LARGE   = "messageTooLarge"
+	ERROR_UNKNOWN_RECIPIENT   = "unknownRecipient"
+	ERROR_RECIPIENT_REQUIRED  = "recipientRequired"
+	ERROR_ROOM_FULL           = "roomFull"
+	ERROR_ROOM_LOCKED         = "roomLocked"
+	ERROR_FORBIDDEN           = "forbidden"
+	ERROR_NOT_ADMITTED        = "notAdmitted"
+	ERROR_DUPLICATE_USER      = "duplicateUser"
+	ERROR_CANNOT_RESUME       = "cannotResume"
+	ERROR_RATE_LIMITED        = "rateLimited"
+	ERROR_UNAVAILABLE         = "unavailable"
+)
@@ -0,0 +1,59 @@
+package server
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"profanity.com/metrics"
+)
+
+var (
+	activeRooms = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.NAMESPACE,
+		Name:      "rooms_active",
+		Help:      "Rooms open on this replica.",
+	}, func() float64 {
+		AllRooms.Mutex.RLock()
+		defer AllRooms.Mutex.RUnlock()
+		return float64(len(AllRooms.Map))
+	})
+
+	activeParticipants = promauto.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: metrics.NAMESPACE,
+		Name:      "participants_active",
+		Help:      "Participants admitted in the rooms of this replica.",
+	}, func() float64 {
+		AllRooms.Mutex.RLock()
+		defer AllRooms.Mutex.RUnlock()
+		count := 0
+		for _, room := range AllRooms.Map {
+			count += len(room.Participants)
+		}
+		return float64(count)
+	})
+
+	rateLimitRate = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.NAMESPACE,
+		Name:      "rate_limit_per_second",
+		Help:      "Sustained rate allowed by the rate limits, by bucket (0 when disabled).",
+	}, []string{"bucket"})
+
+	rateLimitBurst = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metrics.NAMESPACE,
+		Name:      "rate_limit_burst",
+		Help:      "Burst allowed by the rate limits, by bucket.",
+	}, []string{"bucket"})
+)
+
+// exportRateLimits publishes the configured rate limits
+func exportRateLimits() {
+	limits := map[string][2]float64{
+		LIMIT_CREATE:  {settings.CreateRate, float64(settings.CreateBurst)},
+		LIMIT_MESSAGE: {settings.MessageRate, float64(settings.MessageBurst)},
+		LIMIT_CHAT:    {settings.ChatRate, float64(settings.ChatBurst)},
+		LIMIT_EMOJI:   {settings.EmojiRate, float64(settings.EmojiBurst)},
+	}
+	for bucket, limit := range limits {
+		rateLimitRate.WithLabelValues(bucket).Set(limit[0])
+		rateLimitBurst.WithLabelValues(bucket).Set(limit[1])
+	}
+}
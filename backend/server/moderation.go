@@ -0,0 +1,283 @@
+package server
+
+import (
+	"log/slog"
+	"sort"
+	"time"
+
+	"profanity.com/cluster"
+	"profanity.com/events"
+	"profanity.com/moderation"
+	"profanity.com/report"
+)
+
+// find returns the participant, admitted or waiting, with the given userID. The caller must hold the lock.
+func (room *Room) find(userID string) (Participant, bool, bool) {
+	for _, p := range room.Participants {
+		if p.UserID == userID {
+			return p, true, true
+		}
+	}
+	for _, p := range room.Waiting {
+		if p.UserID == userID {
+			return p, false, true
+		}
+	}
+	return Participant{}, false, false
+}
+
+// removeWaiting removes the user from the waiting room. The caller must hold the lock.
+func (room *Room) removeWaiting(userID string) (Participant, bool) {
+	for i, p := range room.Waiting {
+		if p.UserID == userID {
+			room.Waiting = append(room.Waiting[:i], room.Waiting[i+1:]...)
+			return p, true
+		}
+	}
+	return Participant{}, false
+}
+
+// IsAdmitted returns true if the user is a participant of the room, and not held in the waiting room
+func (r *RoomMap) IsAdmitted(roomID string, userID string) bool {
+	return r.Contains(roomID, userID)
+}
+
+// IsHost returns true if the user is an admitted host of the room
+func (r *RoomMap) IsHost(roomID string, userID string) bool {
+	for _, p := range r.Get(roomID) {
+		if p.UserID == userID {
+			return p.Host
+		}
+	}
+	return false
+}
+
+// SendTo queues the message for the user, admitted or waiting
+func (r *RoomMap) SendTo(roomID string, userID string, message Envelope) {
+	r.Mutex.RLock()
+	room, ok := r.Map[roomID]
+	var p Participant
+	if ok {
+		p, _, ok = room.find(userID)
+	}
+	r.Mutex.RUnlock()
+
+	if ok {
+		p.writer.send(message)
+	}
+}
+
+// State returns the moderation state of the room shared with its participants
+func (r *RoomMap) State(roomID string) (RoomStatePayload, bool) {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	room, ok := r.Map[roomID]
+	if !ok {
+		return RoomStatePayload{}, false
+	}
+
+	state := RoomStatePayload{
+		Locked:           room.Locked,
+		WaitingRoom:      room.WaitingRoom,
+		Hosts:            []string{},
+		Waiting:          []string{},
+		Muted:            sortedKeys(room.muted),
+		TranscriptionOff: sortedKeys(room.transcriptionOff),
+		ChatPolicy:       room.ChatPolicy,
+	}
+	for _, p := range room.Participants {
+		if p.Host {
+			state.Hosts = append(state.Hosts, p.UserID)
+		}
+	}
+	for _, p := range room.Waiting {
+		state.Waiting = append(state.Waiting, p.UserID)
+	}
+	return state, true
+}
+
+// sortedKeys returns the keys set to true, sorted
+func sortedKeys(m map[string]bool) []string {
+	keys := []string{}
+	for k, v := range m {
+		if v {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// stateEnvelope builds the roomState message of the room
+func stateEnvelope(roomID string) (Envelope, bool) {
+	state, ok := AllRooms.State(roomID)
+	if !ok {
+		return Envelope{}, false
+	}
+
+	message, err := newEnvelope(MESSAGE_ROOM_STATE, state)
+	if err != nil {
+		slog.Error("Error marshaling room state", "err", err)
+		return Envelope{}, false
+	}
+	return message, true
+}
+
+// broadcastState sends the moderation state of the room to every participant
+func broadcastState(roomID string) {
+	if message, ok := stateEnvelope(roomID); ok {
+		// The moderation state is enforced by each replica for its own participants
+		AllRooms.Broadcast(broadcastMsg{Message: message, RoomID: roomID, Local: true})
+	}
+}
+
+// announceWaiting tells the joiner it is held and knocks on the door of the hosts
+func announceWaiting(roomID string, userID string) {
+	waiting, _ := newEnvelope(MESSAGE_WAITING, nil)
+	AllRooms.SendTo(roomID, userID, waiting)
+
+	knock, _ := newEnvelope(MESSAGE_KNOCK, nil)
+	knock.From = userID
+	for _, p := range AllRooms.Get(roomID) {
+		if p.Host {
+			p.writer.send(knock)
+		}
+	}
+	broadcastState(roomID)
+}
+
+// handleHostCommand applies a moderation command sent by a host
+func handleHostCommand(roomID string, userID string, message Envelope) *protocolError {
+	if !AllRooms.IsHost(roomID, userID) {
+		return newProtocolError(ERROR_FORBIDDEN, "%s is reserved to the hosts", message.Type)
+	}
+
+	var payload HostCommandPayload
+	if err := decodePayload(message.Payload, &payload); err != nil {
+		return err
+	}
+	enabled := payload.Enabled != nil && *payload.Enabled
+
+	if payload.UserID == userID && (message.Type == MESSAGE_KICK || message.Type == MESSAGE_ADMIT) {
+		return newProtocolError(ERROR_INVALID_PAYLOAD, "a host cannot %s itself", message.Type)
+	}
+
+	// The flags are shared before they apply here, the joins reading them back never see an older value
+	switch message.Type {
+	case MESSAGE_LOCK_ROOM, MESSAGE_SET_WAITING_ROOM:
+		flag := cluster.FLAG_LOCKED
+		if message.Type == MESSAGE_SET_WAITING_ROOM {
+			flag = cluster.FLAG_WAITING_ROOM
+		}
+		if err := shareFlag(roomID, flag, enabled); err != nil {
+			slog.Error("Moderation flag could not be shared", "roomID", roomID, "flag", flag, "err", err)
+			return newProtocolError(ERROR_UNAVAILABLE, "the room could not be updated, retry later")
+		}
+	}
+
+	AllRooms.Mutex.Lock()
+	room, ok := AllRooms.Map[roomID]
+	if !ok {
+		AllRooms.Mutex.Unlock()
+		return nil
+	}
+
+	var target Participant
+	var found bool
+	if payload.UserID != "" {
+		target, _, found = room.find(payload.UserID)
+		if !found {
+			AllRooms.Mutex.Unlock()
+			return newProtocolError(ERROR_UNKNOWN_RECIPIENT, "%q is not in the room", payload.UserID)
+		}
+	}
+
+	admitted := false
+	var released ChatMessage
+	var wasHeld bool
+	switch message.Type {
+	case MESSAGE_KICK:
+		room.banned[target.UserID] = true
+		moderation.Participants.Kick(roomID, target.UserID)
+
+	case MESSAGE_MUTE:
+		room.muted[target.UserID] = enabled
+		moderation.Participants.SetMuted(roomID, target.UserID, enabled)
+
+	case MESSAGE_SET_TRANSCRIPTION:
+		room.transcriptionOff[target.UserID] = !enabled
+		moderation.Participants.SetTranscription(roomID, target.UserID, enabled)
+
+	case MESSAGE_LOCK_ROOM:
+		room.Locked = enabled
+
+	case MESSAGE_SET_WAITING_ROOM:
+		room.WaitingRoom = enabled
+
+	case MESSAGE_ADMIT:
+		if _, waiting := room.removeWaiting(target.UserID); !waiting {
+			AllRooms.Mutex.Unlock()
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "%q is not in the waiting room", target.UserID)
+		}
+		if enabled {
+			target.JoinedAt = time.Now()
+			room.Participants = append(room.Participants, target)
+			room.joined = true
+			report.Meetings.Join(roomID, target.UserID)
+			room.sendSession(target, false, false)
+			admitted = true
+		}
+
+	case MESSAGE_SET_CHAT_POLICY:
+		room.ChatPolicy = payload.Policy
+
+	case MESSAGE_RELEASE_CHAT:
+		released, wasHeld = room.held[payload.MessageID]
+		if !wasHeld {
+			AllRooms.Mutex.Unlock()
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "%q is not a held chat message", payload.MessageID)
+		}
+		delete(room.held, payload.MessageID)
+	}
+	AllRooms.Mutex.Unlock()
+
+	slog.Info("Host command", "roomID", roomID, "host", userID, "command", message.Type, "target", payload.UserID, "enabled", enabled)
+	events.Publish(events.ROOM_MODERATED, roomID, userID, map[string]interface{}{
+		"command":   message.Type,
+		"target":    payload.UserID,
+		"enabled":   enabled,
+		"policy":    payload.Policy,
+		"messageID": payload.MessageID,
+	})
+
+	// The server notifies the target, the state it enforces is shared with everyone below
+	switch message.Type {
+	case MESSAGE_KICK:
+		shareBan(roomID, target.UserID)
+		kicked, _ := newEnvelope(MESSAGE_KICKED, nil)
+		kicked.From = userID
+		target.writer.sendAndClose(kicked)
+
+	case MESSAGE_ADMIT:
+		if admitted {
+			announceJoin(roomID, target.UserID)
+		} else {
+			denied, _ := newEnvelope(MESSAGE_DENIED, nil)
+			denied.From = userID
+			target.writer.sendAndClose(denied)
+		}
+
+	case MESSAGE_RELEASE_CHAT:
+		if enabled {
+			deliverChat(roomID, "", released)
+		} else {
+			rejected, _ := newEnvelope(MESSAGE_CHAT_REJECTED, ChatMessage{ID: released.ID, From: released.From})
+			rejected.From = userID
+			AllRooms.SendTo(roomID, released.From, rejected)
+		}
+	}
+
+	broadcastState(roomID)
+	return nil
+}
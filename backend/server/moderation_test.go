@@ -0,0 +1,93 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/invite"
+)
+
+// dial connects a client to /join with an invite token for the role and returns the connection
+func dial(t *testing.T, serverURL string, roomID string, userID string, role string) *websocket.Conn {
+	t.Helper()
+
+	token, _, err := invite.Tokens.Sign(roomID, userID, role)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "ws" + strings.TrimPrefix(serverURL, "http") + "/join?token=" + token
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("dial %s: %v", userID, err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// expectMessage reads until a message of the given type arrives
+func expectMessage(t *testing.T, conn *websocket.Conn, msgType string) Envelope {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var message Envelope
+		if err := conn.ReadJSON(&message); err != nil {
+			t.Fatalf("waiting for %s: %v", msgType, err)
+		}
+		if message.Type == msgType {
+			return message
+		}
+	}
+}
+
+// TestWaitingRoom tests that a joiner is held until the host admits it, and that only hosts can moderate
+func TestWaitingRoom(t *testing.T) {
+	AllRooms.Init()
+	srv := httptest.NewServer(http.HandlerFunc(JoinRoomRequestHandler))
+	defer srv.Close()
+
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	host := dial(t, srv.URL, roomID, "host", invite.ROLE_HOST)
+	expectMessage(t, host, MESSAGE_PARTICIPANTS)
+	host.WriteJSON(Envelope{Type: MESSAGE_SET_WAITING_ROOM, Payload: []byte(`{"enabled":true}`)})
+	expectMessage(t, host, MESSAGE_ROOM_STATE)
+
+	guest := dial(t, srv.URL, roomID, "guest", invite.ROLE_GUEST)
+	expectMessage(t, guest, MESSAGE_WAITING)
+	if knock := expectMessage(t, host, MESSAGE_KNOCK); knock.From != "guest" {
+		t.Errorf("expected a knock from guest, got %q", knock.From)
+	}
+
+	// A held joiner cannot talk to the room
+	guest.WriteJSON(Envelope{Type: MESSAGE_EMOJI, Payload: []byte(`"👍"`)})
+	if e := expectMessage(t, guest, MESSAGE_ERROR); !strings.Contains(string(e.Payload), ERROR_NOT_ADMITTED) {
+		t.Errorf("expected %s, got %s", ERROR_NOT_ADMITTED, e.Payload)
+	}
+
+	host.WriteJSON(Envelope{Type: MESSAGE_ADMIT, Payload: []byte(`{"userID":"guest","enabled":true}`)})
+	expectMessage(t, guest, MESSAGE_PARTICIPANTS)
+	expectMessage(t, host, MESSAGE_PARTICIPANT_JOINED)
+
+	// Only a host can moderate
+	guest.WriteJSON(Envelope{Type: MESSAGE_KICK, Payload: []byte(`{"userID":"host"}`)})
+	if e := expectMessage(t, guest, MESSAGE_ERROR); !strings.Contains(string(e.Payload), ERROR_FORBIDDEN) {
+		t.Errorf("expected %s, got %s", ERROR_FORBIDDEN, e.Payload)
+	}
+
+	host.WriteJSON(Envelope{Type: MESSAGE_KICK, Payload: []byte(`{"userID":"guest"}`)})
+	expectMessage(t, guest, MESSAGE_KICKED)
+	expectMessage(t, host, MESSAGE_PARTICIPANT_LEFT)
+
+	if err := AllRooms.CanJoin(roomID, "guest", false); err != ErrBanned {
+		t.Errorf("expected a kicked participant to be banned, got %v", err)
+	}
+}
@@ -0,0 +1,174 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"unicode/utf8"
+)
+
+// protocolError is a validation error reported to the sender as an error frame
+type protocolError struct {
+	Code    string
+	Message string
+}
+
+func (e *protocolError) Error() string {
+	return e.Code + ": " + e.Message
+}
+
+// newProtocolError returns a protocolError with a formatted message
+func newProtocolError(code string, format string, a ...interface{}) *protocolError {
+	return &protocolError{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// parseEnvelope decodes and validates a message received from a client
+func parseEnvelope(data []byte) (Envelope, *protocolError) {
+	var env Envelope
+
+	if len(data) > MAX_MESSAGE_SIZE {
+		return env, newProtocolError(ERROR_MESSAGE_TOO_LARGE, "message exceeds %d bytes", MAX_MESSAGE_SIZE)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&env); err != nil {
+		return env, newProtocolError(ERROR_INVALID_MESSAGE, "invalid JSON envelope: %v", err)
+	}
+
+	if env.Version != 0 && env.Version != PROTOCOL_VERSION {
+		return env, newProtocolError(ERROR_UNSUPPORTED_VERSION, "version %d is not supported, use %d", env.Version, PROTOCOL_VERSION)
+	}
+	if len(env.ID) > MAX_ID_SIZE {
+		return env, newProtocolError(ERROR_INVALID_MESSAGE, "id exceeds %d bytes", MAX_ID_SIZE)
+	}
+	if len(env.To) > MAX_ID_SIZE {
+		return env, newProtocolError(ERROR_INVALID_MESSAGE, "to exceeds %d bytes", MAX_ID_SIZE)
+	}
+
+	if err := validatePayload(env); err != nil {
+		return env, err
+	}
+
+	env.Version = PROTOCOL_VERSION
+	return env, nil
+}
+
+// validatePayload checks the payload against the schema of the message type
+func validatePayload(env Envelope) *protocolError {
+	switch env.Type {
+	case MESSAGE_OFFER, MESSAGE_ANSWER:
+		var payload SessionDescriptionPayload
+		if err := decodePayload(env.Payload, &payload); err != nil {
+			return err
+		}
+		if payload.Type != env.Type {
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "payload type %q does not match %q", payload.Type, env.Type)
+		}
+		if payload.SDP == "" || len(payload.SDP) > MAX_SDP_SIZE {
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "sdp must be between 1 and %d bytes", MAX_SDP_SIZE)
+		}
+
+	case MESSAGE_ICE_CANDIDATE:
+		var payload IceCandidatePayload
+		if err := decodePayload(env.Payload, &payload); err != nil {
+			return err
+		}
+		if len(payload.Candidate) > MAX_CANDIDATE {
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "candidate exceeds %d bytes", MAX_CANDIDATE)
+		}
+
+	case MESSAGE_HANG_UP:
+		if len(env.Payload) != 0 && !bytes.Equal(env.Payload, []byte("null")) {
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "hangUp has no payload")
+		}
+
+	case MESSAGE_EMOJI:
+		var emoji string
+		if err := decodePayload(env.Payload, &emoji); err != nil {
+			return err
+		}
+		if emoji == "" || len(emoji) > MAX_EMOJI_SIZE {
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "emoji must be between 1 and %d bytes", MAX_EMOJI_SIZE)
+		}
+
+	case MESSAGE_CHAT:
+		var payload ChatPayload
+		if err := decodePayload(env.Payload, &payload); err != nil {
+			return err
+		}
+		if payload.Text == "" || utf8.RuneCountInString(payload.Text) > MAX_CHAT_SIZE {
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "text must be between 1 and %d characters", MAX_CHAT_SIZE)
+		}
+
+	case MESSAGE_KICK, MESSAGE_MUTE, MESSAGE_LOCK_ROOM, MESSAGE_SET_WAITING_ROOM, MESSAGE_SET_TRANSCRIPTION, MESSAGE_ADMIT,
+		MESSAGE_SET_CHAT_POLICY, MESSAGE_RELEASE_CHAT:
+		var payload HostCommandPayload
+		if err := decodePayload(env.Payload, &payload); err != nil {
+			return err
+		}
+		switch env.Type {
+		case MESSAGE_LOCK_ROOM, MESSAGE_SET_WAITING_ROOM:
+			// Room-wide commands have no target
+		case MESSAGE_SET_CHAT_POLICY:
+			if !validChatPolicy(payload.Policy) {
+				return newProtocolError(ERROR_INVALID_PAYLOAD, "policy must be %s, %s or %s", CHAT_POLICY_ALLOW, CHAT_POLICY_REDACT, CHAT_POLICY_HOLD)
+			}
+			return nil
+		case MESSAGE_RELEASE_CHAT:
+			if payload.MessageID == "" || len(payload.MessageID) > MAX_ID_SIZE {
+				return newProtocolError(ERROR_INVALID_PAYLOAD, "%s requires a messageID", env.Type)
+			}
+		default:
+			if payload.UserID == "" || len(payload.UserID) > MAX_ID_SIZE {
+				return newProtocolError(ERROR_INVALID_PAYLOAD, "%s requires a userID", env.Type)
+			}
+		}
+		if env.Type != MESSAGE_KICK && payload.Enabled == nil {
+			return newProtocolError(ERROR_INVALID_PAYLOAD, "%s requires enabled", env.Type)
+		}
+
+	case "":
+		return newProtocolError(ERROR_INVALID_MESSAGE, "type is missing")
+
+	default:
+		return newProtocolError(ERROR_UNKNOWN_TYPE, "unknown message type %q", env.Type)
+	}
+
+	return nil
+}
+
+// decodePayload strictly decodes the payload into v
+func decodePayload(payload json.RawMessage, v interface{}) *protocolError {
+	if len(payload) == 0 {
+		return newProtocolError(ERROR_INVALID_PAYLOAD, "payload is missing")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return newProtocolError(ERROR_INVALID_PAYLOAD, "invalid payload: %v", err)
+	}
+	return nil
+}
+
+// newEnvelope builds a server message with the given payload
+func newEnvelope(msgType string, payload interface{}) (Envelope, error) {
+	env := Envelope{Version: PROTOCOL_VERSION, Type: msgType}
+
+	if payload != nil {
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return env, err
+		}
+		env.Payload = data
+	}
+	return env, nil
+}
+
+// newErrorEnvelope builds the error frame answering the message with the given ID
+func newErrorEnvelope(id string, err *protocolError) Envelope {
+	env, _ := newEnvelope(MESSAGE_ERROR, ErrorPayload{Code: err.Code, Message: err.Message})
+	env.ID = id
+	return env
+}
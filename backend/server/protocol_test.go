@@ -0,0 +1,52 @@
+package server
+
+import (
+	"strings"
+	"testing"
+)
+
+// TestParseEnvelope tests the validation of the signaling messages.
+// An invalid message must be rejected with the matching error code.
+func TestParseEnvelope(t *testing.T) {
+	tests := []struct {
+		name         string
+		message      string
+		expectedCode string
+	}{
+		{"offer", `{"type":"offer","payload":{"type":"offer","sdp":"v=0"}}`, ""},
+		{"answer", `{"version":1,"type":"answer","id":"1","payload":{"type":"answer","sdp":"v=0"}}`, ""},
+		{"iceCandidate", `{"type":"iceCandidate","payload":{"candidate":"candidate:1","sdpMid":"0","sdpMLineIndex":0,"usernameFragment":"abc"}}`, ""},
+		{"hangUp", `{"type":"hangUp"}`, ""},
+		{"emoji", `{"type":"emoji","payload":"👍"}`, ""},
+		{"chat", `{"type":"chat","payload":{"text":"hello"}}`, ""},
+		{"invalid JSON", `{"type":`, ERROR_INVALID_MESSAGE},
+		{"unknown field", `{"type":"hangUp","foo":1}`, ERROR_INVALID_MESSAGE},
+		{"missing type", `{"payload":"👍"}`, ERROR_INVALID_MESSAGE},
+		{"unknown type", `{"type":"foo"}`, ERROR_UNKNOWN_TYPE},
+		{"future version", `{"version":2,"type":"hangUp"}`, ERROR_UNSUPPORTED_VERSION},
+		{"mismatched sdp type", `{"type":"offer","payload":{"type":"answer","sdp":"v=0"}}`, ERROR_INVALID_PAYLOAD},
+		{"empty sdp", `{"type":"offer","payload":{"type":"offer","sdp":""}}`, ERROR_INVALID_PAYLOAD},
+		{"hangUp with payload", `{"type":"hangUp","payload":"bye"}`, ERROR_INVALID_PAYLOAD},
+		{"emoji too large", `{"type":"emoji","payload":"` + strings.Repeat("a", MAX_EMOJI_SIZE+1) + `"}`, ERROR_INVALID_PAYLOAD},
+		{"empty chat", `{"type":"chat","payload":{"text":""}}`, ERROR_INVALID_PAYLOAD},
+		{"message too large", `{"type":"chat","payload":{"text":"` + strings.Repeat("a", MAX_MESSAGE_SIZE) + `"}}`, ERROR_MESSAGE_TOO_LARGE},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			message, err := parseEnvelope([]byte(tt.message))
+			if tt.expectedCode == "" {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				if message.Version != PROTOCOL_VERSION {
+					t.Errorf("expected version %d, got %d", PROTOCOL_VERSION, message.Version)
+				}
+				return
+			}
+			if err == nil || err.Code != tt.expectedCode {
+				t.Errorf("expected %s, got %v", tt.expectedCode, err)
+			}
+		})
+	}
+}
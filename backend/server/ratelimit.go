@@ -0,0 +1,150 @@
+package server
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+	"profanity.com/metrics"
+)
+
+// newBucket returns a token bucket, or nil when the rate disables the limit
+func newBucket(perSecond float64, burst int) *rate.Limiter {
+	if perSecond <= 0 {
+		return nil
+	}
+	return rate.NewLimiter(rate.Limit(perSecond), max(burst, 1))
+}
+
+// messageLimiter rate limits the messages of a connection, with separate buckets for the chat and the emojis
+type messageLimiter struct {
+	buckets     map[string]*rate.Limiter
+	strikes     int
+	windowStart time.Time
+}
+
+// newMessageLimiter returns the limiter of a new connection
+func newMessageLimiter() *messageLimiter {
+	return &messageLimiter{buckets: map[string]*rate.Limiter{
+		LIMIT_MESSAGE: newBucket(settings.MessageRate, settings.MessageBurst),
+		LIMIT_CHAT:    newBucket(settings.ChatRate, settings.ChatBurst),
+		LIMIT_EMOJI:   newBucket(settings.EmojiRate, settings.EmojiBurst),
+	}}
+}
+
+// bucketOf returns the bucket counting the messages of the type
+func bucketOf(msgType string) string {
+	switch msgType {
+	case MESSAGE_CHAT:
+		return LIMIT_CHAT
+	case MESSAGE_EMOJI:
+		return LIMIT_EMOJI
+	}
+	return LIMIT_MESSAGE
+}
+
+// allow returns whether the message can be handled, and whether the client went over the limits
+// so many times within the strike window that it must be disconnected
+func (l *messageLimiter) allow(msgType string) (bool, bool) {
+	bucket := bucketOf(msgType)
+	if limiter := l.buckets[bucket]; limiter == nil || limiter.Allow() {
+		return true, false
+	}
+	metrics.RateLimited.WithLabelValues(bucket).Inc()
+
+	now := time.Now()
+	if now.Sub(l.windowStart) > settings.RateLimitStrikeWindow {
+		l.windowStart = now
+		l.strikes = 0
+	}
+	l.strikes++
+
+	disconnect := settings.RateLimitStrikes > 0 && l.strikes >= settings.RateLimitStrikes
+	if disconnect {
+		metrics.RateLimitDisconnects.Inc()
+	}
+	return false, disconnect
+}
+
+type ipLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipLimiters holds a token bucket per client IP
+type ipLimiters struct {
+	mutex    sync.Mutex
+	limiters map[string]*ipLimiter
+}
+
+// Room creations of every client IP
+var creationLimits = &ipLimiters{limiters: make(map[string]*ipLimiter)}
+
+// reserve takes a token of the IP, and returns how long to wait when there is none
+func (l *ipLimiters) reserve(ip string, now time.Time) (bool, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	entry, ok := l.limiters[ip]
+	if !ok {
+		bucket := newBucket(settings.CreateRate, settings.CreateBurst)
+		if bucket == nil {
+			return true, 0
+		}
+		entry = &ipLimiter{limiter: bucket}
+		l.limiters[ip] = entry
+	}
+	entry.lastSeen = now
+
+	if entry.limiter.AllowN(now, 1) {
+		return true, 0
+	}
+	// The next token arrives after one period of the rate
+	return false, time.Duration(float64(time.Second) / float64(entry.limiter.Limit()))
+}
+
+// prune forgets the IPs without requests for a while
+func (l *ipLimiters) prune(now time.Time) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	for ip, entry := range l.limiters {
+		if now.Sub(entry.lastSeen) > IP_LIMITER_TTL {
+			delete(l.limiters, ip)
+		}
+	}
+}
+
+// clientIP returns the IP of the client, as seen by the reverse proxy when it is trusted
+func clientIP(r *http.Request) string {
+	if settings.TrustForwardedFor {
+		// The proxy appends the address it received the request from
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// limitCreation rejects the room creation when the client IP went over its limit
+func limitCreation(w http.ResponseWriter, r *http.Request) bool {
+	allowed, retryAfter := creationLimits.reserve(clientIP(r), time.Now())
+	if allowed {
+		return true
+	}
+
+	metrics.RateLimited.WithLabelValues(LIMIT_CREATE).Inc()
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	http.Error(w, "Too many rooms created, retry later", http.StatusTooManyRequests)
+	return false
+}
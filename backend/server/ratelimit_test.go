@@ -0,0 +1,96 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"profanity.com/invite"
+	"profanity.com/metrics"
+)
+
+// TestCreationRateLimit tests that an IP creating too many rooms is told to retry later, while another IP is not
+func TestCreationRateLimit(t *testing.T) {
+	AllRooms.Init()
+	previous := settings
+	settings.CreateRate = 0.01
+	settings.CreateBurst = 2
+	creationLimits = &ipLimiters{limiters: make(map[string]*ipLimiter)}
+	t.Cleanup(func() { settings = previous })
+
+	create := func(remoteAddr string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPost, "/create", nil)
+		req.RemoteAddr = remoteAddr
+		rec := httptest.NewRecorder()
+		CreateRoomRequestHandler(rec, req)
+		return rec
+	}
+
+	for i := 0; i < 2; i++ {
+		if rec := create("192.0.2.1:1234"); rec.Code != http.StatusOK {
+			t.Fatalf("expected creation %d to succeed, got %d", i, rec.Code)
+		}
+	}
+	rec := create("192.0.2.1:5678")
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") == "" {
+		t.Errorf("expected 429 with Retry-After, got %d %v", rec.Code, rec.Header())
+	}
+	if rec := create("192.0.2.2:1234"); rec.Code != http.StatusOK {
+		t.Errorf("expected another IP to create a room, got %d", rec.Code)
+	}
+}
+
+// TestMessageRateLimit tests that a client flooding the chat gets rateLimited errors, then is disconnected
+func TestMessageRateLimit(t *testing.T) {
+	AllRooms.Init()
+	previous := settings
+	settings.ChatRate = 0.01
+	settings.ChatBurst = 1
+	settings.RateLimitStrikes = 3
+	t.Cleanup(func() { settings = previous })
+
+	srv := httptest.NewServer(http.HandlerFunc(JoinRoomRequestHandler))
+	defer srv.Close()
+
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := dial(t, srv.URL, roomID, "alice", invite.ROLE_HOST)
+	expectMessage(t, alice, MESSAGE_SESSION)
+	rejected := testutil.ToFloat64(metrics.RateLimited.WithLabelValues(LIMIT_CHAT))
+
+	// The chat is delivered once classified, the next messages are refused right away
+	alice.WriteJSON(Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"spam"}`)})
+	expectChat(t, alice, MESSAGE_CHAT)
+	for i := 0; i < 3; i++ {
+		alice.WriteJSON(Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"spam"}`)})
+	}
+
+	var limited ErrorPayload
+	json.Unmarshal(expectMessage(t, alice, MESSAGE_ERROR).Payload, &limited)
+	if limited.Code != ERROR_RATE_LIMITED {
+		t.Errorf("expected a rateLimited error, got %+v", limited)
+	}
+	if testutil.ToFloat64(metrics.RateLimited.WithLabelValues(LIMIT_CHAT)) <= rejected {
+		t.Error("expected the rejected chat messages to be counted")
+	}
+
+	// The third strike closes the connection, without keeping the slot
+	alice.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		if _, _, err := alice.ReadMessage(); err != nil {
+			break
+		}
+	}
+	deadline := time.Now().Add(time.Second)
+	for AllRooms.Contains(roomID, "alice") {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the flooding client to be removed")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"profanity.com/auth"
+	"profanity.com/report"
+)
+
+// RoomReportRequestHandler returns the meeting report of a room to one of its hosts
+func RoomReportRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if auth.AllowCORS(w, r) {
+		return
+	}
+	roomID := r.PathValue("id")
+	if _, ok := requireHost(w, r, roomID); !ok {
+		return
+	}
+
+	meetingReport, ok := report.Meetings.Get(roomID)
+	if !ok {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(meetingReport)
+}
+
+// pushReport sends the meeting report to a participant hanging up.
+// The last participant to leave triggers the final report, the others receive the meeting so far.
+// The participants are counted on every replica.
+func pushReport(roomID string, userID string) {
+	others := slices.DeleteFunc(AllRooms.Members(roomID), func(member string) bool { return member == userID })
+
+	var meetingReport *report.Report
+	if len(others) == 0 {
+		meetingReport = report.Meetings.Close(context.Background(), roomID)
+	} else {
+		meetingReport, _ = report.Meetings.Snapshot(roomID)
+	}
+
+	if meetingReport == nil {
+		slog.Info("No report to push", "roomID", roomID, "userID", userID)
+		return
+	}
+
+	message, err := newEnvelope(MESSAGE_REPORT, meetingReport)
+	if err != nil {
+		slog.Error("Error marshaling report", "err", err)
+		return
+	}
+
+	AllRooms.Broadcast(broadcastMsg{
+		Message: message,
+		RoomID:  roomID,
+		To:      userID,
+	})
+}
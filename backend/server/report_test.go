@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"profanity.com/invite"
+	"profanity.com/report"
+)
+
+// TestReportDelivery tests that a participant hanging up receives the meeting so far, and the last one of every
+// replica the final report, shared with the teardown of the room
+func TestReportDelivery(t *testing.T) {
+	AllRooms.Init()
+	previous := settings
+	settings = defaultConfig()
+	settings.ResumeGracePeriod = 0
+	t.Cleanup(func() { settings = previous })
+
+	srv := httptest.NewServer(http.HandlerFunc(JoinRoomRequestHandler))
+	defer srv.Close()
+
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := dialRoom(t, srv.URL, roomID, "alice")
+	bob := dialRoom(t, srv.URL, roomID, "bob")
+	report.Meetings.AddFlag(roomID, "alice", "bad words", 0.99)
+
+	if err := alice.WriteJSON(Envelope{Type: MESSAGE_HANG_UP}); err != nil {
+		t.Fatal(err)
+	}
+	var snapshot report.Report
+	if err := json.Unmarshal(expectMessage(t, alice, MESSAGE_REPORT).Payload, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if !snapshot.InProgress || len(snapshot.Flags) != 1 {
+		t.Errorf("expected the meeting in progress with its flag, got %+v", snapshot)
+	}
+
+	alice.Close()
+	waitFor(t, func() bool { return !AllRooms.Contains(roomID, "alice") })
+
+	// A participant of another replica keeps the meeting going
+	bus.Join(context.Background(), roomID, "carol")
+	if err := bob.WriteJSON(Envelope{Type: MESSAGE_HANG_UP}); err != nil {
+		t.Fatal(err)
+	}
+	var remaining report.Report
+	if err := json.Unmarshal(expectMessage(t, bob, MESSAGE_REPORT).Payload, &remaining); err != nil {
+		t.Fatal(err)
+	}
+	if !remaining.InProgress {
+		t.Error("expected the meeting to go on with the participant of the other replica")
+	}
+
+	bus.Leave(context.Background(), roomID, "carol")
+	if err := bob.WriteJSON(Envelope{Type: MESSAGE_HANG_UP}); err != nil {
+		t.Fatal(err)
+	}
+	var final report.Report
+	if err := json.Unmarshal(expectMessage(t, bob, MESSAGE_REPORT).Payload, &final); err != nil {
+		t.Fatal(err)
+	}
+	if final.InProgress || len(final.Flags) != 1 {
+		t.Errorf("expected the final report with its flag, got %+v", final)
+	}
+
+	// The teardown of the room closes the meeting too, it gets the report already built
+	closed := report.Meetings.Close(context.Background(), roomID)
+	if closed == nil || !closed.EndedAt.Equal(final.EndedAt) {
+		t.Errorf("expected the teardown to get the delivered report, got %+v", closed)
+	}
+}
+
+// TestReportAccess tests that the report of a room is only returned to its hosts, with the invite token in its header
+func TestReportAccess(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1/rooms/{id}/report", RoomReportRequestHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	report.Meetings.Join("reported", "alice")
+	fetch := func(token string) int {
+		req, _ := http.NewRequest(http.MethodGet, srv.URL+"/v1/rooms/reported/report", nil)
+		req.Header.Set(invite.HEADER_INVITE_TOKEN, token)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	guest, _, _ := invite.Tokens.Sign("reported", "bob", invite.ROLE_GUEST)
+	otherHost, _, _ := invite.Tokens.Sign("other", "carol", invite.ROLE_HOST)
+	host, _, _ := invite.Tokens.Sign("reported", "alice", invite.ROLE_HOST)
+	for name, token := range map[string]string{"missing": "", "guest": guest, "host of another room": otherHost} {
+		if status := fetch(token); status != http.StatusUnauthorized {
+			t.Errorf("expected the %s token to be refused, got %d", name, status)
+		}
+	}
+	if status := fetch(host); status != http.StatusOK {
+		t.Errorf("expected the host to get the report, got %d", status)
+	}
+}
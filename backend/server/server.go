@@ -1,15 +1,39 @@
 package server
 
 import (
+	"context"
+	"crypto/rand"
+	"errors"
 	"log/slog"
-	"math/rand"
+	"math/big"
+	"slices"
 	"time"
 
 	"github.com/gorilla/websocket"
+	"golang.org/x/crypto/bcrypt"
+	"profanity.com/cluster"
+	"profanity.com/events"
+	"profanity.com/report"
+)
+
+var (
+	ErrRoomFull   = errors.New("room is full")
+	ErrRoomLocked = errors.New("room is locked")
+	ErrBanned     = errors.New("participant was removed from the room")
+
+	ErrRoomNotFound  = errors.New("room not found")
+	ErrDuplicateUser = errors.New("user is already connected to the room")
+	ErrCannotResume  = errors.New("session cannot be resumed")
+
+	ErrParticipantNotFound = errors.New("participant not found")
+	ErrShuttingDown        = errors.New("server is shutting down")
 )
 
 func (r *RoomMap) Init() {
-	r.Map = make(map[string][]Participant)
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	r.Map = make(map[string]*Room)
 }
 
 // Get returns the list of participants in a room
@@ -17,64 +41,429 @@ func (r *RoomMap) Get(roomID string) []Participant {
 	r.Mutex.RLock()
 	defer r.Mutex.RUnlock()
 
-	return r.Map[roomID]
+	room, ok := r.Map[roomID]
+	if !ok {
+		return nil
+	}
+	return append([]Participant{}, room.Participants...)
+}
+
+// Participants returns the userIDs of the participants in a room
+func (r *RoomMap) Participants(roomID string) []string {
+	userIDs := []string{}
+	for _, p := range r.Get(roomID) {
+		userIDs = append(userIDs, p.UserID)
+	}
+	return userIDs
+}
+
+// Contains returns true if the user is a participant of the room
+func (r *RoomMap) Contains(roomID string, userID string) bool {
+	for _, p := range r.Get(roomID) {
+		if p.UserID == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// Recipients returns the participants a message from the sender must be delivered to.
+// The message goes to every other participant, or only to the addressee when set.
+func (r *RoomMap) Recipients(roomID string, from string, to string) []Participant {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	room, ok := r.Map[roomID]
+	if !ok {
+		return []Participant{}
+	}
+	return room.recipients(from, to)
+}
+
+// recipients returns the participants a message from the sender must be delivered to. The caller must hold the lock.
+func (room *Room) recipients(from string, to string) []Participant {
+	recipients := []Participant{}
+	for _, p := range room.Participants {
+		if p.UserID == from {
+			continue
+		}
+		if to != "" && p.UserID != to {
+			continue
+		}
+		recipients = append(recipients, p)
+	}
+	return recipients
+}
+
+// Broadcast sequences the message, keeps it for the participants who may resume,
+// and queues it on the writer of each recipient. It never blocks on a slow client.
+func (r *RoomMap) Broadcast(msg broadcastMsg) {
+	r.Mutex.Lock()
+	room, ok := r.Map[msg.RoomID]
+	if !ok {
+		r.Mutex.Unlock()
+		return
+	}
+
+	// Queuing under the lock keeps the messages in sequence order on every writer
+	message := room.record(msg)
+	recipients := room.recipients(msg.UserID, msg.To)
+	for _, p := range recipients {
+		p.writer.send(message)
+	}
+	r.Mutex.Unlock()
+
+	// A message addressed to a participant of this replica stays here, the others are relayed
+	if !msg.Local && (msg.To == "" || len(recipients) == 0) {
+		publish(msg)
+	}
 }
 
-// CreateRoom creates a new room and returns the roomID
-func (r *RoomMap) CreateRoom() string {
+// Touch records activity in the room, postponing its idle expiration
+func (r *RoomMap) Touch(roomID string) {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
-	rand.New(rand.NewSource(time.Now().UnixNano()))
 
-	// Generate a random roomID following the pattern: XXX-XXXX-XXX
-	roomID := randStringRunes(3) + "-" + randStringRunes(4) + "-" + randStringRunes(3)
+	if room, ok := r.Map[roomID]; ok {
+		room.LastActivity = time.Now()
+	}
+}
+
+// newRoom adds an empty room to the map. The caller must hold the lock.
+func (r *RoomMap) newRoom(roomID string) *Room {
+	now := time.Now()
+	room := &Room{
+		ID:               roomID,
+		CreatedAt:        now,
+		LastActivity:     now,
+		banned:           make(map[string]bool),
+		muted:            make(map[string]bool),
+		transcriptionOff: make(map[string]bool),
+		suspended:        make(map[string]*time.Timer),
+		historySize:      settings.ResumeHistorySize,
+		gracePeriod:      settings.ResumeGracePeriod,
+		ChatPolicy:       settings.ChatPolicy,
+		held:             make(map[string]ChatMessage),
+	}
+	r.Map[roomID] = room
+	return room
+}
+
+// CreateRoom creates a new room, protected by the password when it is not empty, and returns the roomID
+func (r *RoomMap) CreateRoom(password string) (string, error) {
+	var passwordHash []byte
+	if password != "" {
+		hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+		if err != nil {
+			return "", err
+		}
+		passwordHash = hash
+	}
+
+	for {
+		// Generate a random roomID following the pattern: XXX-XXXX-XXX
+		roomID, err := randomRoomID()
+		if err != nil {
+			return "", err
+		}
 
-	r.Map[roomID] = []Participant{}
+		// The roomID must be unique among the rooms of every replica
+		ctx, cancel := sharedContext()
+		err = cluster.Rooms.CreateRoom(ctx, roomID, cluster.RoomInfo{PasswordHash: passwordHash})
+		cancel()
+		if err == cluster.ErrRoomExists {
+			slog.Warn("RoomID collision, generating a new one", "roomID", roomID)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
 
-	return roomID
+		r.Mutex.Lock()
+		room := r.newRoom(roomID)
+		room.passwordHash = passwordHash
+		r.Mutex.Unlock()
+
+		// A room deaf to the other replicas is not handed out, it would be left behind until it expires
+		if err := r.subscribe(roomID); err != nil {
+			r.Mutex.Lock()
+			delete(r.Map, roomID)
+			r.Mutex.Unlock()
+
+			ctx, cancel := sharedContext()
+			if err := cluster.Rooms.DeleteRoom(ctx, roomID); err != nil {
+				slog.Error("Room could not be deleted from the bus", "roomID", roomID, "err", err)
+			}
+			cancel()
+			return "", err
+		}
+
+		slog.Info("Room created", "roomID", roomID)
+		events.Publish(events.ROOM_CREATED, roomID, "", nil)
+		return roomID, nil
+	}
 }
 
-// randStringRunes generates a random string of length n
-func randStringRunes(n int) string {
-	var letters = []rune("abcdefghijklmnpqrstuvwxyz")
+// Exists returns true if the room was created, on any replica, and is still open
+func (r *RoomMap) Exists(roomID string) bool {
+	r.Mutex.RLock()
+	_, ok := r.Map[roomID]
+	r.Mutex.RUnlock()
+	if ok {
+		return true
+	}
 
-	if n > len(letters) {
-		slog.Error("n is greater than the length of the letters array")
+	ctx, cancel := sharedContext()
+	defer cancel()
+	_, exists, err := cluster.Rooms.GetRoom(ctx, roomID)
+	return err == nil && exists
+}
+
+// CheckPassword returns true if the room has no password or if the password matches
+func (r *RoomMap) CheckPassword(roomID string, password string) bool {
+	r.Mutex.RLock()
+	room, ok := r.Map[roomID]
+	var passwordHash []byte
+	if ok {
+		passwordHash = room.passwordHash
 	}
+	r.Mutex.RUnlock()
+
+	if len(passwordHash) == 0 {
+		return true
+	}
+	return bcrypt.CompareHashAndPassword(passwordHash, []byte(password)) == nil
+}
+
+// randomRoomID generates a roomID following the pattern: XXX-XXXX-XXX
+func randomRoomID() (string, error) {
+	parts := []int{3, 4, 3}
+	roomID := ""
+
+	for i, n := range parts {
+		s, err := randStringRunes(n)
+		if err != nil {
+			return "", err
+		}
+		if i > 0 {
+			roomID += "-"
+		}
+		roomID += s
+	}
+	return roomID, nil
+}
+
+// randStringRunes generates a cryptographically random string of length n
+func randStringRunes(n int) (string, error) {
+	letters := []rune(ROOM_ID_LETTERS)
+	max := big.NewInt(int64(len(letters)))
 
 	b := make([]rune, n)
 	for i := range b {
-		b[i] = letters[rand.Intn(len(letters))]
+		index, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b[i] = letters[index.Int64()]
+	}
+	return string(b), nil
+}
+
+// checkJoin returns why the user cannot join the room, if anything prevents it. The caller must hold the lock.
+// The refusals come before the duplicate, a connection replacing another one is refused like a new one.
+func (room *Room) checkJoin(userID string, host bool) error {
+	if room.banned[userID] {
+		return ErrBanned
+	}
+	if room.Locked && !host {
+		return ErrRoomLocked
+	}
+	if _, _, found := room.find(userID); found {
+		// The replacement takes the slot of the previous connection, the capacity is unchanged
+		return ErrDuplicateUser
+	}
+	if len(room.Participants)+len(room.Waiting) >= settings.MaxParticipants {
+		return ErrRoomFull
 	}
-	return string(b)
+	return nil
 }
 
-// InsertIntoRoom inserts a new participant into the room
-func (r *RoomMap) InsertIntoRoom(roomID string, userID string, conn *websocket.Conn) {
+// CanJoin returns why the user cannot join the room, if anything prevents it.
+// The lock, the waiting room and the bans may have been set on another replica, they are read first.
+func (r *RoomMap) CanJoin(roomID string, userID string, host bool) error {
+	if err := r.syncState(roomID); err != nil {
+		slog.Error("Moderation state of the room is unavailable", "roomID", roomID, "err", err)
+	}
+
+	r.Mutex.RLock()
+	room, ok := r.Map[roomID]
+	err := ErrRoomNotFound
+	if ok {
+		err = room.checkJoin(userID, host)
+	}
+	r.Mutex.RUnlock()
+
+	if err == ErrDuplicateUser && settings.DuplicateSessionPolicy == DUPLICATE_POLICY_REPLACE {
+		// The previous connection is evicted before inserting the new one
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// The participants of the other replicas count toward the capacity too
+	members := r.Members(roomID)
+	if len(members) >= settings.MaxParticipants && !slices.Contains(members, userID) {
+		return ErrRoomFull
+	}
+	return nil
+}
+
+// Evict removes the connection of the user from the room, to be replaced by a new connection.
+// It returns the evicted participant and whether it had been admitted.
+func (r *RoomMap) Evict(roomID string, userID string) (Participant, bool, bool) {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
 
-	p := Participant{userID, conn}
+	room, ok := r.Map[roomID]
+	if !ok {
+		return Participant{}, false, false
+	}
 
-	slog.Info("Inserting into Room", "roomID", roomID)
-	r.Map[roomID] = append(r.Map[roomID], p)
+	for i, p := range room.Participants {
+		if p.UserID == userID {
+			room.Participants = append(room.Participants[:i], room.Participants[i+1:]...)
+			room.endSuspension(userID)
+			return p, true, true
+		}
+	}
+	p, found := room.removeWaiting(userID)
+	return p, false, found
 }
 
-// DeleteFromRoom deletes a participant from the room
-func (r *RoomMap) DeleteFromRoom(roomID string, userID string) {
+// InsertIntoRoom inserts a new participant into the room and starts its writer.
+// A joiner is held in the waiting room when it is enabled, until a host admits it.
+func (r *RoomMap) InsertIntoRoom(roomID string, userID string, conn *websocket.Conn, host bool) (Participant, bool, error) {
 	r.Mutex.Lock()
 	defer r.Mutex.Unlock()
 
-	for i, p := range r.Map[roomID] {
-		if p.UserID == userID {
+	room, ok := r.Map[roomID]
+	if !ok {
+		return Participant{}, false, ErrRoomNotFound
+	}
+	if err := room.checkJoin(userID, host); err != nil {
+		return Participant{}, false, err
+	}
+
+	resumeToken, err := randomToken()
+	if err != nil {
+		return Participant{}, false, err
+	}
+	p := Participant{userID, conn, newConnWriter(userID, conn), host, resumeToken, time.Now()}
+
+	if room.WaitingRoom && !host {
+		slog.Info("Holding in the waiting room", "roomID", roomID, "userID", userID)
+		room.Waiting = append(room.Waiting, p)
+		return p, false, nil
+	}
+
+	slog.Info("Inserting into Room", "roomID", roomID)
+	room.Participants = append(room.Participants, p)
+	room.LastActivity = time.Now()
+	room.joined = true
+	report.Meetings.Join(roomID, userID)
+	room.sendSession(p, false, false)
+	return p, true, nil
+}
+
+// DeleteFromRoom deletes the connection of a participant from the room.
+// It returns true if the participant had been admitted, meaning the others must be told it left.
+func (r *RoomMap) DeleteFromRoom(roomID string, userID string, conn *websocket.Conn) bool {
+	r.Mutex.Lock()
+	room, ok := r.Map[roomID]
+	if !ok {
+		r.Mutex.Unlock()
+		return false
+	}
+
+	admitted := false
+	for i, p := range room.Participants {
+		if p.UserID == userID && p.Conn == conn {
 			slog.Info("Deleting from Room", "roomID", roomID, "userID", userID)
-			r.Map[roomID] = append(r.Map[roomID][:i], r.Map[roomID][i+1:]...)
+			room.Participants = append(room.Participants[:i], room.Participants[i+1:]...)
+			room.LastActivity = time.Now()
+			room.endSuspension(userID)
+			report.Meetings.Leave(roomID, userID)
+			admitted = true
+			break
+		}
+	}
+	if !admitted {
+		if p, _, found := room.find(userID); found && p.Conn == conn {
+			room.removeWaiting(userID)
 		}
 	}
 
 	// Delete the room if there are no participants left
-	if len(r.Map[roomID]) == 0 {
+	empty := len(room.Participants) == 0 && len(room.Waiting) == 0
+	if empty {
 		slog.Info("Room is empty", "roomID", roomID)
 		delete(r.Map, roomID)
 	}
+	r.Mutex.Unlock()
+
+	if admitted {
+		leaveShared(roomID, userID)
+	}
+	if empty {
+		r.release(room, events.ROOM_CLOSED)
+	}
+	return admitted
+}
+
+// StartJanitor expires the unused and idle rooms, and forgets the idle creation limiters, until the context is done
+func (r *RoomMap) StartJanitor(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(settings.JanitorInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case now := <-ticker.C:
+				r.expireRooms(now)
+				creationLimits.prune(now)
+			}
+		}
+	}()
+}
+
+// expireRooms removes the rooms nobody joined before their TTL and the rooms idle for too long
+func (r *RoomMap) expireRooms(now time.Time) {
+	r.Mutex.Lock()
+	expired := []*Room{}
+	for roomID, room := range r.Map {
+		unused := !room.joined && now.Sub(room.CreatedAt) > settings.RoomTTL
+		idle := settings.RoomIdleTimeout > 0 && now.Sub(room.LastActivity) > settings.RoomIdleTimeout
+		if unused || idle {
+			delete(r.Map, roomID)
+			expired = append(expired, room)
+		}
+	}
+	r.Mutex.Unlock()
+
+	for _, room := range expired {
+		slog.Info("Room expired", "roomID", room.ID, "participants", len(room.Participants))
+
+		// Closing the writers ends the read loops of the remaining participants
+		for _, p := range room.Participants {
+			p.writer.close()
+			leaveShared(room.ID, p.UserID)
+		}
+		for _, p := range room.Waiting {
+			p.writer.close()
+		}
+		r.release(room, events.ROOM_EXPIRED)
+	}
 }
@@ -0,0 +1,159 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/classifier"
+	"profanity.com/cluster"
+	"profanity.com/events"
+)
+
+// keywordClassifier flags the texts containing "darn", and fails on the texts containing "unscored", in place of
+// the profanity service
+type keywordClassifier struct{}
+
+func (keywordClassifier) Classify(ctx context.Context, text string) (float64, error) {
+	if strings.Contains(text, "unscored") {
+		return 0, errors.New("profanity service unavailable")
+	}
+	if strings.Contains(text, "darn") {
+		return 0.99, nil
+	}
+	return 0.01, nil
+}
+
+func TestMain(m *testing.M) {
+	classifier.Profanity = keywordClassifier{}
+	cluster.Rooms = bus
+	os.Exit(m.Run())
+}
+
+// TestCreateRoom tests that the roomIDs follow the XXX-XXXX-XXX pattern and are unique
+func TestCreateRoom(t *testing.T) {
+	AllRooms.Init()
+	pattern := regexp.MustCompile(`^[a-z]{3}-[a-z]{4}-[a-z]{3}$`)
+
+	seen := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		roomID, err := AllRooms.CreateRoom("")
+		if err != nil {
+			t.Fatalf("expected no error, got %v", err)
+		}
+		if !pattern.MatchString(roomID) {
+			t.Errorf("roomID %s does not follow the pattern", roomID)
+		}
+		if seen[roomID] {
+			t.Errorf("roomID %s was generated twice", roomID)
+		}
+		seen[roomID] = true
+	}
+}
+
+// TestExpireRooms tests that the janitor removes the unused and idle rooms and publishes their expiration
+func TestExpireRooms(t *testing.T) {
+	AllRooms.Init()
+	expired, unsubscribe := events.Internal.Subscribe(10, events.ROOM_EXPIRED)
+	defer unsubscribe()
+
+	now := time.Now()
+	AllRooms.Map["unused"] = &Room{ID: "unused", CreatedAt: now.Add(-2 * settings.RoomTTL), LastActivity: now}
+	AllRooms.Map["fresh"] = &Room{ID: "fresh", CreatedAt: now, LastActivity: now}
+	AllRooms.Map["idle"] = &Room{ID: "idle", CreatedAt: now, LastActivity: now.Add(-2 * settings.RoomIdleTimeout), joined: true}
+	AllRooms.Map["active"] = &Room{ID: "active", CreatedAt: now.Add(-2 * settings.RoomTTL), LastActivity: now, joined: true}
+
+	AllRooms.expireRooms(now)
+
+	for _, roomID := range []string{"unused", "idle"} {
+		if _, ok := AllRooms.Map[roomID]; ok {
+			t.Errorf("expected room %s to expire", roomID)
+		}
+	}
+	for _, roomID := range []string{"fresh", "active"} {
+		if _, ok := AllRooms.Map[roomID]; !ok {
+			t.Errorf("expected room %s to be kept", roomID)
+		}
+	}
+	if len(expired) != 2 {
+		t.Errorf("expected 2 expiration events, got %d", len(expired))
+	}
+}
+
+// TestRedeemInvite tests that each redemption of the shared invite joins as a new user once the password matches,
+// and that a locked room refuses a connection replacing another one
+func TestRedeemInvite(t *testing.T) {
+	AllRooms.Init()
+	creationLimits = &ipLimiters{limiters: make(map[string]*ipLimiter)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/create", CreateRoomRequestHandler)
+	mux.HandleFunc("/join", JoinRoomRequestHandler)
+	mux.HandleFunc("POST /v1/rooms/{id}/redeem", RedeemInviteRequestHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	resp, err := http.PostForm(srv.URL+"/create", url.Values{"password": {"secret"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var room RoomCreationResponse
+	json.NewDecoder(resp.Body).Decode(&room)
+	resp.Body.Close()
+
+	redeem := func(password string) (InviteResponse, int) {
+		var guest InviteResponse
+		resp, err := http.PostForm(srv.URL+"/v1/rooms/"+room.RoomID+"/redeem?token="+room.InviteToken, url.Values{"password": {password}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		json.NewDecoder(resp.Body).Decode(&guest)
+		return guest, resp.StatusCode
+	}
+
+	if _, status := redeem("wrong"); status != http.StatusUnauthorized {
+		t.Errorf("expected a wrong password to be refused, got %d", status)
+	}
+	first, status := redeem("secret")
+	second, _ := redeem("secret")
+	if status != http.StatusCreated || first.UserID == "" || first.UserID == second.UserID {
+		t.Fatalf("expected each redemption to get a user of its own, got %q and %q", first.UserID, second.UserID)
+	}
+
+	join := func(token string) int {
+		conn, resp, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/join?token="+token, nil)
+		if err != nil {
+			return resp.StatusCode
+		}
+		t.Cleanup(func() { conn.Close() })
+		expectMessage(t, conn, MESSAGE_SESSION)
+		return http.StatusSwitchingProtocols
+	}
+	if status := join(room.InviteToken); status != http.StatusUnauthorized {
+		t.Errorf("expected the shared invite to be refused, got %d", status)
+	}
+	if join(first.Token) != http.StatusSwitchingProtocols || join(second.Token) != http.StatusSwitchingProtocols {
+		t.Fatal("expected both guests to join")
+	}
+
+	// The replacement of a connection goes through the same refusals as a new one
+	if err := shareFlag(room.RoomID, cluster.FLAG_LOCKED, true); err != nil {
+		t.Fatal(err)
+	}
+	if status := join(first.Token); status != http.StatusForbidden {
+		t.Errorf("expected the locked room to refuse the replacement, got %d", status)
+	}
+	if status := join(room.HostToken); status != http.StatusSwitchingProtocols {
+		t.Errorf("expected the host to join the locked room, got %d", status)
+	}
+}
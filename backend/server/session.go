@@ -0,0 +1,167 @@
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// randomToken generates an opaque resume token
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// record gives the message the next sequence number of the room and keeps it in the history.
+// The caller must hold the lock.
+func (room *Room) record(msg broadcastMsg) Envelope {
+	room.seq++
+	message := msg.Message
+	message.Seq = room.seq
+
+	if message.Type == MESSAGE_CHAT {
+		room.keepChat(message)
+	}
+
+	if room.historySize > 0 {
+		room.history = append(room.history, historyEntry{message: message, from: msg.UserID, to: msg.To})
+		if overflow := len(room.history) - room.historySize; overflow > 0 {
+			room.history = room.history[overflow:]
+		}
+	}
+	return message
+}
+
+// missed returns the messages of the history the user did not receive after lastSeq,
+// and whether older messages were missed beyond the history. The caller must hold the lock.
+func (room *Room) missed(userID string, lastSeq uint64) ([]Envelope, bool) {
+	truncated := lastSeq < room.seq && (len(room.history) == 0 || room.history[0].message.Seq > lastSeq+1)
+
+	messages := []Envelope{}
+	for _, entry := range room.history {
+		if entry.message.Seq <= lastSeq || entry.from == userID {
+			continue
+		}
+		if entry.to != "" && entry.to != userID {
+			continue
+		}
+		messages = append(messages, entry.message)
+	}
+	return messages, truncated
+}
+
+// sendSession queues the resume token of the participant. The caller must hold the lock.
+func (room *Room) sendSession(p Participant, resumed bool, truncated bool) {
+	session, err := newEnvelope(MESSAGE_SESSION, SessionPayload{
+		ResumeToken: p.resumeToken,
+		Seq:         room.seq,
+		Resumed:     resumed,
+		Truncated:   truncated,
+	})
+	if err != nil {
+		slog.Error("Error marshaling session", "err", err)
+		return
+	}
+	p.writer.send(session)
+}
+
+// endSuspension cancels the grace period of the user, if any. The caller must hold the lock.
+func (room *Room) endSuspension(userID string) {
+	if timer, ok := room.suspended[userID]; ok {
+		timer.Stop()
+		delete(room.suspended, userID)
+	}
+}
+
+// Suspend keeps the slot of a participant whose connection dropped for the grace period.
+// It returns false when the participant must leave now: it was never admitted, or the server closed its connection.
+func (r *RoomMap) Suspend(roomID string, userID string, conn *websocket.Conn) bool {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	room, ok := r.Map[roomID]
+	if !ok || room.banned[userID] || room.gracePeriod <= 0 {
+		return false
+	}
+
+	for _, p := range room.Participants {
+		if p.UserID != userID || p.Conn != conn {
+			continue
+		}
+		if p.writer.closedByServer() {
+			return false
+		}
+
+		slog.Info("Connection lost, keeping the slot", "roomID", roomID, "userID", userID, "grace", room.gracePeriod)
+		room.endSuspension(userID)
+		room.suspended[userID] = time.AfterFunc(room.gracePeriod, func() {
+			slog.Info("Grace period is over", "roomID", roomID, "userID", userID)
+			leaveRoom(roomID, userID, conn)
+		})
+		return true
+	}
+	return false
+}
+
+// CanResume returns true if the token resumes the session of the user in the room
+func (r *RoomMap) CanResume(roomID string, userID string, resumeToken string) bool {
+	r.Mutex.RLock()
+	defer r.Mutex.RUnlock()
+
+	room, ok := r.Map[roomID]
+	if !ok || room.banned[userID] {
+		return false
+	}
+	_, found := room.resumable(userID, resumeToken)
+	return found
+}
+
+// resumable returns the index of the admitted participant matching the resume token. The caller must hold the lock.
+func (room *Room) resumable(userID string, resumeToken string) (int, bool) {
+	for i, p := range room.Participants {
+		if p.UserID == userID && subtle.ConstantTimeCompare([]byte(p.resumeToken), []byte(resumeToken)) == 1 {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// Resume gives the slot of the participant to the new connection and replays the messages after lastSeq.
+// The previous connection is closed if the server did not notice it dropped yet.
+func (r *RoomMap) Resume(roomID string, userID string, resumeToken string, lastSeq uint64, conn *websocket.Conn) (Participant, error) {
+	r.Mutex.Lock()
+	defer r.Mutex.Unlock()
+
+	room, ok := r.Map[roomID]
+	if !ok || room.banned[userID] {
+		return Participant{}, ErrCannotResume
+	}
+	i, found := room.resumable(userID, resumeToken)
+	if !found {
+		return Participant{}, ErrCannotResume
+	}
+
+	room.endSuspension(userID)
+	p := room.Participants[i]
+	p.writer.close()
+	p.Conn = conn
+	p.writer = newConnWriter(userID, conn)
+	room.Participants[i] = p
+	room.LastActivity = time.Now()
+
+	messages, truncated := room.missed(userID, lastSeq)
+	slog.Info("Session resumed", "roomID", roomID, "userID", userID, "replayed", len(messages), "truncated", truncated)
+
+	room.sendSession(p, true, truncated)
+	for _, message := range messages {
+		p.writer.send(message)
+	}
+	return p, nil
+}
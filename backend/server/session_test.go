@@ -0,0 +1,80 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/invite"
+)
+
+// TestResume tests that a dropped participant keeps its slot and receives the messages it missed
+func TestResume(t *testing.T) {
+	AllRooms.Init()
+	srv := httptest.NewServer(http.HandlerFunc(JoinRoomRequestHandler))
+	defer srv.Close()
+
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	alice := dial(t, srv.URL, roomID, "alice", invite.ROLE_HOST)
+	var session SessionPayload
+	json.Unmarshal(expectMessage(t, alice, MESSAGE_SESSION).Payload, &session)
+	if session.ResumeToken == "" {
+		t.Fatal("expected a resume token on join")
+	}
+
+	bob := dial(t, srv.URL, roomID, "bob", invite.ROLE_GUEST)
+	lastSeq := expectMessage(t, alice, MESSAGE_PARTICIPANT_JOINED).Seq
+
+	// Drop the connection without a close frame, as a network failure would
+	alice.UnderlyingConn().Close()
+	waitFor(t, func() bool {
+		AllRooms.Mutex.RLock()
+		defer AllRooms.Mutex.RUnlock()
+		_, suspended := AllRooms.Map[roomID].suspended["alice"]
+		return suspended
+	})
+	if !AllRooms.Contains(roomID, "alice") {
+		t.Fatal("expected the slot of alice to be kept")
+	}
+
+	bob.WriteJSON(Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"are you there?"}`)})
+	time.Sleep(50 * time.Millisecond)
+
+	token, _, _ := invite.Tokens.Sign(roomID, "alice", invite.ROLE_HOST)
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/join?token=" + token +
+		"&resume=" + session.ResumeToken + "&lastSeq=" + strconv.FormatUint(lastSeq, 10)
+	resumed, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatalf("resume: %v", err)
+	}
+	defer resumed.Close()
+
+	json.Unmarshal(expectMessage(t, resumed, MESSAGE_SESSION).Payload, &session)
+	if !session.Resumed || session.Truncated {
+		t.Errorf("expected a complete resume, got %+v", session)
+	}
+	if chat := expectMessage(t, resumed, MESSAGE_CHAT); chat.From != "bob" || chat.Seq <= lastSeq {
+		t.Errorf("expected the missed chat from bob to be replayed, got %+v", chat)
+	}
+
+	// The others never saw alice leave
+	bob.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	for {
+		var message Envelope
+		if err := bob.ReadJSON(&message); err != nil {
+			break
+		}
+		if message.Type == MESSAGE_PARTICIPANT_LEFT {
+			t.Error("expected bob not to be told alice left")
+		}
+	}
+}
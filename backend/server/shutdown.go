@@ -0,0 +1,103 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"profanity.com/events"
+)
+
+var (
+	// shuttingDown is set once the server stopped accepting rooms and participants
+	shuttingDown atomic.Bool
+	// Meeting reports generated in the background, waited for on shutdown
+	reports sync.WaitGroup
+)
+
+// refuseWhenShuttingDown answers 503 to the requests received while the server shuts down
+func refuseWhenShuttingDown(w http.ResponseWriter) bool {
+	if !shuttingDown.Load() {
+		return false
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(settings.ShutdownReconnectDelay.Seconds())+1))
+	http.Error(w, ErrShuttingDown.Error(), http.StatusServiceUnavailable)
+	return true
+}
+
+// CheckAccepting returns an error once the server stopped accepting rooms and participants
+func CheckAccepting(ctx context.Context) error {
+	if shuttingDown.Load() {
+		return ErrShuttingDown
+	}
+	return nil
+}
+
+// Shutdown stops accepting rooms and participants, and tells the participants to reconnect to another replica
+func (r *RoomMap) Shutdown() {
+	shuttingDown.Store(true)
+
+	message, err := newEnvelope(MESSAGE_SERVER_SHUTDOWN, ServerShutdownPayload{
+		ReconnectAfterMs: settings.ShutdownReconnectDelay.Milliseconds(),
+	})
+	if err != nil {
+		slog.Error("Error marshaling server shutdown", "err", err)
+		return
+	}
+
+	r.Mutex.RLock()
+	roomIDs := make([]string, 0, len(r.Map))
+	for roomID := range r.Map {
+		roomIDs = append(roomIDs, roomID)
+	}
+	r.Mutex.RUnlock()
+
+	slog.Info("Telling the participants the server shuts down", "rooms", len(roomIDs))
+	for _, roomID := range roomIDs {
+		// Only the participants of this replica are leaving
+		r.Broadcast(broadcastMsg{Message: message, RoomID: roomID, Local: true})
+	}
+}
+
+// CloseAll disconnects the participants of every room, and waits for the meeting reports until the context is done
+func (r *RoomMap) CloseAll(ctx context.Context) error {
+	r.Mutex.Lock()
+	closing := make([]*Room, 0, len(r.Map))
+	for roomID, room := range r.Map {
+		for userID := range room.suspended {
+			room.endSuspension(userID)
+		}
+		delete(r.Map, roomID)
+		closing = append(closing, room)
+	}
+	r.Mutex.Unlock()
+
+	for _, room := range closing {
+		// Closing the writers ends the read loops of the participants
+		for _, p := range room.Participants {
+			p.writer.close()
+			leaveShared(room.ID, p.UserID)
+		}
+		for _, p := range room.Waiting {
+			p.writer.close()
+		}
+		r.release(room, events.ROOM_CLOSED)
+	}
+
+	generated := make(chan struct{})
+	go func() {
+		reports.Wait()
+		close(generated)
+	}()
+
+	select {
+	case <-generated:
+		return nil
+	case <-ctx.Done():
+		slog.Warn("Meeting reports still generating at shutdown", "err", ctx.Err())
+		return ctx.Err()
+	}
+}
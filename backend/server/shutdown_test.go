@@ -0,0 +1,65 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/invite"
+)
+
+// TestShutdown tests that the participants are told to reconnect, that new joins are refused, and that the rooms are closed
+func TestShutdown(t *testing.T) {
+	AllRooms.Init()
+	t.Cleanup(func() { shuttingDown.Store(false) })
+
+	srv := httptest.NewServer(http.HandlerFunc(JoinRoomRequestHandler))
+	defer srv.Close()
+
+	roomID, err := AllRooms.CreateRoom("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alice := dial(t, srv.URL, roomID, "alice", invite.ROLE_HOST)
+	expectMessage(t, alice, MESSAGE_SESSION)
+
+	AllRooms.Shutdown()
+	var payload ServerShutdownPayload
+	json.Unmarshal(expectMessage(t, alice, MESSAGE_SERVER_SHUTDOWN).Payload, &payload)
+	if payload.ReconnectAfterMs != settings.ShutdownReconnectDelay.Milliseconds() {
+		t.Errorf("expected a reconnect hint, got %+v", payload)
+	}
+
+	token, _, _ := invite.Tokens.Sign(roomID, "bob", invite.ROLE_GUEST)
+	_, resp, err := websocket.DefaultDialer.Dial("ws"+srv.URL[len("http"):]+"/join?token="+token, nil)
+	if err == nil || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("expected the join to be refused while shutting down, got %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := AllRooms.CloseAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if AllRooms.Exists(roomID) {
+		t.Error("expected the room to be closed")
+	}
+	alice.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		_, _, err := alice.ReadMessage()
+		if err == nil {
+			continue
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			t.Error("expected the connection of alice to be closed")
+		}
+		break
+	}
+}
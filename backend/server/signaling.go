@@ -2,82 +2,250 @@ package server
 
 import (
 	"encoding/json"
+	"errors"
 	"log/slog"
+	"net"
 	"net/http"
+	"strconv"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
+	"profanity.com/auth"
+	"profanity.com/events"
+	"profanity.com/heartbeat"
+	"profanity.com/invite"
 )
 
 var AllRooms RoomMap
 
-// CreateRoomRequestHandler handles the request to create a new room
+// CreateRoomRequestHandler handles the request to create a new room, optionally protected by a password
 func CreateRoomRequestHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	roomID := AllRooms.CreateRoom()
+	if auth.AllowCORS(w, r) || refuseWhenShuttingDown(w) {
+		return
+	}
+	identity, ok := auth.Require(w, r)
+	if !ok || !limitCreation(w, r) {
+		return
+	}
+	if identity.Role == invite.ROLE_GUEST {
+		http.Error(w, "Guests cannot create rooms", http.StatusForbidden)
+		return
+	}
+
+	password := r.FormValue("password")
+	if len(password) > MAX_PASSWORD_SIZE {
+		http.Error(w, "Password is too long", http.StatusBadRequest)
+		return
+	}
+
+	roomID, err := AllRooms.CreateRoom(password)
+	if err != nil {
+		slog.Error("Room creation failed", "err", err)
+		http.Error(w, "Room creation failed", http.StatusInternalServerError)
+		return
+	}
+
+	// The creator is the host, it shares the invite with the guests who redeem it to join.
+	// An authenticated creator hosts under its own identity, and the room is kept to its tenant.
+	hostID := identity.UserID
+	if hostID == "" {
+		hostID = uuid.New().String()
+	}
+	hostToken, claims, err := invite.Tokens.SignInTenant(roomID, hostID, invite.ROLE_HOST, identity.Tenant)
+	if err != nil {
+		slog.Error("Host token signing failed", "err", err)
+		http.Error(w, "Room creation failed", http.StatusInternalServerError)
+		return
+	}
+	inviteToken, _, err := invite.Tokens.SignInvite(roomID, identity.Tenant)
+	if err != nil {
+		slog.Error("Invite token signing failed", "err", err)
+		http.Error(w, "Room creation failed", http.StatusInternalServerError)
+		return
+	}
 
-	// Return the roomID as a JSON response
-	json.NewEncoder(w).Encode(RoomCreationResponse{RoomID: roomID})
+	// Return the roomID and the tokens as a JSON response
+	json.NewEncoder(w).Encode(RoomCreationResponse{
+		RoomID:      roomID,
+		UserID:      hostID,
+		HostToken:   hostToken,
+		InviteToken: inviteToken,
+		ExpiresAt:   time.Unix(claims.ExpiresAt, 0),
+	})
 }
 
-var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool {
-		return true
-	},
+// requireHost returns the claims of the host invite token of the request, and answers 401 when it is missing,
+// or 403 when the authenticated user is not its holder
+func requireHost(w http.ResponseWriter, r *http.Request, roomID string) (invite.Claims, bool) {
+	identity, ok := auth.Require(w, r)
+	if !ok {
+		return invite.Claims{}, false
+	}
+	claims, err := invite.Tokens.Verify(invite.FromRequest(r))
+	if err != nil || claims.RoomID != roomID || claims.Role != invite.ROLE_HOST {
+		http.Error(w, "A host token of the room is required", http.StatusUnauthorized)
+		return invite.Claims{}, false
+	}
+	if _, err := identity.Apply(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return invite.Claims{}, false
+	}
+	return claims, true
 }
 
-type broadcastMsg struct {
-	Message map[string]interface{}
-	RoomID  string
-	UserID  string
-	Client  *websocket.Conn
+// InviteRequestHandler issues a new guest invite token, on behalf of a host of the room
+func InviteRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if auth.AllowCORS(w, r) {
+		return
+	}
+	roomID := r.PathValue("id")
+
+	claims, ok := requireHost(w, r, roomID)
+	if !ok {
+		return
+	}
+	if !AllRooms.Exists(roomID) {
+		http.Error(w, ErrRoomNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	userID := uuid.New().String()
+	token, inviteClaims, err := invite.Tokens.SignInTenant(roomID, userID, invite.ROLE_GUEST, claims.Tenant)
+	if err != nil {
+		slog.Error("Invite token signing failed", "err", err)
+		http.Error(w, "Invite creation failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(InviteResponse{
+		RoomID:    roomID,
+		UserID:    userID,
+		Token:     token,
+		ExpiresAt: time.Unix(inviteClaims.ExpiresAt, 0),
+	})
 }
 
-var broadcast = make(chan broadcastMsg)
+// RedeemInviteRequestHandler exchanges the invite shared with the guests for a token of their own, each redemption
+// joining as a new user. The password of the room is checked here, not on the joins with the token.
+func RedeemInviteRequestHandler(w http.ResponseWriter, r *http.Request) {
+	if auth.AllowCORS(w, r) || refuseWhenShuttingDown(w) {
+		return
+	}
+	identity, ok := auth.Require(w, r)
+	if !ok {
+		return
+	}
+	roomID := r.PathValue("id")
 
-// broadcaster is a goroutine that listens for messages from the broadcast channel and sends them to all clients in the room
-func broadcaster() {
-	for {
-		msg := <-broadcast
-		for _, client := range AllRooms.Map[msg.RoomID] {
-			// Don't send the message back to the sender
-			if client.UserID != msg.UserID {
-				// Check if the connection is still open
-				if err := client.Conn.WriteControl(websocket.PingMessage, []byte{}, time.Now().Add(time.Second)); err != nil {
-					slog.Error("Client connection is closed", "err", err)
-					AllRooms.DeleteFromRoom(msg.RoomID, client.UserID)
-					continue
-				}
-
-				err := client.Conn.WriteJSON(msg.Message)
-				if err != nil {
-					slog.Error("An error occur while writing", "err", err)
-				}
-			}
-		}
+	claims, err := invite.Tokens.Verify(invite.FromRequest(r))
+	if err == nil && (claims.RoomID != roomID || !claims.Shared) {
+		err = invite.ErrInvalidToken
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if _, err := identity.Apply(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	// The room may have been created on another replica
+	if err := AllRooms.Open(roomID); err != nil {
+		http.Error(w, err.Error(), joinErrorStatus(err))
+		return
+	}
+	password := r.FormValue("password")
+	if len(password) > MAX_PASSWORD_SIZE || !AllRooms.CheckPassword(roomID, password) {
+		slog.Info("Invite redemption refused", "roomID", roomID, "reason", "wrong password")
+		http.Error(w, "Wrong room password", http.StatusUnauthorized)
+		return
+	}
+
+	userID := uuid.New().String()
+	token, guestClaims, err := invite.Tokens.SignInTenant(roomID, userID, invite.ROLE_GUEST, claims.Tenant)
+	if err != nil {
+		slog.Error("Guest token signing failed", "err", err)
+		http.Error(w, "Invite redemption failed", http.StatusInternalServerError)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(InviteResponse{
+		RoomID:    roomID,
+		UserID:    userID,
+		Token:     token,
+		ExpiresAt: time.Unix(guestClaims.ExpiresAt, 0),
+	})
 }
 
-func init() {
-	go broadcaster()
+var upgrader = websocket.Upgrader{
+	CheckOrigin: auth.CheckOrigin,
+}
+
+type broadcastMsg struct {
+	Message Envelope
+	RoomID  string
+	UserID  string
+	Client  *websocket.Conn
+	// To restricts the delivery to a single participant when set
+	To string
+	// Local keeps the message on this replica, it is not relayed to the others
+	Local bool
 }
 
 // JoinRoomRequestHandler handles the request to join a room and listen on the websocket connection
 func JoinRoomRequestHandler(w http.ResponseWriter, r *http.Request) {
-	roomID := r.URL.Query().Get("roomID")
-	if roomID == "" {
-		slog.Info("RoomID missing in URL Parameters")
-		http.Error(w, "RoomID missing in URL Parameters", http.StatusBadRequest)
+	if refuseWhenShuttingDown(w) {
+		return
+	}
+
+	// Unauthenticated callers are refused before the upgrade
+	identity, ok := auth.Require(w, r)
+	if !ok {
+		return
+	}
+
+	// The room and the role come from the signed invite token, the user from the access token when authentication is enabled.
+	// The invite shared with the guests is redeemed first, for a user of their own.
+	claims, err := invite.Tokens.VerifyPersonal(invite.FromRequest(r))
+	if err != nil {
+		slog.Info("Join refused", "reason", err)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+	if claims, err = identity.Apply(claims); err != nil {
+		slog.Info("Join refused", "roomID", claims.RoomID, "userID", identity.UserID, "reason", err)
+		http.Error(w, err.Error(), http.StatusForbidden)
 		return
 	}
+	roomID := claims.RoomID
+	userID := claims.UserID
+	host := claims.Role == invite.ROLE_HOST
 
-	userID := r.URL.Query().Get("userID")
-	if userID == "" {
-		slog.Info("userID missing in URL Parameters")
-		http.Error(w, "userID missing in URL Parameters", http.StatusBadRequest)
+	// The room may have been created on another replica
+	if err := AllRooms.Open(roomID); err != nil {
+		slog.Info("Join refused", "roomID", roomID, "userID", userID, "reason", err)
+		http.Error(w, err.Error(), joinErrorStatus(err))
 		return
 	}
 
+	// A participant coming back from a network drop takes its slot back instead of joining again
+	resumeToken := r.URL.Query().Get("resume")
+	resuming := resumeToken != "" && AllRooms.CanResume(roomID, userID, resumeToken)
+
+	if !resuming {
+		if err := AllRooms.CanJoin(roomID, userID, host); err != nil {
+			slog.Info("Join refused", "roomID", roomID, "userID", userID, "reason", err)
+			http.Error(w, err.Error(), joinErrorStatus(err))
+			return
+		}
+	}
+
 	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		slog.Error("WebSocket connection upgrade failed", "Error", err)
@@ -85,15 +253,54 @@ func JoinRoomRequestHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	defer wsConn.Close()
 
-	AllRooms.InsertIntoRoom(roomID, userID, wsConn)
+	wsConn.SetReadLimit(MAX_FRAME_SIZE)
+	pongTimeout := settings.PongTimeout
+	heartbeat.ExpectPongs(wsConn, pongTimeout)
+
+	var participant Participant
+	var admitted bool
+	if resuming {
+		lastSeq, _ := strconv.ParseUint(r.URL.Query().Get("lastSeq"), 10, 64)
+		participant, err = AllRooms.Resume(roomID, userID, resumeToken, lastSeq, wsConn)
+		admitted = true
+	} else {
+		if settings.DuplicateSessionPolicy == DUPLICATE_POLICY_REPLACE {
+			replaceSession(roomID, userID)
+		}
+		participant, admitted, err = AllRooms.InsertIntoRoom(roomID, userID, wsConn, host)
+	}
+	if err != nil {
+		// The room changed since the check, nobody else writes on the connection yet
+		slog.Info("Join refused", "roomID", roomID, "userID", userID, "reason", err)
+		wsConn.WriteJSON(newErrorEnvelope("", newProtocolError(joinErrorCode(err), "%v", err)))
+		return
+	}
+	defer participant.writer.close()
+
+	switch {
+	case resuming:
+		sendParticipants(roomID, userID)
+		if state, ok := stateEnvelope(roomID); ok {
+			AllRooms.SendTo(roomID, userID, state)
+		}
+	case admitted:
+		announceJoin(roomID, userID)
+	default:
+		announceWaiting(roomID, userID)
+	}
 
 	// This is the main loop that listens for messages from the client
+	limiter := newMessageLimiter()
+	chats := newChatQueue(roomID, userID)
+	defer chats.close()
 	for {
-		var message map[string]interface{}
-		err := wsConn.ReadJSON(&message)
+		messageType, data, err := wsConn.ReadMessage()
+		if err == nil {
+			heartbeat.Extend(wsConn, pongTimeout)
+		}
 
 		if err != nil {
-			slog.Error("Error reading JSON", "err", err)
+			slog.Error("Error reading message", "err", err)
 
 			if websocket.IsCloseError(err, websocket.CloseGoingAway) {
 				slog.Warn("Client is going away", "userID", userID)
@@ -101,17 +308,203 @@ func JoinRoomRequestHandler(w http.ResponseWriter, r *http.Request) {
 			if websocket.IsCloseError(err, websocket.CloseNoStatusReceived) {
 				slog.Warn("Client close without notifying", "userID", userID)
 			}
-			AllRooms.DeleteFromRoom(roomID, userID)
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				slog.Warn("Client stopped answering the pings", "userID", userID)
+			}
+			// A dropped connection keeps its slot for a while, a closed one leaves now
+			closed := websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+			if !closed && AllRooms.Suspend(roomID, userID, wsConn) {
+				return
+			}
+			leaveRoom(roomID, userID, wsConn)
 			return
 		}
 
+		if messageType != websocket.TextMessage {
+			sendError(roomID, userID, "", newProtocolError(ERROR_INVALID_MESSAGE, "only text messages are supported"))
+			continue
+		}
+
+		message, protocolErr := parseEnvelope(data)
+		if allowed, disconnect := limiter.allow(message.Type); !allowed {
+			limited := newErrorEnvelope(message.ID, newProtocolError(ERROR_RATE_LIMITED, "too many %s messages, slow down", bucketOf(message.Type)))
+			if disconnect {
+				// The read loop ends once the writer closed the connection
+				slog.Warn("Disconnecting a client flooding the room", "roomID", roomID, "userID", userID)
+				participant.writer.sendAndClose(limited)
+			} else {
+				participant.writer.send(limited)
+			}
+			continue
+		}
+		if protocolErr != nil {
+			slog.Warn("Invalid signaling message", "userID", userID, "err", protocolErr)
+			sendError(roomID, userID, message.ID, protocolErr)
+			continue
+		}
+
+		// The sender is always the authenticated connection, never what the client claims
+		message.From = userID
+		AllRooms.Touch(roomID)
+
+		if !AllRooms.IsAdmitted(roomID, userID) {
+			sendError(roomID, userID, message.ID, newProtocolError(ERROR_NOT_ADMITTED, "waiting for a host to admit you"))
+			continue
+		}
+
+		switch message.Type {
+		case MESSAGE_KICK, MESSAGE_MUTE, MESSAGE_LOCK_ROOM, MESSAGE_SET_WAITING_ROOM, MESSAGE_SET_TRANSCRIPTION, MESSAGE_ADMIT,
+			MESSAGE_SET_CHAT_POLICY, MESSAGE_RELEASE_CHAT:
+			if protocolErr := handleHostCommand(roomID, userID, message); protocolErr != nil {
+				sendError(roomID, userID, message.ID, protocolErr)
+			}
+			continue
+
+		case MESSAGE_CHAT:
+			if protocolErr := handleChat(roomID, userID, message, chats); protocolErr != nil {
+				sendError(roomID, userID, message.ID, protocolErr)
+			}
+			continue
+		}
+
+		if protocolErr := checkRecipient(roomID, message); protocolErr != nil {
+			sendError(roomID, userID, message.ID, protocolErr)
+			continue
+		}
+
 		broadcastMsg := broadcastMsg{
 			Message: message,
 			RoomID:  roomID,
 			UserID:  userID,
 			Client:  wsConn,
+			To:      message.To,
+		}
+
+		AllRooms.Broadcast(broadcastMsg)
+
+		if message.Type == MESSAGE_HANG_UP {
+			// The final report may wait for the summary, the read loop goes on meanwhile
+			reports.Add(1)
+			go func() {
+				defer reports.Done()
+				pushReport(roomID, userID)
+			}()
+		}
+	}
+}
+
+// sendError sends an error frame back to the sender of an invalid message
+func sendError(roomID string, userID string, id string, err *protocolError) {
+	AllRooms.SendTo(roomID, userID, newErrorEnvelope(id, err))
+}
+
+// replaceSession closes the previous connection of the user, if any, to make room for the new one
+func replaceSession(roomID string, userID string) {
+	previous, admitted, found := AllRooms.Evict(roomID, userID)
+	if !found {
+		return
+	}
+
+	slog.Info("Replacing the previous connection", "roomID", roomID, "userID", userID)
+	replaced, _ := newEnvelope(MESSAGE_REPLACED, nil)
+	previous.writer.sendAndClose(replaced)
+	if admitted {
+		announceLeave(roomID, userID)
+	}
+}
+
+// joinErrorStatus returns the HTTP status of a refused join
+func joinErrorStatus(err error) int {
+	switch err {
+	case ErrRoomNotFound:
+		return http.StatusNotFound
+	case ErrRoomFull, ErrDuplicateUser:
+		return http.StatusConflict
+	case ErrBanned, ErrRoomLocked:
+		return http.StatusForbidden
+	default:
+		return http.StatusServiceUnavailable
+	}
+}
+
+// joinErrorCode returns the error code of a refused join
+func joinErrorCode(err error) string {
+	switch err {
+	case ErrRoomFull:
+		return ERROR_ROOM_FULL
+	case ErrRoomLocked:
+		return ERROR_ROOM_LOCKED
+	case ErrDuplicateUser:
+		return ERROR_DUPLICATE_USER
+	case ErrCannotResume:
+		return ERROR_CANNOT_RESUME
+	default:
+		return ERROR_FORBIDDEN
+	}
+}
+
+// checkRecipient checks that a message can be delivered in the room.
+// Negotiation messages must be addressed to a peer once the room is a mesh of more than two participants.
+func checkRecipient(roomID string, message Envelope) *protocolError {
+	if message.To != "" {
+		if message.To == message.From || !AllRooms.IsMember(roomID, message.To) {
+			return newProtocolError(ERROR_UNKNOWN_RECIPIENT, "%q is not a peer in the room", message.To)
 		}
+		return nil
+	}
+
+	switch message.Type {
+	case MESSAGE_OFFER, MESSAGE_ANSWER, MESSAGE_ICE_CANDIDATE:
+		if len(AllRooms.knownMembers(roomID)) > 2 {
+			return newProtocolError(ERROR_RECIPIENT_REQUIRED, "%s must be addressed to a peer with the to field", message.Type)
+		}
+	}
+	return nil
+}
 
-		broadcast <- broadcastMsg
+// leaveRoom removes the connection of the participant from the room and tells the others
+func leaveRoom(roomID string, userID string, conn *websocket.Conn) {
+	if AllRooms.DeleteFromRoom(roomID, userID, conn) {
+		announceLeave(roomID, userID)
 	}
+	broadcastState(roomID)
+}
+
+// announceJoin sends the list of peers to the new participant and announces it to the others
+func announceJoin(roomID string, userID string) {
+	events.Publish(events.PARTICIPANT_JOINED, roomID, userID, nil)
+	joinShared(roomID, userID)
+	sendParticipants(roomID, userID)
+	sendChatHistory(roomID, userID)
+
+	joined, _ := newEnvelope(MESSAGE_PARTICIPANT_JOINED, nil)
+	joined.From = userID
+	AllRooms.Broadcast(broadcastMsg{Message: joined, RoomID: roomID, UserID: userID})
+}
+
+// sendParticipants sends the list of its peers to the participant
+func sendParticipants(roomID string, userID string) {
+	peers := []string{}
+	for _, peerID := range AllRooms.Members(roomID) {
+		if peerID != userID {
+			peers = append(peers, peerID)
+		}
+	}
+
+	participants, err := newEnvelope(MESSAGE_PARTICIPANTS, ParticipantsPayload{Participants: peers})
+	if err != nil {
+		slog.Error("Error marshaling participants", "err", err)
+		return
+	}
+	AllRooms.Broadcast(broadcastMsg{Message: participants, RoomID: roomID, To: userID})
+}
+
+// announceLeave tells the remaining participants that a peer left the room
+func announceLeave(roomID string, userID string) {
+	events.Publish(events.PARTICIPANT_LEFT, roomID, userID, nil)
+
+	left, _ := newEnvelope(MESSAGE_PARTICIPANT_LEFT, nil)
+	left.From = userID
+	AllRooms.Broadcast(broadcastMsg{Message: left, RoomID: roomID, UserID: userID})
 }
@@ -1,21 +1,215 @@
 package server
 
 import (
+	"encoding/json"
 	"sync"
+	"time"
 
 	"github.com/gorilla/websocket"
+	"profanity.com/transcription"
 )
 
 type Participant struct {
 	UserID string
 	Conn   *websocket.Conn
+	writer *connWriter
+	Host   bool
+	// resumeToken lets the participant take its slot back after a network drop
+	resumeToken string
+	// JoinedAt is the time the participant connected, or was admitted from the waiting room
+	JoinedAt time.Time
+}
+
+type Room struct {
+	ID           string
+	Participants []Participant
+	CreatedAt    time.Time
+	LastActivity time.Time
+	// joined is true once a participant entered the room
+	joined bool
+
+	// Settings controlled by the hosts
+	Locked      bool
+	WaitingRoom bool
+	// Joiners held until a host admits them
+	Waiting          []Participant
+	passwordHash     []byte
+	banned           map[string]bool
+	muted            map[string]bool
+	transcriptionOff map[string]bool
+
+	// Sequence number of the last room message, and the last messages kept for the resuming participants
+	seq     uint64
+	history []historyEntry
+	// Participants whose connection dropped, removed when their grace period ends
+	suspended   map[string]*time.Timer
+	historySize int
+	gracePeriod time.Duration
+
+	// unsubscribe stops relaying the messages of the other replicas
+	unsubscribe func()
+	// members are the participants on every replica as last read from the bus, kept up to date by the
+	// arrivals and departures. Nil until read.
+	members map[string]bool
+
+	// Chat of the room, and the flagged messages waiting for a host
+	ChatPolicy string
+	chat       []ChatMessage
+	held       map[string]ChatMessage
+}
+
+// historyEntry is a room message with its delivery scope
+type historyEntry struct {
+	message Envelope
+	from    string
+	to      string
 }
 
 type RoomMap struct {
 	Mutex sync.RWMutex
-	Map   map[string][]Participant
+	Map   map[string]*Room
 }
 
 type RoomCreationResponse struct {
-	RoomID string `json:"room_id"`
+	RoomID      string    `json:"room_id"`
+	UserID      string    `json:"user_id"`
+	HostToken   string    `json:"host_token"`
+	InviteToken string    `json:"invite_token"`
+	ExpiresAt   time.Time `json:"expires_at"`
+}
+
+type InviteResponse struct {
+	RoomID    string    `json:"room_id"`
+	UserID    string    `json:"user_id"`
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// Envelope is the signaling message exchanged on /join
+type Envelope struct {
+	Version int             `json:"version,omitempty"`
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	From    string          `json:"from,omitempty"`
+	To      string          `json:"to,omitempty"`
+	Seq     uint64          `json:"seq,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+type SessionDescriptionPayload struct {
+	Type string `json:"type"`
+	SDP  string `json:"sdp"`
+}
+
+type IceCandidatePayload struct {
+	Candidate        string  `json:"candidate"`
+	SdpMid           *string `json:"sdpMid"`
+	SdpMLineIndex    *uint16 `json:"sdpMLineIndex"`
+	UsernameFragment *string `json:"usernameFragment"`
+}
+
+type ChatPayload struct {
+	Text string `json:"text"`
+}
+
+type ParticipantsPayload struct {
+	Participants []string `json:"participants"`
+}
+
+// HostCommandPayload is the payload of the moderation commands sent by the hosts
+type HostCommandPayload struct {
+	UserID    string `json:"userID,omitempty"`
+	Enabled   *bool  `json:"enabled,omitempty"`
+	Policy    string `json:"policy,omitempty"`
+	MessageID string `json:"messageID,omitempty"`
+}
+
+// ChatMessage is a chat message delivered by the server once classified
+type ChatMessage struct {
+	ID             string    `json:"id"`
+	From           string    `json:"from"`
+	Text           string    `json:"text"`
+	ProfanityScore float64   `json:"profanityScore"`
+	Redacted       bool      `json:"redacted,omitempty"`
+	SentAt         time.Time `json:"sentAt"`
+}
+
+type ChatHistoryPayload struct {
+	Messages []ChatMessage `json:"messages"`
+}
+
+type RoomStatePayload struct {
+	Locked           bool     `json:"locked"`
+	WaitingRoom      bool     `json:"waitingRoom"`
+	Hosts            []string `json:"hosts"`
+	Waiting          []string `json:"waiting"`
+	Muted            []string `json:"muted"`
+	TranscriptionOff []string `json:"transcriptionOff"`
+	ChatPolicy       string   `json:"chatPolicy"`
+}
+
+// SessionPayload is sent on join and on resume. The client reconnects with the token and the last seq it received.
+type SessionPayload struct {
+	ResumeToken string `json:"resumeToken"`
+	Seq         uint64 `json:"seq"`
+	Resumed     bool   `json:"resumed"`
+	// Truncated is true when messages were missed beyond the kept history
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// ServerShutdownPayload tells the participants to reconnect, the server they are connected to is going away
+type ServerShutdownPayload struct {
+	ReconnectAfterMs int64 `json:"reconnectAfterMs"`
+}
+
+type ErrorPayload struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// AdminRoomSummary is a room in the room list of the admin API
+type AdminRoomSummary struct {
+	ID           string    `json:"id"`
+	CreatedAt    time.Time `json:"created_at"`
+	LastActivity time.Time `json:"last_activity"`
+	Participants int       `json:"participants"`
+	Waiting      int       `json:"waiting"`
+	Locked       bool      `json:"locked"`
+}
+
+// AdminRoom is a room with its participants, as seen by the admin API
+type AdminRoom struct {
+	ID           string             `json:"id"`
+	CreatedAt    time.Time          `json:"created_at"`
+	LastActivity time.Time          `json:"last_activity"`
+	Locked       bool               `json:"locked"`
+	WaitingRoom  bool               `json:"waiting_room"`
+	ChatPolicy   string             `json:"chat_policy"`
+	Participants []AdminParticipant `json:"participants"`
+	Waiting      []AdminParticipant `json:"waiting"`
+}
+
+// AdminParticipant is a participant of a room, as seen by the admin API
+type AdminParticipant struct {
+	UserID           string                `json:"user_id"`
+	Host             bool                  `json:"host"`
+	JoinedAt         time.Time             `json:"joined_at"`
+	Connected        bool                  `json:"connected"`
+	Muted            bool                  `json:"muted"`
+	TranscriptionOff bool                  `json:"transcription_off"`
+	Transcription    *transcription.Status `json:"transcription,omitempty"`
+}
+
+// chatQueue holds the chat messages of a participant during their classification
+type chatQueue struct {
+	roomID  string
+	userID  string
+	pending chan pendingChat
+	done    chan struct{}
+}
+
+// pendingChat is a chat message waiting for its classification, with the ID of the message of the client
+type pendingChat struct {
+	id   string
+	text string
 }
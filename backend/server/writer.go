@@ -0,0 +1,158 @@
+package server
+
+import (
+	"errors"
+	"log/slog"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/metrics"
+)
+
+type outboundMsg struct {
+	message Envelope
+	// closeAfter closes the connection once the message is written
+	closeAfter bool
+}
+
+// connWriter owns the writes of a websocket connection, a slow client only fills its own queue
+type connWriter struct {
+	userID       string
+	conn         *websocket.Conn
+	queue        chan outboundMsg
+	done         chan struct{}
+	once         sync.Once
+	policy       string
+	writeTimeout time.Duration
+	pingInterval time.Duration
+	// lost is true when the connection failed, rather than being closed by the server
+	lost atomic.Bool
+}
+
+// newConnWriter creates the writer of the connection and starts its goroutine
+func newConnWriter(userID string, conn *websocket.Conn) *connWriter {
+	w := &connWriter{
+		userID: userID,
+		conn:   conn,
+		queue:  make(chan outboundMsg, settings.OutboundQueueSize),
+		done:   make(chan struct{}),
+
+		policy:       settings.OutboundQueuePolicy,
+		writeTimeout: settings.WriteTimeout,
+		pingInterval: settings.PingInterval,
+	}
+	go w.run()
+	return w
+}
+
+// run writes the queued messages and pings the client until the writer is closed
+func (w *connWriter) run() {
+	var ping <-chan time.Time
+	if w.pingInterval > 0 {
+		ticker := time.NewTicker(w.pingInterval)
+		defer ticker.Stop()
+		ping = ticker.C
+	}
+
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-ping:
+			if err := w.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(w.writeTimeout)); err != nil {
+				slog.Warn("Ping failed", "userID", w.userID, "err", err)
+				metrics.WebSocketWriteErrors.WithLabelValues(metrics.ENDPOINT_JOIN).Inc()
+				w.markLost(err)
+				w.close()
+				return
+			}
+		case msg := <-w.queue:
+			deadline := time.Now().Add(w.writeTimeout)
+			w.conn.SetWriteDeadline(deadline)
+			if err := w.conn.WriteJSON(msg.message); err != nil {
+				slog.Error("An error occur while writing", "userID", w.userID, "err", err)
+				metrics.WebSocketWriteErrors.WithLabelValues(metrics.ENDPOINT_JOIN).Inc()
+				w.markLost(err)
+				w.close()
+				return
+			}
+			if msg.closeAfter {
+				w.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, msg.message.Type), deadline)
+				w.close()
+				return
+			}
+		}
+	}
+}
+
+// send queues the message without blocking and applies the queue policy when it is full.
+// It returns false if the message was not queued.
+func (w *connWriter) send(msg Envelope) bool {
+	return w.enqueue(outboundMsg{message: msg})
+}
+
+// sendAndClose queues the last message of the connection, which is closed once it is written
+func (w *connWriter) sendAndClose(msg Envelope) {
+	if !w.enqueue(outboundMsg{message: msg, closeAfter: true}) {
+		w.close()
+	}
+}
+
+// enqueue queues the message without blocking and applies the queue policy when it is full
+func (w *connWriter) enqueue(msg outboundMsg) bool {
+	select {
+	case <-w.done:
+		return false
+	default:
+	}
+
+	select {
+	case w.queue <- msg:
+		return true
+	default:
+	}
+
+	if w.policy == QUEUE_POLICY_DROP {
+		slog.Warn("Outbound queue full, dropping message", "userID", w.userID, "type", msg.message.Type)
+		return false
+	}
+
+	slog.Warn("Outbound queue full, disconnecting client", "userID", w.userID)
+	w.close()
+	return false
+}
+
+// markLost records that the connection failed, unless the server already closed it.
+// A client too slow to read within the timeout is disconnected, as with a full queue.
+func (w *connWriter) markLost(err error) {
+	select {
+	case <-w.done:
+		return
+	default:
+	}
+
+	var netErr net.Error
+	w.lost.Store(!errors.As(err, &netErr) || !netErr.Timeout())
+}
+
+// closedByServer returns true if the server closed the connection on purpose,
+// when the queue policy disconnected the client or a host removed it
+func (w *connWriter) closedByServer() bool {
+	select {
+	case <-w.done:
+		return !w.lost.Load()
+	default:
+		return false
+	}
+}
+
+// close stops the writer and closes the connection, which ends the read loop of the participant
+func (w *connWriter) close() {
+	w.once.Do(func() {
+		close(w.done)
+		w.conn.Close()
+	})
+}
@@ -0,0 +1,150 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/invite"
+)
+
+// dialRoom connects a guest to the room through the /join handler, creating the room if needed
+func dialRoom(t *testing.T, serverURL string, roomID string, userID string) *websocket.Conn {
+	t.Helper()
+
+	AllRooms.Mutex.Lock()
+	if _, ok := AllRooms.Map[roomID]; !ok {
+		AllRooms.newRoom(roomID)
+	}
+	AllRooms.Mutex.Unlock()
+
+	conn := dial(t, serverURL, roomID, userID, invite.ROLE_GUEST)
+
+	// Wait for the participant to be registered
+	waitFor(t, func() bool { return AllRooms.Contains(roomID, userID) })
+	return conn
+}
+
+// waitFor polls the condition until it holds, failing the test after two seconds
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition never met")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestStalledClientDoesNotBlockOtherRooms floods a client that never reads.
+// The other rooms must keep their latency and the stalled client must be disconnected.
+func TestStalledClientDoesNotBlockOtherRooms(t *testing.T) {
+	AllRooms.Init()
+	previous := settings
+	settings = defaultConfig()
+	settings.OutboundQueueSize = 8
+	settings.OutboundQueuePolicy = QUEUE_POLICY_DISCONNECT
+	settings.WriteTimeout = 500 * time.Millisecond
+	// The flood is about the outbound queues, not the rate limits
+	settings.ChatRate = 0
+	t.Cleanup(func() { settings = previous })
+
+	srv := httptest.NewServer(http.HandlerFunc(JoinRoomRequestHandler))
+	defer srv.Close()
+
+	dialRoom(t, srv.URL, "slow", "stalled")
+	flooder := dialRoom(t, srv.URL, "slow", "flooder")
+	alice := dialRoom(t, srv.URL, "fast", "alice")
+	bob := dialRoom(t, srv.URL, "fast", "bob")
+
+	// Flood the stalled client with enough data to fill the socket buffers
+	go func() {
+		message := Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"` + strings.Repeat("a", MAX_CHAT_SIZE-10) + `"}`)}
+		for i := 0; i < 5000; i++ {
+			if err := flooder.WriteJSON(message); err != nil {
+				return
+			}
+		}
+	}()
+
+	const messageCount = 20
+	received := make(chan time.Time, messageCount)
+	go func() {
+		for {
+			var message Envelope
+			if err := bob.ReadJSON(&message); err != nil {
+				return
+			}
+			if message.Type == MESSAGE_CHAT {
+				received <- time.Now()
+			}
+		}
+	}()
+
+	for i := 0; i < messageCount; i++ {
+		sentAt := time.Now()
+		if err := alice.WriteJSON(Envelope{Type: MESSAGE_CHAT, Payload: []byte(`{"text":"hello"}`)}); err != nil {
+			t.Fatalf("alice write: %v", err)
+		}
+
+		select {
+		case receivedAt := <-received:
+			if latency := receivedAt.Sub(sentAt); latency > 200*time.Millisecond {
+				t.Errorf("message %d took %s to reach the other room", i, latency)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatalf("message %d never reached bob", i)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for AllRooms.Contains("slow", "stalled") {
+		if time.Now().After(deadline) {
+			t.Fatal("stalled client was never disconnected")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestDeadPeerIsRemoved tests that a client which stops answering the pings is removed from the room,
+// while a client reading its connection stays
+func TestDeadPeerIsRemoved(t *testing.T) {
+	AllRooms.Init()
+	previous := settings
+	settings = defaultConfig()
+	settings.PingInterval = 20 * time.Millisecond
+	settings.PongTimeout = 100 * time.Millisecond
+	settings.ResumeGracePeriod = 0
+	t.Cleanup(func() { settings = previous })
+
+	srv := httptest.NewServer(http.HandlerFunc(JoinRoomRequestHandler))
+	defer srv.Close()
+
+	// A client never reading does not answer the pings, as a half-open connection
+	dialRoom(t, srv.URL, "heartbeat", "dead")
+	alive := dialRoom(t, srv.URL, "heartbeat", "alive")
+	go func() {
+		for {
+			if _, _, err := alive.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for AllRooms.Contains("heartbeat", "dead") {
+		if time.Now().After(deadline) {
+			t.Fatal("dead peer was never removed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !AllRooms.Contains("heartbeat", "alive") {
+		t.Error("expected the client answering the pings to stay")
+	}
+}
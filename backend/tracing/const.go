@@ -0,0 +1,26 @@
+package tracing
+
+// Exporters of the spans, tracing is off by default
+const (
+	EXPORTER_NONE   = "none"
+	EXPORTER_STDOUT = "stdout"
+	EXPORTER_OTLP   = "otlp"
+
+	DEFAULT_SERVICE_NAME = "clean-chat-backend"
+	DEFAULT_SAMPLE_RATIO = 1.0
+
+	// Instrumentation scope of the spans
+	TRACER_NAME = "profanity.com"
+)
+
+// Spans of an utterance, from the audio of the participant to the messages it receives
+const (
+	SPAN_UTTERANCE         = "utterance"
+	SPAN_RTP_RECEIVE       = "rtp.receive"
+	SPAN_OPUS_DECODE       = "opus.decode"
+	SPAN_RECOGNIZER_DECODE = "recognizer.decode"
+	SPAN_ENDPOINT          = "recognizer.endpoint"
+	SPAN_CLASSIFY          = "classifier.classify"
+	SPAN_LLM_COMPLETION    = "llm.completion"
+	SPAN_WEBSOCKET_WRITE   = "websocket.write"
+)
@@ -0,0 +1,86 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"profanity.com/config"
+)
+
+// Tracer starts the spans of the server, they are dropped until LoadConfig installs an exporter
+var Tracer = otel.Tracer(TRACER_NAME)
+
+// provider exports the spans, nil when tracing is off
+var provider *sdktrace.TracerProvider
+
+// LoadConfig installs the exporter chosen by TRACING_EXPORTER. The OTLP exporter reads its collector
+// from the standard OTEL_EXPORTER_OTLP_ENDPOINT, e.g. http://localhost:4318.
+func LoadConfig(ctx context.Context) error {
+	name := config.String("TRACING_EXPORTER", EXPORTER_NONE)
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name {
+	case EXPORTER_NONE:
+		return nil
+	case EXPORTER_STDOUT:
+		exporter, err = stdouttrace.New()
+	case EXPORTER_OTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	default:
+		return fmt.Errorf("unknown tracing exporter %q", name)
+	}
+	if err != nil {
+		return err
+	}
+
+	serviceName := config.String("OTEL_SERVICE_NAME", DEFAULT_SERVICE_NAME)
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(attribute.String("service.name", serviceName)))
+	if err != nil {
+		return err
+	}
+
+	ratio := config.Float("TRACING_SAMPLE_RATIO", DEFAULT_SAMPLE_RATIO)
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	slog.Info("Tracing enabled", "exporter", name, "service", serviceName, "ratio", ratio)
+	return nil
+}
+
+// Shutdown exports the spans left in the batch
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Inject adds the trace context of ctx to the headers of an outbound request
+func Inject(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
+
+// End records the error of the span, if any, and ends it
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,39 @@
+package transcription
+
+import (
+	"sync"
+	"time"
+)
+
+// Status is the state and the stats of the transcription connection of a participant
+type Status struct {
+	RoomID           string    `json:"room_id"`
+	UserID           string    `json:"user_id"`
+	ConnectedAt      time.Time `json:"connected_at"`
+	ConnectionState  string    `json:"connection_state"`
+	ICEState         string    `json:"ice_state"`
+	Codec            string    `json:"codec,omitempty"`
+	Streaming        bool      `json:"streaming"`
+	Utterances       int       `json:"utterances"`
+	Flags            int       `json:"flags"`
+	TalkTimeSeconds  float64   `json:"talk_time_seconds"`
+	LastTranscriptAt time.Time `json:"last_transcript_at,omitempty"`
+}
+
+type key struct {
+	roomID string
+	userID string
+}
+
+// Connection is a transcription connection registered by the WebRTC server
+type Connection struct {
+	status Status
+	// close ends the connection, from outside of its handler
+	close func()
+}
+
+// Registry holds the transcription connections of the participants
+type Registry struct {
+	mutex       sync.RWMutex
+	connections map[key]*Connection
+}
@@ -0,0 +1,144 @@
+package transcription
+
+import "time"
+
+// Connections is the transcription connection of every participant on this replica
+var Connections = NewRegistry()
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{connections: make(map[key]*Connection)}
+}
+
+// Register records the transcription connection of the participant, replacing a previous one.
+// The close function is called when the connection must end, e.g. when the participant is kicked.
+func (r *Registry) Register(roomID string, userID string, close func()) *Connection {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	c := &Connection{
+		status: Status{RoomID: roomID, UserID: userID, ConnectedAt: time.Now()},
+		close:  close,
+	}
+	r.connections[key{roomID, userID}] = c
+	return c
+}
+
+// Unregister forgets the connection, unless a newer connection of the participant replaced it
+func (r *Registry) Unregister(c *Connection) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	k := key{c.status.RoomID, c.status.UserID}
+	if r.connections[k] == c {
+		delete(r.connections, k)
+	}
+}
+
+// update applies the change to the status of the connection of the participant, if any
+func (r *Registry) update(roomID string, userID string, change func(s *Status)) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if c, ok := r.connections[key{roomID, userID}]; ok {
+		change(&c.status)
+	}
+}
+
+// SetConnectionState records the state of the peer connection of the participant
+func (r *Registry) SetConnectionState(roomID string, userID string, state string) {
+	r.update(roomID, userID, func(s *Status) { s.ConnectionState = state })
+}
+
+// SetICEState records the state of the ICE connection of the participant
+func (r *Registry) SetICEState(roomID string, userID string, state string) {
+	r.update(roomID, userID, func(s *Status) { s.ICEState = state })
+}
+
+// SetCodec records the codec of the audio track of the participant
+func (r *Registry) SetCodec(roomID string, userID string, codec string) {
+	r.update(roomID, userID, func(s *Status) { s.Codec = codec })
+}
+
+// SetStreaming records whether the participant asked for its audio to be transcribed
+func (r *Registry) SetStreaming(roomID string, userID string, streaming bool) {
+	r.update(roomID, userID, func(s *Status) { s.Streaming = streaming })
+}
+
+// AddUtterance counts a transcribed utterance of the participant
+func (r *Registry) AddUtterance(roomID string, userID string, flagged bool) {
+	r.update(roomID, userID, func(s *Status) {
+		s.Utterances++
+		if flagged {
+			s.Flags++
+		}
+		s.LastTranscriptAt = time.Now()
+	})
+}
+
+// AddTalkTime adds voiced time to the participant
+func (r *Registry) AddTalkTime(roomID string, userID string, talkTime time.Duration) {
+	r.update(roomID, userID, func(s *Status) { s.TalkTimeSeconds += talkTime.Seconds() })
+}
+
+// Get returns the status of the transcription connection of the participant
+func (r *Registry) Get(roomID string, userID string) (Status, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	c, ok := r.connections[key{roomID, userID}]
+	if !ok {
+		return Status{}, false
+	}
+	return c.status, true
+}
+
+// Close ends the transcription connection of the participant, and returns false if it has none
+func (r *Registry) Close(roomID string, userID string) bool {
+	r.mutex.RLock()
+	c, ok := r.connections[key{roomID, userID}]
+	r.mutex.RUnlock()
+
+	if ok {
+		c.close()
+	}
+	return ok
+}
+
+// CloseRoom ends the transcription connections of every participant of the room
+func (r *Registry) CloseRoom(roomID string) {
+	r.mutex.RLock()
+	closing := []*Connection{}
+	for k, c := range r.connections {
+		if k.roomID == roomID {
+			closing = append(closing, c)
+		}
+	}
+	r.mutex.RUnlock()
+
+	for _, c := range closing {
+		c.close()
+	}
+}
+
+// CloseAll ends every transcription connection
+func (r *Registry) CloseAll() {
+	r.mutex.RLock()
+	closing := make([]*Connection, 0, len(r.connections))
+	for _, c := range r.connections {
+		closing = append(closing, c)
+	}
+	r.mutex.RUnlock()
+
+	for _, c := range closing {
+		c.close()
+	}
+}
+
+// Count returns the number of transcription connections
+func (r *Registry) Count() int {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+
+	return len(r.connections)
+}
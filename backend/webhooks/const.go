@@ -0,0 +1,26 @@
+package webhooks
+
+import "time"
+
+const (
+	// Delivery of an event: attempts before the dead-letter log, and backoff between two attempts
+	DEFAULT_MAX_ATTEMPTS    = 5
+	DEFAULT_INITIAL_BACKOFF = time.Second
+	DEFAULT_MAX_BACKOFF     = time.Minute
+	DEFAULT_TIMEOUT         = 5 * time.Second
+	// Deliveries in progress per subscription, the events of a room stay in order
+	DEFAULT_WORKERS = 4
+
+	// Events waiting to be delivered to a subscription, the bus drops the events beyond
+	QUEUE_SIZE = 256
+)
+
+// Headers of the deliveries. The signature is the hex HMAC-SHA256 of "<timestamp>.<body>" with the subscription secret.
+const (
+	HEADER_SIGNATURE = "X-Webhook-Signature"
+	HEADER_TIMESTAMP = "X-Webhook-Timestamp"
+	HEADER_EVENT     = "X-Webhook-Event"
+	HEADER_ID        = "X-Webhook-ID"
+
+	SIGNATURE_PREFIX = "sha256="
+)
@@ -0,0 +1,53 @@
+package webhooks
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"profanity.com/events"
+)
+
+// Subscription is an endpoint receiving the events of the given types, or every event when none is given
+type Subscription struct {
+	URL    string   `json:"url"`
+	Secret string   `json:"secret"`
+	Events []string `json:"events"`
+}
+
+// Options tune the retries of the deliveries
+type Options struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Timeout        time.Duration
+	// Deliveries in progress per subscription, each worker delivering the events of its rooms in order
+	Workers int
+	// File appended with the deliveries that exhausted their attempts, as JSON lines. Empty logs them only.
+	DeadLetterPath string
+}
+
+// DeadLetter is an event that could not be delivered to a subscription
+type DeadLetter struct {
+	URL      string       `json:"url"`
+	Event    events.Event `json:"event"`
+	Attempts int          `json:"attempts"`
+	Error    string       `json:"error"`
+	FailedAt time.Time    `json:"failed_at"`
+}
+
+// Dispatcher delivers the events of the bus to the subscriptions
+type Dispatcher struct {
+	subscriptions []Subscription
+	options       Options
+	client        *http.Client
+
+	// Unsubscribes from the bus, and workers delivering the events of the queues
+	unsubscribe []func()
+	workers     sync.WaitGroup
+	// Stops the retries waiting for their backoff
+	stopped  chan struct{}
+	stopOnce sync.Once
+
+	deadLetterMutex sync.Mutex
+}
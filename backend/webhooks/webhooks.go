@@ -0,0 +1,242 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"profanity.com/config"
+	"profanity.com/events"
+)
+
+// errPermanent is a refusal of the endpoint that retrying will not fix
+var errPermanent = errors.New("delivery refused")
+
+// Outbox delivers the events of the internal bus to the configured subscriptions
+var Outbox = NewDispatcher(nil, Options{})
+
+// LoadConfig reads the subscriptions from the JSON file WEBHOOKS_FILE, and the delivery options from the environment
+func LoadConfig() error {
+	var subscriptions []Subscription
+	if path := config.String("WEBHOOKS_FILE", ""); path != "" {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(data, &subscriptions); err != nil {
+			return fmt.Errorf("invalid webhooks file %s: %w", path, err)
+		}
+	}
+
+	Outbox = NewDispatcher(subscriptions, Options{
+		MaxAttempts:    config.Int("WEBHOOK_MAX_ATTEMPTS", DEFAULT_MAX_ATTEMPTS),
+		InitialBackoff: config.Duration("WEBHOOK_INITIAL_BACKOFF", DEFAULT_INITIAL_BACKOFF),
+		MaxBackoff:     config.Duration("WEBHOOK_MAX_BACKOFF", DEFAULT_MAX_BACKOFF),
+		Timeout:        config.Duration("WEBHOOK_TIMEOUT", DEFAULT_TIMEOUT),
+		Workers:        config.Int("WEBHOOK_WORKERS", DEFAULT_WORKERS),
+		DeadLetterPath: config.String("WEBHOOK_DEAD_LETTER", ""),
+	})
+	slog.Info("Webhooks loaded", "subscriptions", len(subscriptions))
+	return nil
+}
+
+// NewDispatcher returns a dispatcher for the subscriptions, the unset options taking their default
+func NewDispatcher(subscriptions []Subscription, options Options) *Dispatcher {
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = DEFAULT_MAX_ATTEMPTS
+	}
+	if options.InitialBackoff <= 0 {
+		options.InitialBackoff = DEFAULT_INITIAL_BACKOFF
+	}
+	if options.MaxBackoff < options.InitialBackoff {
+		options.MaxBackoff = max(DEFAULT_MAX_BACKOFF, options.InitialBackoff)
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DEFAULT_TIMEOUT
+	}
+	if options.Workers <= 0 {
+		options.Workers = DEFAULT_WORKERS
+	}
+
+	return &Dispatcher{
+		subscriptions: subscriptions,
+		options:       options,
+		client:        &http.Client{Timeout: options.Timeout},
+		stopped:       make(chan struct{}),
+	}
+}
+
+// Start subscribes every subscription to the bus and delivers its events in the background, with a fixed number
+// of workers each. The events of a room always go to the same worker, they are delivered in order. The events
+// beyond the queue are dropped by the bus.
+func (d *Dispatcher) Start(bus *events.Bus) {
+	for _, subscription := range d.subscriptions {
+		queue, unsubscribe := bus.Subscribe(QUEUE_SIZE, subscription.Events...)
+		d.unsubscribe = append(d.unsubscribe, unsubscribe)
+
+		// A retrying delivery holds back the rooms of its worker only
+		shards := make([]chan events.Event, d.options.Workers)
+		for i := range shards {
+			shards[i] = make(chan events.Event, QUEUE_SIZE)
+			d.workers.Add(1)
+			go func() {
+				defer d.workers.Done()
+				for event := range shards[i] {
+					d.deliver(subscription, event)
+				}
+			}()
+		}
+
+		d.workers.Add(1)
+		go func() {
+			defer d.workers.Done()
+			for event := range queue {
+				shards[shardOf(event.RoomID, len(shards))] <- event
+			}
+			for _, shard := range shards {
+				close(shard)
+			}
+		}()
+	}
+}
+
+// shardOf returns the worker delivering the events of the room
+func shardOf(roomID string, workers int) int {
+	h := fnv.New32a()
+	h.Write([]byte(roomID))
+	return int(h.Sum32() % uint32(workers))
+}
+
+// Stop unsubscribes from the bus and waits for the queued events to be delivered until the context is done.
+// The retries still waiting are sent to the dead-letter log.
+func (d *Dispatcher) Stop(ctx context.Context) error {
+	for _, unsubscribe := range d.unsubscribe {
+		unsubscribe()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		// The workers end once their queue is drained
+		d.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		d.stopOnce.Do(func() { close(d.stopped) })
+		return ctx.Err()
+	}
+}
+
+// deliver sends the event to the subscription, retrying with an exponential backoff
+func (d *Dispatcher) deliver(subscription Subscription, event events.Event) {
+	body, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Error marshaling webhook event", "type", event.Type, "err", err)
+		return
+	}
+
+	backoff := d.options.InitialBackoff
+	for attempt := 1; ; attempt++ {
+		err = d.post(subscription, event, body)
+		if err == nil {
+			return
+		}
+		slog.Warn("Webhook delivery failed", "url", subscription.URL, "type", event.Type, "attempt", attempt, "err", err)
+
+		if errors.Is(err, errPermanent) || attempt >= d.options.MaxAttempts {
+			d.deadLetter(subscription, event, attempt, err)
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-d.stopped:
+			d.deadLetter(subscription, event, attempt, err)
+			return
+		}
+		backoff = min(2*backoff, d.options.MaxBackoff)
+	}
+}
+
+// post sends one signed delivery of the event
+func (d *Dispatcher) post(subscription Subscription, event events.Event, body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, subscription.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%w: %v", errPermanent, err)
+	}
+
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HEADER_ID, event.ID)
+	req.Header.Set(HEADER_EVENT, event.Type)
+	req.Header.Set(HEADER_TIMESTAMP, timestamp)
+	req.Header.Set(HEADER_SIGNATURE, SIGNATURE_PREFIX+Sign(subscription.Secret, timestamp, body))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return fmt.Errorf("endpoint answered %s", resp.Status)
+	default:
+		return fmt.Errorf("%w: endpoint answered %s", errPermanent, resp.Status)
+	}
+}
+
+// Sign returns the hex HMAC-SHA256 of the timestamp and the body, for the receivers to check the deliveries
+func Sign(secret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// deadLetter records an event that could not be delivered
+func (d *Dispatcher) deadLetter(subscription Subscription, event events.Event, attempts int, err error) {
+	slog.Error("Webhook delivery abandoned", "url", subscription.URL, "type", event.Type, "eventID", event.ID, "attempts", attempts, "err", err)
+	if d.options.DeadLetterPath == "" {
+		return
+	}
+
+	line, marshalErr := json.Marshal(DeadLetter{
+		URL:      subscription.URL,
+		Event:    event,
+		Attempts: attempts,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+	})
+	if marshalErr != nil {
+		slog.Error("Error marshaling dead letter", "err", marshalErr)
+		return
+	}
+
+	d.deadLetterMutex.Lock()
+	defer d.deadLetterMutex.Unlock()
+
+	f, openErr := os.OpenFile(d.options.DeadLetterPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if openErr != nil {
+		slog.Error("Dead-letter log unavailable", "path", d.options.DeadLetterPath, "err", openErr)
+		return
+	}
+	defer f.Close()
+	f.Write(append(line, '\n'))
+}
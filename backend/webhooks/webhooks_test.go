@@ -0,0 +1,180 @@
+package webhooks
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"profanity.com/events"
+)
+
+// TestDeliveryRetries tests that a failing endpoint is retried until it accepts the signed event
+func TestDeliveryRetries(t *testing.T) {
+	var attempts atomic.Int32
+	received := make(chan events.Event, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(HEADER_SIGNATURE) != SIGNATURE_PREFIX+Sign("secret", r.Header.Get(HEADER_TIMESTAMP), body) {
+			t.Error("invalid signature")
+		}
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		var event events.Event
+		json.Unmarshal(body, &event)
+		received <- event
+	}))
+	defer receiver.Close()
+
+	bus := events.NewBus()
+	dispatcher := NewDispatcher(
+		[]Subscription{{URL: receiver.URL, Secret: "secret", Events: []string{events.ROOM_CREATED}}},
+		Options{InitialBackoff: 10 * time.Millisecond},
+	)
+	dispatcher.Start(bus)
+
+	bus.Publish(events.Event{Type: events.ROOM_CLOSED, RoomID: "ignored"})
+	bus.Publish(events.Event{Type: events.ROOM_CREATED, RoomID: "room"})
+
+	select {
+	case event := <-received:
+		if event.Type != events.ROOM_CREATED || event.RoomID != "room" {
+			t.Errorf("expected the room creation, got %+v", event)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("event was never delivered")
+	}
+	if n := attempts.Load(); n != 3 {
+		t.Errorf("expected 3 attempts, got %d", n)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := dispatcher.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestDeadLetter tests that an event refused by the endpoint ends in the dead-letter log
+func TestDeadLetter(t *testing.T) {
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer receiver.Close()
+
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	bus := events.NewBus()
+	dispatcher := NewDispatcher(
+		[]Subscription{{URL: receiver.URL, Secret: "secret"}},
+		Options{MaxAttempts: 2, InitialBackoff: 10 * time.Millisecond, DeadLetterPath: path},
+	)
+	dispatcher.Start(bus)
+	bus.Publish(events.Event{Type: events.USER_FLAGGED, RoomID: "room", UserID: "bob"})
+
+	// Stopping waits for the delivery to exhaust its attempts
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := dispatcher.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		t.Fatal("expected a dead letter")
+	}
+	var letter DeadLetter
+	json.Unmarshal(scanner.Bytes(), &letter)
+	if letter.Attempts != 2 || letter.Event.UserID != "bob" || letter.URL != receiver.URL {
+		t.Errorf("unexpected dead letter %+v", letter)
+	}
+}
+
+// TestDeliveryWorkers tests that the deliveries in progress are bounded by the workers, that the events of a room
+// are delivered in order, and that stopping twice is safe
+func TestDeliveryWorkers(t *testing.T) {
+	var (
+		inFlight, peak atomic.Int32
+		mu             sync.Mutex
+		delivered      = make(map[string][]string)
+	)
+	release := make(chan struct{})
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if n <= old || peak.CompareAndSwap(old, n) {
+				break
+			}
+		}
+		<-release
+
+		var event events.Event
+		json.NewDecoder(r.Body).Decode(&event)
+		mu.Lock()
+		delivered[event.RoomID] = append(delivered[event.RoomID], event.UserID)
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	// Two rooms delivered by different workers
+	rooms := []string{"room-0"}
+	for i := 1; len(rooms) < 2; i++ {
+		if room := fmt.Sprintf("room-%d", i); shardOf(room, 2) != shardOf(rooms[0], 2) {
+			rooms = append(rooms, room)
+		}
+	}
+
+	bus := events.NewBus()
+	dispatcher := NewDispatcher(
+		[]Subscription{{URL: receiver.URL, Secret: "secret"}},
+		Options{Workers: 2, InitialBackoff: 10 * time.Millisecond},
+	)
+	dispatcher.Start(bus)
+	users := []string{"alice", "bob", "carol", "dave"}
+	for _, user := range users {
+		for _, room := range rooms {
+			bus.Publish(events.Event{Type: events.PARTICIPANT_JOINED, RoomID: room, UserID: user})
+		}
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := dispatcher.Stop(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if n := peak.Load(); n != 2 {
+		t.Errorf("expected 2 deliveries in progress at most, got %d", n)
+	}
+	for _, room := range rooms {
+		if !slices.Equal(delivered[room], users) {
+			t.Errorf("expected the events of %s in order, got %v", room, delivered[room])
+		}
+	}
+
+	expired, cancelExpired := context.WithCancel(context.Background())
+	cancelExpired()
+	dispatcher.Stop(expired)
+}
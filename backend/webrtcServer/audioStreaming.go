@@ -7,11 +7,9 @@ import (
 	"fmt"
 	"log/slog"
 	"os"
-	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/gorilla/websocket"
-	"github.com/pion/webrtc/v4"
 	"github.com/pion/webrtc/v4/pkg/media/oggwriter"
 )
 
@@ -33,8 +31,8 @@ func getFileAndWriter(writer *oggwriter.OggWriter) (*os.File, *oggwriter.OggWrit
 }
 
 // handleAudioStream handles the audio stream by writing it to file
-func handleAudioStream(ctx context.Context, track *webrtc.TrackRemote, isStreaming *bool, wsConn *websocket.Conn, mu *sync.Mutex) {
+func handleAudioStream(ctx context.Context, track packetReader, decoder audioDecoder, format trackFormat, roomID string, userID string, label string, isStreaming *atomic.Bool, sink resultSink) {
 
 	// This take the audio stream for ever
-	transcribe(ctx, track, isStreaming, wsConn, mu)
+	transcribe(ctx, track, decoder, format, roomID, userID, label, isStreaming, sink)
 }
@@ -0,0 +1,53 @@
+package webrtcserver
+
+import "profanity.com/config"
+
+var (
+	// Time during which the transcription session of a dropped connection is kept for it to resume
+	resumeGracePeriod = DEFAULT_RESUME_GRACE_PERIOD
+	// Interval between two pings (0 disables them) and time without a pong after which the client is dead
+	pingInterval = DEFAULT_PING_INTERVAL
+	pongTimeout  = DEFAULT_PONG_TIMEOUT
+
+	// Range of the UDP ports of the plain RTP sessions (0 picks any free port), the host announced to the
+	// senders, and the time without packets after which a session ends
+	rtpPortMin     = 0
+	rtpPortMax     = 0
+	rtpPublicHost  = ""
+	rtpIdleTimeout = DEFAULT_RTP_IDLE_TIMEOUT
+
+	// Transcription of the stereo tracks, STEREO_DOWNMIX or STEREO_SPLIT
+	stereoMode = STEREO_DOWNMIX
+)
+
+// LoadConfig reads the transcription configuration from the environment
+func LoadConfig() {
+	resumeGracePeriod = config.Duration("RESUME_GRACE_PERIOD", DEFAULT_RESUME_GRACE_PERIOD)
+	pingInterval = config.Duration("PING_INTERVAL", DEFAULT_PING_INTERVAL)
+	pongTimeout = config.Duration("PONG_TIMEOUT", DEFAULT_PONG_TIMEOUT)
+
+	rtpPortMin = config.Int("RTP_PORT_MIN", 0)
+	rtpPortMax = config.Int("RTP_PORT_MAX", rtpPortMin)
+	rtpPublicHost = config.String("RTP_PUBLIC_HOST", "")
+	rtpIdleTimeout = config.Duration("RTP_IDLE_TIMEOUT", DEFAULT_RTP_IDLE_TIMEOUT)
+	if rtpPortMin < 0 || rtpPortMax < rtpPortMin || rtpPortMax > 65535 {
+		rtpPortMin, rtpPortMax = 0, 0
+	}
+	if rtpIdleTimeout <= 0 {
+		rtpIdleTimeout = DEFAULT_RTP_IDLE_TIMEOUT
+	}
+
+	stereoMode = config.String("STEREO_MODE", STEREO_DOWNMIX)
+	if stereoMode != STEREO_SPLIT {
+		stereoMode = STEREO_DOWNMIX
+	}
+
+	if pingInterval > 0 && pongTimeout <= pingInterval {
+		pingInterval = DEFAULT_PING_INTERVAL
+		pongTimeout = DEFAULT_PONG_TIMEOUT
+	}
+	// Without pings the clients have nothing to answer, the reads have no deadline
+	if pingInterval <= 0 {
+		pongTimeout = 0
+	}
+}
@@ -1,12 +1,106 @@
 package webrtcserver
 
+import "time"
+
 const (
 	// WebRTC
 	INPUT_SAMPLE_RATE = 48000
 	MODEL_SAMPLE_RATE = 16000
 
+	// Minimal RMS energy of a decoded frame to be counted as talk time
+	VOICE_RMS_THRESHOLD = 0.01
+
+	// Tracks of a peer: the microphone is the only labeled track counted as talk time, the unlabeled tracks
+	// after the first one are labeled by their order
+	MIC_LABEL              = "mic"
+	UNLABELED_TRACK_PREFIX = "track-"
+
 	// Profanity
 	PROFANITY_ANALYSIS_BUFFER_SIZE = 7
+
+	// Transcription session kept after a network drop
+	DEFAULT_RESUME_GRACE_PERIOD = 30 * time.Second
+
+	// Heartbeats of the websocket
+	DEFAULT_PING_INTERVAL = 15 * time.Second
+	DEFAULT_PONG_TIMEOUT  = 45 * time.Second
+	PING_WRITE_TIMEOUT    = 5 * time.Second
+
+	// Write of a result to a telephony provider, a stalled provider ends the call
+	TELEPHONY_WRITE_TIMEOUT = 5 * time.Second
+
+	// Data channel of the results, negotiated by both peers with the same ID
+	DATA_CHANNEL_LABEL = "results"
+	DATA_CHANNEL_ID    = 0
+	// Bytes queued on the data channel above which the results go through the websocket, a congested channel
+	// does not delay them further
+	DATA_CHANNEL_MAX_BUFFERED = 1024 * 1024
+
+	// WHIP: largest SDP offer or trickle ICE fragment
+	MAX_SDP_SIZE = 64 * 1024
+
+	// Results queued for the event stream of an ingestion session
+	EVENT_QUEUE_SIZE = 64
+
+	// Plain RTP: largest datagram read, and time without packets after which the session ends
+	MAX_RTP_PACKET_SIZE      = 1500
+	DEFAULT_RTP_IDLE_TIMEOUT = 30 * time.Second
+
+	// Raw PCM websocket: largest binary frame, and the accepted sample rates
+	MAX_PCM_FRAME_SIZE      = 64 * 1024
+	DEFAULT_PCM_SAMPLE_RATE = 16000
+	MIN_PCM_SAMPLE_RATE     = 8000
+	MAX_PCM_SAMPLE_RATE     = 48000
+
+	// Telephony media stream: largest event, and the sample rate of a call without one
+	MAX_TELEPHONY_MESSAGE_SIZE = 64 * 1024
+	// Time given to a call to start its stream, the connection is only authorized then
+	TELEPHONY_START_TIMEOUT = 10 * time.Second
+	// Custom parameter of the start event carrying the invite token, for the providers without query strings
+	TELEPHONY_TOKEN_PARAMETER     = "token"
+	DEFAULT_TELEPHONY_SAMPLE_RATE = 8000
+)
+
+// Transcription of the stereo tracks: downmixed, or split with a transcription per channel
+const (
+	STEREO_DOWNMIX = "downmix"
+	STEREO_SPLIT   = "split"
+
+	CHANNEL_LEFT  = "left"
+	CHANNEL_RIGHT = "right"
+)
+
+// Kinds of ingestion session
+const (
+	SESSION_WHIP = "whip"
+	SESSION_RTP  = "rtp"
+)
+
+// Encodings of the raw PCM websocket
+const (
+	ENCODING_PCM16 = "pcm16"
+	ENCODING_MULAW = "mulaw"
+)
+
+// Events of the telephony media stream
+const (
+	TELEPHONY_CONNECTED = "connected"
+	TELEPHONY_START     = "start"
+	TELEPHONY_MEDIA     = "media"
+	TELEPHONY_STOP      = "stop"
+	TELEPHONY_MARK      = "mark"
+	TELEPHONY_CLEAR     = "clear"
+
+	// Encoding of the calls, and the track of the caller
+	TELEPHONY_ENCODING_MULAW = "audio/x-mulaw"
+	TELEPHONY_TRACK_INBOUND  = "inbound"
+)
+
+// Content types of the WHIP requests
+const (
+	CONTENT_TYPE_SDP         = "application/sdp"
+	CONTENT_TYPE_TRICKLE_ICE = "application/trickle-ice-sdpfrag"
+	CONTENT_TYPE_EVENTS      = "text/event-stream"
 )
 
 const LLM_PROMPT = `
@@ -0,0 +1,64 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hraban/opus"
+	"github.com/pion/webrtc/v4"
+)
+
+// audioDecoder decodes the RTP payloads of a track into interleaved PCM16 samples
+type audioDecoder interface {
+	decode(payload []byte) ([]int16, error)
+}
+
+// trackFormat is the sample rate and the channel count of the decoded samples
+type trackFormat struct {
+	sampleRate int
+	channels   int
+}
+
+type opusDecoder struct {
+	decoder  *opus.Decoder
+	channels int
+}
+
+func (d opusDecoder) decode(payload []byte) ([]int16, error) {
+	return decodeRTPPayload(d.decoder, payload, d.channels)
+}
+
+// g711Decoder decodes PCMU or PCMA with the table of its law
+type g711Decoder struct {
+	table *[256]int16
+}
+
+func (d g711Decoder) decode(payload []byte) ([]int16, error) {
+	return decodeG711(d.table, payload), nil
+}
+
+// newAudioDecoder returns the decoder of the codec negotiated for a track, and the format of its samples.
+// The format is read from the codec, the clock rate of G.711 is 8 kHz while Opus runs at 48 kHz.
+func newAudioDecoder(codec webrtc.RTPCodecParameters) (audioDecoder, trackFormat, error) {
+	format := trackFormat{sampleRate: int(codec.ClockRate), channels: 1}
+
+	switch {
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypeOpus):
+		// Opus is always negotiated with two channels, the stream is only stereo when the sender says so
+		if codec.Channels == 2 && strings.Contains(codec.SDPFmtpLine, "stereo=1") {
+			format.channels = 2
+		}
+		decoder, err := opus.NewDecoder(format.sampleRate, format.channels)
+		if err != nil {
+			return nil, format, err
+		}
+		return opusDecoder{decoder: decoder, channels: format.channels}, format, nil
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypePCMU):
+		return g711Decoder{table: &mulawTable}, format, nil
+	case strings.EqualFold(codec.MimeType, webrtc.MimeTypePCMA):
+		return g711Decoder{table: &alawTable}, format, nil
+	}
+	return nil, format, fmt.Errorf("unsupported codec %s", codec.MimeType)
+}
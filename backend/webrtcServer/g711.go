@@ -0,0 +1,65 @@
+package webrtcserver
+
+import "encoding/binary"
+
+// mulawTable maps every G.711 μ-law byte to its linear PCM16 sample
+var mulawTable = func() (table [256]int16) {
+	for i := range table {
+		u := ^byte(i)
+		exponent := (u >> 4) & 0x07
+		mantissa := int(u & 0x0F)
+		sample := (((mantissa << 3) + 0x84) << exponent) - 0x84
+		if u&0x80 != 0 {
+			sample = -sample
+		}
+		table[i] = int16(sample)
+	}
+	return table
+}()
+
+// alawTable maps every G.711 A-law byte to its linear PCM16 sample
+var alawTable = func() (table [256]int16) {
+	for i := range table {
+		a := byte(i) ^ 0x55
+		segment := (a >> 4) & 0x07
+		sample := int(a&0x0F) << 4
+		if segment == 0 {
+			sample += 0x08
+		} else {
+			sample = (sample + 0x108) << (segment - 1)
+		}
+		if a&0x80 == 0 {
+			sample = -sample
+		}
+		table[i] = int16(sample)
+	}
+	return table
+}()
+
+// decodeMulaw decodes G.711 μ-law bytes into PCM16 samples
+func decodeMulaw(data []byte) []int16 {
+	return decodeG711(&mulawTable, data)
+}
+
+// decodeAlaw decodes G.711 A-law bytes into PCM16 samples
+func decodeAlaw(data []byte) []int16 {
+	return decodeG711(&alawTable, data)
+}
+
+// decodeG711 decodes G.711 bytes with the table of their law
+func decodeG711(table *[256]int16, data []byte) []int16 {
+	samples := make([]int16, len(data))
+	for i, b := range data {
+		samples[i] = table[b]
+	}
+	return samples
+}
+
+// decodePCM16 decodes little-endian PCM16 bytes into samples, a trailing odd byte is dropped
+func decodePCM16(data []byte) []int16 {
+	samples := make([]int16, len(data)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(data[2*i:]))
+	}
+	return samples
+}
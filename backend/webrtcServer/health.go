@@ -0,0 +1,24 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"errors"
+)
+
+var errNoRecognizer = errors.New("recognizer not created")
+
+// CheckRecognizer returns an error when the speech model is not loaded, or is released by a shutdown
+func CheckRecognizer(ctx context.Context) error {
+	if recognizerErr != nil {
+		return recognizerErr
+	}
+	if recognizer == nil {
+		return errNoRecognizer
+	}
+	if isDraining() {
+		return errServerShutdown
+	}
+	return nil
+}
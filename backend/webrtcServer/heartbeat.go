@@ -0,0 +1,34 @@
+package webrtcserver
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/metrics"
+)
+
+// sendPings pings the client until the context is done. A failed ping closes the connection.
+func sendPings(ctx context.Context, conn *websocket.Conn) {
+	if pingInterval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(PING_WRITE_TIMEOUT)); err != nil {
+				slog.Warn("Ping failed, closing the connection", "err", err)
+				metrics.WebSocketWriteErrors.WithLabelValues(metrics.ENDPOINT_TRANSCRIPTION).Inc()
+				conn.Close()
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package webrtcserver
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// errServerShutdown stops the transcriptions when the server drains, after they flushed their last utterance
+	errServerShutdown = errors.New("server is shutting down")
+	// errClosedByServer ends a transcription the participant cannot resume, e.g. once kicked
+	errClosedByServer = errors.New("transcription closed by the server")
+)
+
+var (
+	// Transcriptions and LLM analyses in flight, waited for on shutdown
+	inFlight  sync.WaitGroup
+	workMutex sync.Mutex
+	draining  bool
+
+	// drained is done once the server started draining
+	drained, startDrain = context.WithCancel(context.Background())
+)
+
+// startWork counts a new transcription, and returns false once the server is draining
+func startWork() bool {
+	workMutex.Lock()
+	defer workMutex.Unlock()
+
+	if draining {
+		return false
+	}
+	inFlight.Add(1)
+	return true
+}
+
+// isDraining returns true once the server stopped accepting transcriptions
+func isDraining() bool {
+	workMutex.Lock()
+	defer workMutex.Unlock()
+
+	return draining
+}
+
+// drain stops accepting transcriptions and tells the running ones to flush
+func drain() {
+	workMutex.Lock()
+	draining = true
+	workMutex.Unlock()
+	startDrain()
+}
@@ -0,0 +1,151 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"profanity.com/auth"
+	"profanity.com/invite"
+	"profanity.com/transcription"
+)
+
+// errSessionDeleted ends an ingestion session its client deleted
+var errSessionDeleted = errors.New("ingestion session deleted")
+
+var (
+	ingestSessions = make(map[string]*ingestSession)
+	ingestMutex    sync.Mutex
+)
+
+// addSession makes the session reachable by its id
+func addSession(session *ingestSession) {
+	ingestMutex.Lock()
+	ingestSessions[session.id] = session
+	ingestMutex.Unlock()
+}
+
+// run registers the session until it ends, then releases it
+func (s *ingestSession) run(ctx context.Context) {
+	// The registry exposes the session to the admin API, which may close it
+	connection := transcription.Connections.Register(s.roomID, s.userID, func() { s.end(errClosedByServer) })
+	defer transcription.Connections.Unregister(connection)
+
+	// A draining server stops the transcription once its last utterance is flushed
+	stopDrain := context.AfterFunc(drained, func() { s.cancel(errServerShutdown) })
+	defer stopDrain()
+
+	<-ctx.Done()
+	if errors.Is(context.Cause(ctx), errServerShutdown) {
+		// The flushed utterances are delivered until the shutdown closes the connections
+		<-s.ended
+	}
+
+	ingestMutex.Lock()
+	delete(ingestSessions, s.id)
+	ingestMutex.Unlock()
+
+	s.release()
+	s.events.close()
+	slog.Info("Ingestion session ended", "id", s.id, "kind", s.kind, "cause", context.Cause(ctx))
+}
+
+// end tears the session down
+func (s *ingestSession) end(cause error) {
+	s.cancel(cause)
+	s.endOnce.Do(func() { close(s.ended) })
+}
+
+// sessionOf returns the session of the request, once its caller proved it owns it with its tokens
+func sessionOf(w http.ResponseWriter, r *http.Request, kind string) (*ingestSession, bool) {
+	auth.AllowCORS(w, r)
+
+	ingestMutex.Lock()
+	session, ok := ingestSessions[r.PathValue("id")]
+	ingestMutex.Unlock()
+	if !ok || session.kind != kind {
+		http.Error(w, "Unknown session", http.StatusNotFound)
+		return nil, false
+	}
+
+	identity, ok := auth.Require(w, r)
+	if !ok {
+		return nil, false
+	}
+	claims, err := invite.Tokens.Verify(invite.FromRequest(r))
+	if err == nil {
+		claims, err = identity.Apply(claims)
+	}
+	if err != nil || claims.RoomID != session.roomID || claims.UserID != session.userID {
+		http.Error(w, "The session belongs to another user", http.StatusForbidden)
+		return nil, false
+	}
+	return session, true
+}
+
+// deleteSession ends the session, its last results stay readable until the event stream ends
+func deleteSession(w http.ResponseWriter, r *http.Request, kind string) {
+	session, ok := sessionOf(w, r, kind)
+	if !ok {
+		return
+	}
+	session.end(errSessionDeleted)
+	w.WriteHeader(http.StatusOK)
+}
+
+// streamEvents streams the transcriptions and the LLM analyses of the session as server-sent events.
+// The browsers pass their tokens in the query, an EventSource cannot set headers.
+func streamEvents(w http.ResponseWriter, r *http.Request, kind string) {
+	session, ok := sessionOf(w, r, kind)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", CONTENT_TYPE_EVENTS)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	// Comments keep the idle stream open through the proxies
+	keepAlive := make(<-chan time.Time)
+	if pingInterval > 0 {
+		ticker := time.NewTicker(pingInterval)
+		defer ticker.Stop()
+		keepAlive = ticker.C
+	}
+
+	for {
+		select {
+		case data := <-session.events.events:
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		case <-keepAlive:
+			io.WriteString(w, ": ping\n\n")
+		case <-session.events.done:
+			// The results queued before the end are still delivered
+			for {
+				select {
+				case data := <-session.events.events:
+					fmt.Fprintf(w, "data: %s\n\n", data)
+				default:
+					flusher.Flush()
+					return
+				}
+			}
+		case <-r.Context().Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
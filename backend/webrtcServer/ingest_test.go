@@ -0,0 +1,110 @@
+package webrtcserver
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/pion/rtp"
+)
+
+// TestDecodeG711 tests the G.711 μ-law and A-law decoding against the reference values
+func TestDecodeG711(t *testing.T) {
+	cases := map[byte]int16{
+		0xFF: 0,
+		0x7F: 0,
+		0x80: 32124,
+		0x00: -32124,
+		0xF0: 120,
+		0x70: -120,
+	}
+	for encoded, expected := range cases {
+		if decoded := decodeMulaw([]byte{encoded})[0]; decoded != expected {
+			t.Errorf("expected 0x%02X to decode to %d, got %d", encoded, expected, decoded)
+		}
+	}
+
+	alaw := map[byte]int16{
+		0xD5: 8,
+		0x55: -8,
+		0xAA: 32256,
+		0x2A: -32256,
+	}
+	for encoded, expected := range alaw {
+		if decoded := decodeAlaw([]byte{encoded})[0]; decoded != expected {
+			t.Errorf("expected 0x%02X to decode to %d, got %d", encoded, expected, decoded)
+		}
+	}
+
+	samples := decodePCM16([]byte{0x01, 0x00, 0xFF, 0xFF, 0x00})
+	if len(samples) != 2 || samples[0] != 1 || samples[1] != -1 {
+		t.Errorf("expected [1 -1], got %v", samples)
+	}
+}
+
+// TestSplitChannels tests that the stereo samples are downmixed or split per channel
+func TestSplitChannels(t *testing.T) {
+	stereo := []int16{100, 300, -100, -300, 7, 8}
+
+	mixed := splitChannels(stereo, 2, false)
+	if len(mixed) != 1 || len(mixed[0]) != 3 || mixed[0][0] != 200 || mixed[0][1] != -200 || mixed[0][2] != 7 {
+		t.Errorf("expected [[200 -200 7]], got %v", mixed)
+	}
+
+	split := splitChannels(stereo, 2, true)
+	if len(split) != 2 || split[0][1] != -100 || split[1][1] != -300 {
+		t.Errorf("expected [[100 -100 7] [300 -300 8]], got %v", split)
+	}
+
+	if mono := splitChannels(stereo, 1, true); len(mono) != 1 || len(mono[0]) != len(stereo) {
+		t.Errorf("expected the mono samples unchanged, got %v", mono)
+	}
+}
+
+// TestUDPRTPReader tests that the reader keeps to the first sender, skips the malformed datagrams and fails when idle
+func TestUDPRTPReader(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	var failure error
+	reader := newUDPRTPReader(conn, 200*time.Millisecond, func(err error) { failure = err })
+
+	sender, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+	other, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	packet := func(sequence uint16) []byte {
+		data, err := (&rtp.Packet{Header: rtp.Header{Version: 2, PayloadType: 111, SequenceNumber: sequence}, Payload: []byte{1, 2, 3}}).Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	sender.Write(packet(1))
+	if received, _, err := reader.ReadRTP(); err != nil || received.SequenceNumber != 1 {
+		t.Fatalf("expected the packet 1, got %v, %v", received, err)
+	}
+
+	other.Write(packet(2))
+	sender.Write([]byte{0x80})
+	sender.Write(packet(3))
+	if received, _, err := reader.ReadRTP(); err != nil || received.SequenceNumber != 3 {
+		t.Fatalf("expected the packet 3, got %v, %v", received, err)
+	}
+
+	if _, _, err := reader.ReadRTP(); !errors.Is(err, errRTPIdle) || !errors.Is(failure, errRTPIdle) {
+		t.Errorf("expected the reader to fail idle, got %v and %v", err, failure)
+	}
+}
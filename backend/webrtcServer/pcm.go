@@ -0,0 +1,116 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/heartbeat"
+	"profanity.com/moderation"
+	"profanity.com/transcription"
+)
+
+// pcmFormat returns the encoding and the sample rate declared in the query of the request
+func pcmFormat(r *http.Request) (string, int, bool) {
+	encoding := r.URL.Query().Get("encoding")
+	if encoding == "" {
+		encoding = ENCODING_PCM16
+	}
+	if encoding != ENCODING_PCM16 && encoding != ENCODING_MULAW {
+		return "", 0, false
+	}
+
+	sampleRate := DEFAULT_PCM_SAMPLE_RATE
+	if value := r.URL.Query().Get("sample_rate"); value != "" {
+		rate, err := strconv.Atoi(value)
+		if err != nil || rate < MIN_PCM_SAMPLE_RATE || rate > MAX_PCM_SAMPLE_RATE {
+			return "", 0, false
+		}
+		sampleRate = rate
+	}
+	return encoding, sampleRate, true
+}
+
+// handlePCM transcribes the raw audio of the binary frames of a websocket, PCM16 little-endian or μ-law mono
+// at the declared sample rate. The results are written back on the same websocket.
+func handlePCM(w http.ResponseWriter, r *http.Request) {
+	encoding, sampleRate, ok := pcmFormat(r)
+	if !ok {
+		http.Error(w, "Unsupported encoding or sample rate", http.StatusBadRequest)
+		return
+	}
+	roomID, userID, ok := authorizeTranscription(w, r)
+	if !ok {
+		return
+	}
+	if !startWork() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer inFlight.Done()
+
+	wsConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("WebSocket connection upgrade failed", "Error", err)
+		return
+	}
+	defer wsConn.Close()
+	wsConn.SetReadLimit(MAX_PCM_FRAME_SIZE)
+	heartbeat.ExpectPongs(wsConn, pongTimeout)
+
+	// Only the results are written on this connection, it does not contend with the signaling connections
+	var connMu sync.Mutex
+	sink := wsSink{conn: wsConn, mu: &connMu}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go sendPings(ctx, wsConn)
+
+	// A draining server stops the transcription once its last utterance is flushed
+	stopDrain := context.AfterFunc(drained, func() { cancel(errServerShutdown) })
+	defer stopDrain()
+	// The pending read returns once the context is done
+	stopRead := context.AfterFunc(ctx, func() { wsConn.SetReadDeadline(time.Now()) })
+	defer stopRead()
+
+	// The registry exposes the connection to the admin API, which may close it
+	connection := transcription.Connections.Register(roomID, userID, func() {
+		cancel(errClosedByServer)
+		wsConn.Close()
+	})
+	defer transcription.Connections.Unregister(connection)
+	transcription.Connections.SetCodec(roomID, userID, encoding+"/"+strconv.Itoa(sampleRate))
+	transcription.Connections.SetStreaming(roomID, userID, true)
+
+	t := newTranscriber(roomID, userID, audioSource{}, sink)
+	defer t.stop(ctx)
+
+	for {
+		messageType, message, err := wsConn.ReadMessage()
+		if err != nil {
+			slog.Info("PCM stream ended", "roomID", roomID, "userID", userID, "err", err)
+			cancel(err)
+			return
+		}
+		heartbeat.Extend(wsConn, pongTimeout)
+
+		// Skip if a host muted the user or paused its transcription
+		if messageType != websocket.BinaryMessage || !moderation.Participants.TranscriptionAllowed(roomID, userID) {
+			continue
+		}
+		t.receive()
+
+		var samples []int16
+		if encoding == ENCODING_MULAW {
+			samples = decodeMulaw(message)
+		} else {
+			samples = decodePCM16(message)
+		}
+		t.accept(PcmToFloat32(samples), sampleRate)
+	}
+}
@@ -0,0 +1,115 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+
+	"github.com/pion/webrtc/v4"
+	"profanity.com/auth"
+	"profanity.com/invite"
+	"profanity.com/transcription"
+)
+
+// authorizeTranscription returns the room and the user of a new transcription, or answers why it is refused.
+// Unauthenticated callers are refused before any upgrade or negotiation.
+func authorizeTranscription(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	identity, ok := auth.Require(w, r)
+	if !ok {
+		return "", "", false
+	}
+
+	// The transcription is linked to the room of the invite token, and to the user of the access token when
+	// authentication is enabled
+	claims, err := invite.Tokens.VerifyPersonal(invite.FromRequest(r))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", "", false
+	}
+	if claims, err = identity.Apply(claims); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return "", "", false
+	}
+
+	if isDraining() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return "", "", false
+	}
+	if recognizerErr != nil {
+		http.Error(w, "Transcription is unavailable", http.StatusServiceUnavailable)
+		return "", "", false
+	}
+	return claims.RoomID, claims.UserID, true
+}
+
+// newPeerConnection returns a peer connection transcribing the Opus and G.711 tracks of the user into the sink.
+// When the connection cannot carry audio anymore, the transcription is cancelled and the client is closed.
+func newPeerConnection(ctx context.Context, cancel context.CancelCauseFunc, closeClient func(), roomID string, userID string, tracks *peerTracks, sink resultSink) (*webrtc.PeerConnection, error) {
+	// Register the MediaEngine
+	mediaEngine := webrtc.MediaEngine{}
+	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
+		return nil, err
+	}
+
+	api := webrtc.NewAPI(webrtc.WithMediaEngine(&mediaEngine))
+
+	// Create a new RTCPeerConnection
+	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		return nil, err
+	}
+
+	// A failed ICE connection will not carry audio anymore, the peer is cleaned up with the client
+	peerConnection.OnICEConnectionStateChange(func(state webrtc.ICEConnectionState) {
+		slog.Info("ICE connection state changed", "state", state.String())
+		transcription.Connections.SetICEState(roomID, userID, state.String())
+		if state == webrtc.ICEConnectionStateFailed {
+			cancel(errICEFailed)
+			closeClient()
+		}
+	})
+	peerConnection.OnConnectionStateChange(func(state webrtc.PeerConnectionState) {
+		slog.Info("Peer connection state changed", "state", state.String())
+		transcription.Connections.SetConnectionState(roomID, userID, state.String())
+		switch state {
+		case webrtc.PeerConnectionStateFailed:
+			cancel(errICEFailed)
+			closeClient()
+		case webrtc.PeerConnectionStateClosed:
+			cancel(context.Canceled)
+		}
+	})
+
+	// Handle incoming audio, the format of every track is read from its negotiated codec.
+	// Every track has its own transcription, under the label the client gave it.
+	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
+		codec := track.Codec()
+		label := tracks.label(track.ID(), track.StreamID())
+		slog.Info("Got track, codec", "codecName", codec.MimeType, "clockRate", codec.ClockRate, "channels", codec.Channels, "label", label)
+		transcription.Connections.SetCodec(roomID, userID, codec.MimeType)
+
+		decoder, format, err := newAudioDecoder(codec)
+		if err != nil {
+			slog.Info("Track rejected", "roomID", roomID, "userID", userID, "err", err)
+			sink.send(UnsupportedCodec{
+				Type:      "unsupportedCodec",
+				MimeType:  codec.MimeType,
+				ClockRate: codec.ClockRate,
+				Channels:  codec.Channels,
+			})
+			return
+		}
+		slog.Info("Track has started", "label", label)
+
+		if !startWork() {
+			return
+		}
+		go func() {
+			defer inFlight.Done()
+			handleAudioStream(ctx, track, decoder, format, roomID, userID, label, tracks.streamingOf(label), sink)
+		}()
+	})
+	return peerConnection, nil
+}
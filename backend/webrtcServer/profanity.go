@@ -1,27 +1,28 @@
 package webrtcserver
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
 	"log/slog"
-	"net/http"
 	"strings"
-	"sync"
 	"time"
 
-	"github.com/gorilla/websocket"
 	"github.com/openai/openai-go"
+	"go.opentelemetry.io/otel/attribute"
+	"profanity.com/classifier"
+	"profanity.com/events"
+	"profanity.com/metrics"
+	"profanity.com/report"
+	"profanity.com/tracing"
+	"profanity.com/transcription"
 )
 
 type UserSession struct {
-	RoomID          string
-	UserID          string
-	sentenceBuffer  string
-	client          *openai.Client
-	bufferCounter   int
-	timeToProfanity int64
-	tokenCounter    int
+	RoomID         string
+	UserID         string
+	sentenceBuffer string
+	client         *openai.Client
+	bufferCounter  int
+	talkTime       time.Duration
 }
 
 // startNewSession starts a new session with the given roomID and userID
@@ -31,8 +32,19 @@ func (s *UserSession) startNewSession(roomID string, userID string) {
 	s.UserID = userID
 	s.client = openai.NewClient()
 	s.bufferCounter = 0
-	s.timeToProfanity = 0.0
-	s.tokenCounter = 0
+	s.talkTime = 0
+}
+
+// addTalkTime adds the duration of voiced samples to the pending talk time
+func (s *UserSession) addTalkTime(sampleCount int, sampleRate int) {
+	s.talkTime += time.Duration(sampleCount) * time.Second / time.Duration(sampleRate)
+}
+
+// flushTalkTime reports the pending talk time to the meeting report
+func (s *UserSession) flushTalkTime() {
+	report.Meetings.AddTalkTime(s.RoomID, s.UserID, s.talkTime)
+	transcription.Connections.AddTalkTime(s.RoomID, s.UserID, s.talkTime)
+	s.talkTime = 0
 }
 
 // appendToBuffer appends the sentence to the sentence buffer
@@ -71,48 +83,39 @@ func (s *UserSession) clearBuffer() {
 	s.sentenceBuffer = ""
 }
 
-// analyzeBuffer sends the sentence buffer to the profanity API and returns the profanity score
-func (s *UserSession) analyzeBuffer(wsConn *websocket.Conn, mu *sync.Mutex) (float64, error) {
-
-	startTime := time.Now()
-	url := "http://profanity:8080/profanity"
-	data := PostData{
-		Text: s.sentenceBuffer,
-	}
-
-	jsonData, err := json.Marshal(data)
-	if err != nil {
-		slog.Error("Error marshaling JSON", "err", err)
-		return 0, err
-	}
-
-	resp, err := http.Post(url, "application/json", bytes.NewBuffer(jsonData))
-	if err != nil {
-		slog.Error("Error sending post request", "err", err)
-		return 0, err
-	}
-	defer resp.Body.Close()
+// analyzeBuffer sends the sentence buffer to the profanity classifier and returns the profanity score
+func (s *UserSession) analyzeBuffer(ctx context.Context, sink resultSink) (float64, error) {
 
-	var responseData PostResponse
-	err = json.NewDecoder(resp.Body).Decode(&responseData)
+	profanityScore, err := classifier.Profanity.Classify(ctx, s.sentenceBuffer)
 	if err != nil {
-		slog.Error("Error decoding response", "err", err)
+		slog.Error("Error classifying the buffer", "err", err)
 		return 0, err
 	}
 
-	if responseData.ProfanityScore > 0.9 {
-		go s.llmAnalysis(wsConn, mu)
+	if classifier.IsFlagged(profanityScore) {
+		flagID := report.Meetings.AddFlag(s.RoomID, s.UserID, s.sentenceBuffer, profanityScore)
+		metrics.Flag(events.SOURCE_TRANSCRIPTION, profanityScore)
+		events.Publish(events.USER_FLAGGED, s.RoomID, s.UserID, map[string]interface{}{
+			"source": events.SOURCE_TRANSCRIPTION,
+			"flagID": flagID,
+			"score":  profanityScore,
+		})
+		// The transcription calling it is in flight, the analysis is waited for on shutdown too
+		inFlight.Add(1)
+		go func() {
+			defer inFlight.Done()
+			s.llmAnalysis(ctx, sink, flagID)
+		}()
 	}
 
-	endTime := time.Now()
-	s.tokenCounter += 1
-	s.timeToProfanity = s.timeToProfanity + endTime.Sub(startTime).Milliseconds()
-	slog.Info("Profanity analysis", "profanityScore", responseData.ProfanityScore, "avgTime", s.timeToProfanity/int64(s.tokenCounter))
-	return responseData.ProfanityScore, nil
+	// The latency of the classifier is exported by the metrics
+	slog.Info("Profanity analysis", "profanityScore", profanityScore)
+	return profanityScore, nil
 }
 
-// llmAnalysis sends the sentence buffer to the LLM API and returns the analysis
-func (s *UserSession) llmAnalysis(wsConn *websocket.Conn, mu *sync.Mutex) error {
+// llmAnalysis sends the sentence buffer to the LLM API and returns the analysis.
+// The context carries the trace of the flagged utterance.
+func (s *UserSession) llmAnalysis(ctx context.Context, sink resultSink, flagID string) error {
 
 	// Only analyze every PROFANITY_ANALYSIS_BUFFER_SIZE tokens
 	if s.bufferCounter < PROFANITY_ANALYSIS_BUFFER_SIZE {
@@ -121,26 +124,28 @@ func (s *UserSession) llmAnalysis(wsConn *websocket.Conn, mu *sync.Mutex) error
 
 	userBuffer := s.sentenceBuffer
 
-	ctx := context.Background()
 	userMessage := openai.UserMessage(userBuffer)
 	systemMessage := openai.SystemMessage(LLM_PROMPT)
 
-	completion, err := s.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+	llmCtx, span := tracing.Tracer.Start(ctx, tracing.SPAN_LLM_COMPLETION)
+	span.SetAttributes(attribute.String("llm.caller", metrics.LLM_MODERATION), attribute.String("flag.id", flagID))
+	start := time.Now()
+	completion, err := s.client.Chat.Completions.New(llmCtx, openai.ChatCompletionNewParams{
 		Messages: openai.F([]openai.ChatCompletionMessageParamUnion{
 			systemMessage,
 			userMessage,
 		}),
 		Model: openai.F(openai.ChatModelGPT4oMini),
 	})
+	metrics.ObserveLLM(metrics.LLM_MODERATION, start, err)
+	tracing.End(span, err)
 
 	if err != nil {
 		slog.Error("Error creating completion", "err", err)
 		return err
 	}
 	slog.Info("LLM answer", "content", completion.Choices[0].Message.Content)
-
-	mu.Lock()
-	defer mu.Unlock()
+	report.Meetings.Explain(s.RoomID, flagID, completion.Choices[0].Message.Content)
 
 	location, err := time.LoadLocation("America/Toronto")
 	if err != nil {
@@ -154,7 +159,11 @@ func (s *UserSession) llmAnalysis(wsConn *websocket.Conn, mu *sync.Mutex) error
 		UserMessage: userBuffer,
 		Timestamp:   time.Now().In(location).Format("15:04:05"),
 	}
-	if err := wsConn.WriteJSON(data); err != nil {
+	_, span = tracing.Tracer.Start(ctx, tracing.SPAN_WEBSOCKET_WRITE)
+	span.SetAttributes(attribute.String("message.type", data.Type))
+	err = sink.send(data)
+	tracing.End(span, err)
+	if err != nil {
 		slog.Error("Error writing LLM analysis", "error", err)
 		return err
 	}
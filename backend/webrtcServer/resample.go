@@ -0,0 +1,41 @@
+package webrtcserver
+
+// resampler converts a stream of chunks to another sample rate by linear interpolation.
+// The last sample of a chunk is kept, the chunks are interpolated without seams.
+type resampler struct {
+	// Input samples advanced for every output sample
+	step float64
+	// Position of the next output sample, in the samples of the next chunk preceded by the last one
+	position float64
+	last     float32
+	primed   bool
+}
+
+// newResampler returns a resampler from the sample rate to the target one
+func newResampler(from int, to int) *resampler {
+	return &resampler{step: float64(from) / float64(to)}
+}
+
+// resample returns the samples of the chunk at the target sample rate
+func (r *resampler) resample(chunk []float32) []float32 {
+	if len(chunk) == 0 {
+		return nil
+	}
+	samples := chunk
+	if r.primed {
+		samples = append([]float32{r.last}, chunk...)
+	}
+
+	output := make([]float32, 0, int(float64(len(chunk))/r.step)+1)
+	end := float64(len(samples) - 1)
+	for ; r.position < end; r.position += r.step {
+		i := int(r.position)
+		fraction := float32(r.position - float64(i))
+		output = append(output, samples[i]*(1-fraction)+samples[i+1]*fraction)
+	}
+
+	r.position -= end
+	r.last = samples[len(samples)-1]
+	r.primed = true
+	return output
+}
@@ -0,0 +1,106 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4"
+	"profanity.com/auth"
+)
+
+// handleRTPOptions answers the preflight of the browsers
+func handleRTPOptions(w http.ResponseWriter, r *http.Request) {
+	if !auth.AllowCORS(w, r) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleRTP allocates a UDP port receiving the plain RTP/Opus stream of the user, for the senders without WebRTC.
+// The results are read from the event stream of the session.
+func handleRTP(w http.ResponseWriter, r *http.Request) {
+	auth.AllowCORS(w, r)
+	w.Header().Set("Access-Control-Expose-Headers", "Location")
+
+	roomID, userID, ok := authorizeTranscription(w, r)
+	if !ok {
+		return
+	}
+
+	// The senders without signaling cannot negotiate, the stream is mono Opus
+	decoder, format, err := newAudioDecoder(webrtc.RTPCodecParameters{
+		RTPCodecCapability: webrtc.RTPCodecCapability{MimeType: webrtc.MimeTypeOpus, ClockRate: INPUT_SAMPLE_RATE, Channels: 1},
+	})
+	if err != nil {
+		slog.Error("Opus decoder creation failed", "Error", err)
+		http.Error(w, "Transcription is unavailable", http.StatusInternalServerError)
+		return
+	}
+
+	conn, err := listenRTP()
+	if err != nil {
+		slog.Error("RTP port allocation failed", "Error", err)
+		http.Error(w, "No RTP port available", http.StatusServiceUnavailable)
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	session := &ingestSession{
+		id:      uuid.New().String(),
+		kind:    SESSION_RTP,
+		roomID:  roomID,
+		userID:  userID,
+		events:  newEventStream(EVENT_QUEUE_SIZE),
+		cancel:  cancel,
+		release: func() { conn.Close() },
+		tracks:  newPeerTracks(true),
+		ended:   make(chan struct{}),
+	}
+	if !startWork() {
+		cancel(errServerShutdown)
+		conn.Close()
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+
+	addSession(session)
+	go session.run(ctx)
+	go func() {
+		defer inFlight.Done()
+		reader := newUDPRTPReader(conn, rtpIdleTimeout, session.end)
+		handleAudioStream(ctx, reader, decoder, format, roomID, userID, "", session.tracks.streamingOf(""), session.events)
+	}()
+
+	port := conn.LocalAddr().(*net.UDPAddr).Port
+	slog.Info("RTP session created", "id", session.id, "roomID", roomID, "userID", userID, "port", port)
+	// Relative to the request, the locations stay valid behind the path prefix of the reverse proxy
+	location := "rtp/" + session.id
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(rtpSession{
+		ID:            session.id,
+		Host:          rtpPublicHost,
+		Port:          port,
+		Codec:         "opus",
+		ClockRate:     INPUT_SAMPLE_RATE,
+		Channels:      1,
+		Events:        location + "/events",
+		MaxPacketSize: MAX_RTP_PACKET_SIZE,
+	})
+}
+
+// handleRTPDelete ends the session and frees its port
+func handleRTPDelete(w http.ResponseWriter, r *http.Request) {
+	deleteSession(w, r, SESSION_RTP)
+}
+
+// handleRTPEvents streams the results of the session as server-sent events
+func handleRTPEvents(w http.ResponseWriter, r *http.Request) {
+	streamEvents(w, r, SESSION_RTP)
+}
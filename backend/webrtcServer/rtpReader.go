@@ -0,0 +1,71 @@
+package webrtcserver
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+)
+
+// errRTPIdle ends a plain RTP session whose sender stopped sending
+var errRTPIdle = errors.New("no RTP packet received")
+
+// listenRTP binds a UDP port of the configured range, or any free port when there is no range
+func listenRTP() (*net.UDPConn, error) {
+	for port := rtpPortMin; port <= rtpPortMax; port++ {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err == nil {
+			return conn, nil
+		}
+	}
+	return nil, fmt.Errorf("no free UDP port between %d and %d", rtpPortMin, rtpPortMax)
+}
+
+// udpRTPReader reads the RTP packets of a single sender from a UDP socket.
+// The first sender owns the session, the datagrams of any other address are dropped.
+type udpRTPReader struct {
+	conn    *net.UDPConn
+	source  *net.UDPAddr
+	timeout time.Duration
+	buffer  []byte
+
+	// fail ends the session once the socket cannot be read anymore
+	fail func(err error)
+}
+
+// newUDPRTPReader returns a reader failing after timeout without packets
+func newUDPRTPReader(conn *net.UDPConn, timeout time.Duration, fail func(err error)) *udpRTPReader {
+	return &udpRTPReader{conn: conn, timeout: timeout, buffer: make([]byte, MAX_RTP_PACKET_SIZE), fail: fail}
+}
+
+// ReadRTP returns the next packet of the sender, skipping the malformed datagrams.
+// The payload is only valid until the next read.
+func (r *udpRTPReader) ReadRTP() (*rtp.Packet, interceptor.Attributes, error) {
+	for {
+		r.conn.SetReadDeadline(time.Now().Add(r.timeout))
+		n, addr, err := r.conn.ReadFromUDP(r.buffer)
+		if err != nil {
+			var netErr net.Error
+			if errors.As(err, &netErr) && netErr.Timeout() {
+				err = errRTPIdle
+			}
+			r.fail(err)
+			return nil, nil, err
+		}
+
+		if r.source == nil {
+			r.source = addr
+		} else if !r.source.IP.Equal(addr.IP) || r.source.Port != addr.Port {
+			continue
+		}
+
+		packet := &rtp.Packet{}
+		if err := packet.Unmarshal(r.buffer[:n]); err != nil {
+			continue
+		}
+		return packet, nil, nil
+	}
+}
@@ -0,0 +1,180 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"profanity.com/metrics"
+	"profanity.com/tracing"
+)
+
+// transcriptionSession is the state of a transcription, kept across the reconnections of the user
+type transcriptionSession struct {
+	userSession *UserSession
+	stream      *sherpa.OnlineStream
+	lastText    string
+
+	// Audio of the user transcribed by the session
+	source audioSource
+
+	// Time spent decoding the audio of the current utterance, and the duration of that audio
+	decodeTime time.Duration
+	audioTime  time.Duration
+
+	// Trace of the current utterance, started by its first transcribed packet
+	utterance   trace.Span
+	firstPacket time.Time
+	lastPacket  time.Time
+	packets     int
+	opusTime    time.Duration
+}
+
+// observeReceive records a packet of the current utterance, starting its trace with the first one
+func (s *transcriptionSession) observeReceive(roomID string, userID string) {
+	now := time.Now()
+	if s.utterance == nil {
+		_, s.utterance = tracing.Tracer.Start(context.Background(), tracing.SPAN_UTTERANCE,
+			trace.WithTimestamp(now),
+			trace.WithAttributes(attribute.String("room.id", roomID), attribute.String("user.id", userID)),
+		)
+		s.firstPacket = now
+	}
+	s.lastPacket = now
+	s.packets++
+}
+
+// observeOpus records the decoding of a packet by the Opus decoder
+func (s *transcriptionSession) observeOpus(opusTime time.Duration) {
+	s.opusTime += opusTime
+}
+
+// traceEndpoint records the stages of the utterance once the recognizer found its end, and starts the
+// span of the endpoint. The classifier, the LLM and the websocket writes are traced under the returned context.
+func (s *transcriptionSession) traceEndpoint() (context.Context, trace.Span) {
+	if s.utterance == nil {
+		// The flush of a shutdown can find text without a new packet
+		s.observeReceive(s.userSession.RoomID, s.userSession.UserID)
+	}
+	ctx := trace.ContextWithSpan(context.Background(), s.utterance)
+
+	// The packets are too many to be traced one by one, each stage spans the utterance with its total work
+	packets := attribute.Int("rtp.packets", s.packets)
+	stage := func(name string, start time.Time, end time.Time) {
+		_, span := tracing.Tracer.Start(ctx, name, trace.WithTimestamp(start), trace.WithAttributes(packets))
+		span.End(trace.WithTimestamp(end))
+	}
+	stage(tracing.SPAN_RTP_RECEIVE, s.firstPacket, s.lastPacket)
+	stage(tracing.SPAN_OPUS_DECODE, s.firstPacket, s.firstPacket.Add(s.opusTime))
+	stage(tracing.SPAN_RECOGNIZER_DECODE, s.firstPacket, s.firstPacket.Add(s.decodeTime))
+
+	return tracing.Tracer.Start(ctx, tracing.SPAN_ENDPOINT, trace.WithAttributes(attribute.Int("text.length", len(s.lastText))))
+}
+
+// observeDecode records the decoding of a packet of samples at the sample rate
+func (s *transcriptionSession) observeDecode(decodeTime time.Duration, sampleCount int, sampleRate int) {
+	metrics.RecognizerDecode.Observe(decodeTime.Seconds())
+	s.decodeTime += decodeTime
+	s.audioTime += time.Duration(sampleCount) * time.Second / time.Duration(sampleRate)
+}
+
+// observeUtterance records the real-time factor of the utterance, and starts the next one
+func (s *transcriptionSession) observeUtterance() {
+	if s.audioTime > 0 {
+		metrics.RecognizerRealTimeFactor.Observe(s.decodeTime.Seconds() / s.audioTime.Seconds())
+	}
+	s.decodeTime = 0
+	s.audioTime = 0
+
+	if s.utterance != nil {
+		s.utterance.End()
+	}
+	s.utterance = nil
+	s.packets = 0
+	s.opusTime = 0
+}
+
+type parkedSession struct {
+	session *transcriptionSession
+	timer   *time.Timer
+}
+
+var (
+	parkedSessions = make(map[string]*parkedSession)
+	parkedMutex    sync.Mutex
+)
+
+// sessionKey returns the key of the transcription session of the user in the room, per source of its audio
+func sessionKey(roomID string, userID string, source audioSource) string {
+	key := roomID + "/" + userID
+	if source.label != "" {
+		key += "/" + source.label
+	}
+	if source.channel != "" {
+		key += "#" + source.channel
+	}
+	return key
+}
+
+// connectionLost returns true if the client connection ended without the client or the server closing it.
+// A failed ICE connection is closed by the server, the client starts over with a new peer and does not resume.
+func connectionLost(ctx context.Context) bool {
+	cause := context.Cause(ctx)
+	if errors.Is(cause, errClosedByServer) || errors.Is(cause, errSessionDeleted) || errors.Is(cause, errStreamStopped) ||
+		errors.Is(cause, errICEFailed) {
+		return false
+	}
+	return !websocket.IsCloseError(cause, websocket.CloseNormalClosure, websocket.CloseGoingAway)
+}
+
+// parkSession keeps the transcription session of a dropped connection for the grace period
+func parkSession(roomID string, userID string, session *transcriptionSession) {
+	key := sessionKey(roomID, userID, session.source)
+
+	parkedMutex.Lock()
+	defer parkedMutex.Unlock()
+
+	if previous, ok := parkedSessions[key]; ok {
+		previous.timer.Stop()
+		PutStream(previous.session.stream)
+	}
+
+	slog.Info("Keeping the transcription session", "roomID", roomID, "userID", userID, "grace", resumeGracePeriod)
+	parkedSessions[key] = &parkedSession{
+		session: session,
+		timer: time.AfterFunc(resumeGracePeriod, func() {
+			parkedMutex.Lock()
+			defer parkedMutex.Unlock()
+
+			if parked, ok := parkedSessions[key]; ok && parked.session == session {
+				slog.Info("Transcription session expired", "roomID", roomID, "userID", userID)
+				delete(parkedSessions, key)
+				PutStream(session.stream)
+			}
+		}),
+	}
+}
+
+// resumeSession returns the transcription session parked for the user, if any
+func resumeSession(roomID string, userID string, source audioSource) (*transcriptionSession, bool) {
+	key := sessionKey(roomID, userID, source)
+
+	parkedMutex.Lock()
+	defer parkedMutex.Unlock()
+
+	parked, ok := parkedSessions[key]
+	if !ok {
+		return nil, false
+	}
+	parked.timer.Stop()
+	delete(parkedSessions, key)
+	return parked.session, true
+}
@@ -4,17 +4,28 @@ package webrtcserver
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log"
 	"log/slog"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/google/uuid"
-	"github.com/gorilla/websocket"
 	"github.com/hraban/opus"
 	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
-	"github.com/pion/webrtc/v4"
+	"github.com/pion/interceptor"
+	"github.com/pion/rtp"
+	"go.opentelemetry.io/otel/attribute"
+	"profanity.com/classifier"
+	"profanity.com/metrics"
+	"profanity.com/moderation"
+	"profanity.com/report"
+	"profanity.com/tracing"
+	"profanity.com/transcription"
 )
 
 var (
@@ -22,6 +33,9 @@ var (
 	streamPool  *sync.Pool
 	initialized bool
 	initMutex   sync.Mutex
+
+	// recognizerErr is the reason the model could not be loaded, the transcriptions are refused then
+	recognizerErr error
 )
 
 // init initializes the recognizer and stream
@@ -40,6 +54,11 @@ func init() {
 		},
 	}
 
+	if recognizerErr != nil {
+		slog.Error("Recognizer not loaded, transcriptions are disabled", "err", recognizerErr)
+		return
+	}
+
 	// Pre-warm the pool
 	stream01 := GetStream()
 	stream02 := GetStream()
@@ -85,6 +104,18 @@ func initializeRecognizer() {
 	config.ModelConfig.Transducer.Joiner = "./" + defaultPath + "joiner.onnx"
 	config.ModelConfig.Tokens = "./" + defaultPath + "tokens.txt"
 
+	for _, path := range []string{
+		config.ModelConfig.Transducer.Encoder,
+		config.ModelConfig.Transducer.Decoder,
+		config.ModelConfig.Transducer.Joiner,
+		config.ModelConfig.Tokens,
+	} {
+		if _, err := os.Stat(path); err != nil {
+			recognizerErr = fmt.Errorf("missing model file: %w", err)
+			return
+		}
+	}
+
 	slog.Info("Initializing recognizer (may take several seconds)")
 	recognizer = sherpa.NewOnlineRecognizer(&config)
 	slog.Info("Recognizer created!")
@@ -92,38 +123,53 @@ func initializeRecognizer() {
 	initialized = true
 }
 
-// Transcribe transcribes the audio stream
-func transcribe(ctx context.Context, track *webrtc.TrackRemote, isStreaming *bool, wsConn *websocket.Conn, mu *sync.Mutex) {
-
-	var last_text string
+// packetReader is a source of RTP packets, a WebRTC track or a plain UDP socket
+type packetReader interface {
+	ReadRTP() (*rtp.Packet, interceptor.Attributes, error)
+}
 
-	// Create an Opus decoder
-	decoder, err := opus.NewDecoder(INPUT_SAMPLE_RATE, 1) // Mono channel
-	if err != nil {
-		slog.Error("Failed to create Opus decoder", "error", err)
+// transcribe transcribes the audio of the RTP packets until the context is done.
+// A stereo track is downmixed, or transcribed per channel when STEREO_MODE splits it.
+func transcribe(ctx context.Context, track packetReader, decoder audioDecoder, format trackFormat, roomID string, userID string, label string, isStreaming *atomic.Bool, sink resultSink) {
+	channels := []string{""}
+	split := format.channels == 2 && stereoMode == STEREO_SPLIT
+	if split {
+		channels = []string{CHANNEL_LEFT, CHANNEL_RIGHT}
 	}
 
-	userSession := UserSession{}
-	userSession.startNewSession("roomID", "userID")
-	stream := GetStream()
-	defer PutStream(stream)
+	transcribers := make([]*transcriber, len(channels))
+	resamplers := make([]*resampler, len(channels))
+	for i, channel := range channels {
+		transcribers[i] = newTranscriber(roomID, userID, audioSource{label: label, channel: channel}, sink)
+		if split {
+			transcribers[i].talk = false
+		}
+		defer transcribers[i].stop(ctx)
+
+		// Narrowband audio is upsampled here, the recognizer downsamples wideband audio with its own filter
+		if format.sampleRate < MODEL_SAMPLE_RATE {
+			resamplers[i] = newResampler(format.sampleRate, MODEL_SAMPLE_RATE)
+		}
+	}
 
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Info("Transcription stopped by the context")
 			return
 		default:
 			rtpPacket, _, err := track.ReadRTP()
 
 			if err != nil {
 				slog.Error("Failed to read RTP packet", "packet", err)
-				recognizer.Reset(stream)
+				for _, t := range transcribers {
+					t.reset()
+				}
 				continue
 			}
+			metrics.RTPPackets.WithLabelValues(metrics.RTP_RECEIVED).Inc()
 
-			// Skip if user is not streaming
-			if !*isStreaming {
+			// Skip if user is not streaming, or if a host muted the user or paused its transcription
+			if !isStreaming.Load() || !moderation.Participants.TranscriptionAllowed(roomID, userID) {
 				continue
 			}
 
@@ -131,58 +177,188 @@ func transcribe(ctx context.Context, track *webrtc.TrackRemote, isStreaming *boo
 			if len(payload) == 0 {
 				continue
 			}
+			for _, t := range transcribers {
+				t.receive()
+			}
 
-			// Decode RTP payload into PCM samples (depends on your audio codec)
-			pcmSamples, err := decodeRTPPayload(decoder, payload)
+			// Decode RTP payload into PCM samples
+			start := time.Now()
+			pcmSamples, err := decoder.decode(payload)
+			for _, t := range transcribers {
+				t.session.observeOpus(time.Since(start))
+			}
 			if err != nil {
-				pcmSamples = []int16{0}
+				metrics.RTPPackets.WithLabelValues(metrics.RTP_FAILED).Inc()
+				pcmSamples = make([]int16, format.channels)
+			} else {
+				metrics.RTPPackets.WithLabelValues(metrics.RTP_DECODED).Inc()
 			}
 
-			// Convert PCM samples ([]int16) to []float32
-			samples := PcmToFloat32(pcmSamples)
-
-			// Process samples
-			stream.AcceptWaveform(int(INPUT_SAMPLE_RATE), samples)
+			// Convert PCM samples ([]int16) to []float32, at the sample rate of the recognizer when upsampled
+			voiced := false
+			for i, channelSamples := range splitChannels(pcmSamples, format.channels, split) {
+				samples, sampleRate := PcmToFloat32(channelSamples), format.sampleRate
+				if resamplers[i] != nil {
+					samples, sampleRate = resamplers[i].resample(samples), MODEL_SAMPLE_RATE
+				}
+				voiced = voiced || (split && isVoiced(samples))
+				transcribers[i].accept(samples, sampleRate)
+			}
 
-			for recognizer.IsReady(stream) {
-				recognizer.Decode(stream)
+			// The track talks while any of its channels does, its talk time is counted once
+			if voiced && countsTalkTime(label) {
+				transcribers[0].session.userSession.addTalkTime(len(pcmSamples)/format.channels, format.sampleRate)
 			}
+		}
+	}
+}
 
-			text := recognizer.GetResult(stream).Text
-			if len(text) != 0 && last_text != text {
-				last_text = strings.ToLower(text)
-				slog.Info("Transcription", "text", last_text)
-				userSession.appendToBuffer(last_text)
+// transcriber feeds the audio of a user to the recognizer, and publishes the utterances to the sink.
+// Every ingestion path shares it, whatever the transport and the codec of the audio.
+type transcriber struct {
+	roomID  string
+	userID  string
+	session *transcriptionSession
+	sink    resultSink
+	// talk counts the voiced audio as talk time of the user, the channels of a split track count it together
+	talk bool
+}
 
-				profanityScore, err := userSession.analyzeBuffer(wsConn, mu)
-				if err != nil {
-					slog.Error("Error analyzing buffer", "error", err)
-					continue
-				}
+// newTranscriber starts the transcription of a source of the audio of the user, continuing the session of a
+// dropped connection
+func newTranscriber(roomID string, userID string, source audioSource, sink resultSink) *transcriber {
+	// A user reconnecting after a network drop continues its previous transcription
+	session, resumed := resumeSession(roomID, userID, source)
+	if resumed {
+		slog.Info("Transcription session resumed", "roomID", roomID, "userID", userID, "label", source.label, "channel", source.channel)
+	} else {
+		session = &transcriptionSession{userSession: &UserSession{}, stream: GetStream(), source: source}
+		session.userSession.startNewSession(roomID, userID)
+	}
+	return &transcriber{roomID: roomID, userID: userID, session: session, sink: sink, talk: countsTalkTime(source.label)}
+}
+
+// receive records the arrival of audio, before it is decoded
+func (t *transcriber) receive() {
+	t.session.observeReceive(t.roomID, t.userID)
+}
+
+// reset drops the audio of the current utterance
+func (t *transcriber) reset() {
+	recognizer.Reset(t.session.stream)
+}
 
-				slog.Info("Profanity score", "score", profanityScore)
+// accept transcribes the samples, at their sample rate, and publishes the utterance once the text changed
+func (t *transcriber) accept(samples []float32, sampleRate int) {
+	if len(samples) == 0 {
+		return
+	}
+	session := t.session
+	stream := session.stream
+	if t.talk && isVoiced(samples) {
+		session.userSession.addTalkTime(len(samples), sampleRate)
+	}
 
-				uuid := uuid.New().String()
-				mu.Lock()
-				wsConn.WriteJSON(WebSocketTranscription{
-					Type:           "transcription",
-					Text:           last_text,
-					Uuid:           uuid,
-					ProfanityScore: profanityScore,
-				})
-				mu.Unlock()
+	// Process samples, the recognizer resamples them to MODEL_SAMPLE_RATE
+	stream.AcceptWaveform(sampleRate, samples)
 
-				recognizer.Reset(stream)
-			}
+	start := time.Now()
+	for recognizer.IsReady(stream) {
+		recognizer.Decode(stream)
+	}
+	session.observeDecode(time.Since(start), len(samples), sampleRate)
+
+	text := recognizer.GetResult(stream).Text
+	if len(text) != 0 && session.lastText != text {
+		session.lastText = strings.ToLower(text)
+		if publishUtterance(session, t.roomID, t.userID, t.sink) {
+			recognizer.Reset(stream)
 		}
 	}
 }
 
-// decodeRTPPayload decodes the RTP payload into PCM samples
-func decodeRTPPayload(decoder *opus.Decoder, payload []byte) ([]int16, error) {
+// stop ends the transcription once the context is done. A shutdown flushes the last utterance,
+// a lost connection parks the session for the client to resume it.
+func (t *transcriber) stop(ctx context.Context) {
+	defer t.session.userSession.flushTalkTime()
+
+	slog.Info("Transcription stopped by the context")
+	if errors.Is(context.Cause(ctx), errServerShutdown) {
+		// The stream is not reused, the recognizer is deleted after the flush
+		flushTranscription(t.session, t.roomID, t.userID, t.sink)
+		return
+	}
+	if resumeGracePeriod > 0 && connectionLost(ctx) {
+		parkSession(t.roomID, t.userID, t.session)
+	} else {
+		PutStream(t.session.stream)
+	}
+}
+
+// publishUtterance scores the last text of the session, records it and sends it to the user.
+// It returns false when the text could not be scored.
+func publishUtterance(session *transcriptionSession, roomID string, userID string, sink resultSink) bool {
+	userSession := session.userSession
+	slog.Info("Transcription", "text", session.lastText)
+	userSession.appendToBuffer(session.lastText)
+
+	ctx, endpoint := session.traceEndpoint()
+	profanityScore, err := userSession.analyzeBuffer(ctx, sink)
+	if err != nil {
+		slog.Error("Error analyzing buffer", "error", err)
+		tracing.End(endpoint, err)
+		// The failed utterance ends here, the next one starts its own span and real-time factor
+		session.observeUtterance()
+		return false
+	}
+
+	slog.Info("Profanity score", "score", profanityScore)
+	report.Meetings.AddUtterance(roomID, userID, session.lastText, profanityScore)
+	transcription.Connections.AddUtterance(roomID, userID, classifier.IsFlagged(profanityScore))
+	userSession.flushTalkTime()
+
+	uuid := uuid.New().String()
+	_, span := tracing.Tracer.Start(ctx, tracing.SPAN_WEBSOCKET_WRITE)
+	span.SetAttributes(attribute.String("message.type", "transcription"))
+	err = sink.send(WebSocketTranscription{
+		Type:           "transcription",
+		Text:           session.lastText,
+		Uuid:           uuid,
+		ProfanityScore: profanityScore,
+		Label:          session.source.label,
+		Channel:        session.source.channel,
+	})
+	tracing.End(span, err)
+	if err != nil {
+		slog.Error("Error writing transcription", "error", err)
+	}
+
+	endpoint.End()
+	session.observeUtterance()
+	return true
+}
+
+// flushTranscription decodes the audio left in the stream and publishes it as the final utterance
+func flushTranscription(session *transcriptionSession, roomID string, userID string, sink resultSink) {
+	stream := session.stream
+	stream.InputFinished()
+	for recognizer.IsReady(stream) {
+		recognizer.Decode(stream)
+	}
+
+	text := strings.ToLower(recognizer.GetResult(stream).Text)
+	if len(text) != 0 && session.lastText != text {
+		session.lastText = text
+		publishUtterance(session, roomID, userID, sink)
+	}
+	slog.Info("Transcription flushed", "roomID", roomID, "userID", userID)
+}
+
+// decodeRTPPayload decodes the RTP payload into PCM samples, interleaved when there are several channels
+func decodeRTPPayload(decoder *opus.Decoder, payload []byte, channels int) ([]int16, error) {
 	// Allocate space for PCM samples
 	// Max Opus frame size is 120ms: 48,000 Hz * 0.06 seconds = 5760 samples
-	pcm := make([]int16, 5760)
+	pcm := make([]int16, 5760*channels)
 
 	// Decode the Opus payload into PCM
 	n, err := decoder.Decode(payload, pcm)
@@ -190,6 +366,6 @@ func decodeRTPPayload(decoder *opus.Decoder, payload []byte) ([]int16, error) {
 		return nil, fmt.Errorf("failed to decode Opus payload: %v", err)
 	}
 
-	// Return the decoded PCM samples
-	return pcm[:n], nil
+	// Return the decoded PCM samples, n is counted per channel
+	return pcm[:n*channels], nil
 }
@@ -0,0 +1,41 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"log/slog"
+
+	sherpa "github.com/k2-fsa/sherpa-onnx-go/sherpa_onnx"
+	"profanity.com/transcription"
+)
+
+// Shutdown stops accepting transcriptions and lets the running ones flush their last utterance and moderation
+// results until the context is done. The connections and the recognizer are closed afterwards.
+func Shutdown(ctx context.Context) error {
+	drain()
+
+	flushed := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(flushed)
+	}()
+
+	var err error
+	select {
+	case <-flushed:
+		slog.Info("Transcriptions flushed")
+	case <-ctx.Done():
+		err = ctx.Err()
+		slog.Warn("Transcriptions still running at shutdown", "err", err)
+	}
+
+	// Closing the websockets closes the peer connections
+	transcription.Connections.CloseAll()
+
+	// A transcription still running would use a deleted recognizer
+	if err == nil {
+		sherpa.DeleteOnlineRecognizer(recognizer)
+	}
+	return err
+}
@@ -0,0 +1,95 @@
+package webrtcserver
+
+import (
+	"encoding/json"
+	"errors"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/pion/webrtc/v4"
+	"profanity.com/metrics"
+)
+
+// errEventsFull is the error of an event dropped because the client does not read its event stream
+var errEventsFull = errors.New("event stream is full")
+
+// resultSink delivers the transcriptions and the LLM analyses to the client
+type resultSink interface {
+	send(v any) error
+}
+
+// wsSink writes the results on the signaling websocket, the writes are serialized by the mutex
+type wsSink struct {
+	conn *websocket.Conn
+	mu   *sync.Mutex
+}
+
+func (s wsSink) send(v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	err := s.conn.WriteJSON(v)
+	if err != nil {
+		metrics.WebSocketWriteErrors.WithLabelValues(metrics.ENDPOINT_TRANSCRIPTION).Inc()
+	}
+	return err
+}
+
+// peerSink sends the results on the data channel of the peer connection once it is open. The signaling
+// websocket carries them until then, when the client did not negotiate the channel, or while the channel is
+// congested.
+type peerSink struct {
+	channel  *webrtc.DataChannel
+	fallback wsSink
+}
+
+func (s peerSink) send(v any) error {
+	if s.channel == nil || s.channel.ReadyState() != webrtc.DataChannelStateOpen {
+		return s.fallback.send(v)
+	}
+	// A congested channel would delay the results further, the websocket carries them until it drains
+	if s.channel.BufferedAmount() < DATA_CHANNEL_MAX_BUFFERED {
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		// The channel serializes its sends, the results of the tracks do not wait on each other
+		if err := s.channel.SendText(string(data)); err == nil {
+			return nil
+		}
+	}
+	metrics.DataChannelFallbacks.Inc()
+	return s.fallback.send(v)
+}
+
+// eventStream queues the results for the server-sent events of a client without websocket.
+// The events are dropped while the queue is full, a slow reader never blocks the transcription.
+type eventStream struct {
+	events chan []byte
+	done   chan struct{}
+	once   sync.Once
+}
+
+// newEventStream returns an event stream queueing up to size events
+func newEventStream(size int) *eventStream {
+	return &eventStream{events: make(chan []byte, size), done: make(chan struct{})}
+}
+
+func (s *eventStream) send(v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	select {
+	case s.events <- data:
+		return nil
+	default:
+		metrics.WebSocketWriteErrors.WithLabelValues(metrics.ENDPOINT_EVENTS).Inc()
+		return errEventsFull
+	}
+}
+
+// close ends the stream, its reader returns
+func (s *eventStream) close() {
+	s.once.Do(func() { close(s.done) })
+}
@@ -0,0 +1,92 @@
+package webrtcserver
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// TestPeerSink tests that the results go through the data channel negotiated by the client
+func TestPeerSink(t *testing.T) {
+	client, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := webrtc.NewPeerConnection(webrtc.Configuration{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	negotiated, id := true, uint16(DATA_CHANNEL_ID)
+	init := &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &id}
+	results, err := client.CreateDataChannel(DATA_CHANNEL_LABEL, init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	received := make(chan []byte, 1)
+	results.OnMessage(func(msg webrtc.DataChannelMessage) { received <- msg.Data })
+
+	channel, err := server.CreateDataChannel(DATA_CHANNEL_LABEL, init)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opened := make(chan struct{})
+	channel.OnOpen(func() { close(opened) })
+
+	// The candidates are gathered before the descriptions are exchanged
+	offer, err := client.CreateOffer(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gathered := webrtc.GatheringCompletePromise(client)
+	client.SetLocalDescription(offer)
+	<-gathered
+	answer, err := negotiateLocally(server, *client.LocalDescription())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := client.SetRemoteDescription(answer); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-opened:
+	case <-time.After(10 * time.Second):
+		t.Fatal("the data channel did not open")
+	}
+
+	sink := peerSink{channel: channel}
+	if err := sink.send(WebSocketTranscription{Type: "transcription", Text: "hello", Label: "mic"}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case data := <-received:
+		var result WebSocketTranscription
+		if err := json.Unmarshal(data, &result); err != nil || result.Text != "hello" || result.Label != "mic" {
+			t.Errorf("expected the transcription, got %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the transcription was not received")
+	}
+}
+
+// negotiateLocally answers the offer once the candidates of the answerer are gathered
+func negotiateLocally(peer *webrtc.PeerConnection, offer webrtc.SessionDescription) (webrtc.SessionDescription, error) {
+	if err := peer.SetRemoteDescription(offer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	answer, err := peer.CreateAnswer(nil)
+	if err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	gathered := webrtc.GatheringCompletePromise(peer)
+	if err := peer.SetLocalDescription(answer); err != nil {
+		return webrtc.SessionDescription{}, err
+	}
+	<-gathered
+	return *peer.LocalDescription(), nil
+}
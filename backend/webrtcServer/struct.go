@@ -1,5 +1,12 @@
 package webrtcserver
 
+import (
+	"context"
+	"sync"
+
+	"github.com/pion/webrtc/v4"
+)
+
 type WebSocketMessage struct {
 	Type      string `json:"type"`
 	SDP       string `json:"sdp,omitempty"`
@@ -10,6 +17,11 @@ type WebSocketMessage struct {
 		UsernameFragment string `json:"usernameFragment"`
 	} `json:"candidate,omitempty"`
 	IsStreaming bool `json:"isStreaming,omitempty"`
+
+	// Labels of the audio tracks sent with the offer, by track ID or stream ID, and the label of the track
+	// whose streaming is toggled (every track when empty)
+	Tracks map[string]string `json:"tracks,omitempty"`
+	Label  string            `json:"label,omitempty"`
 }
 
 type WebSocketTranscription struct {
@@ -17,15 +29,29 @@ type WebSocketTranscription struct {
 	Text           string  `json:"text"`
 	Uuid           string  `json:"uuid"`
 	ProfanityScore float64 `json:"profanity_score"`
+	Label          string  `json:"label,omitempty"`
+	Channel        string  `json:"channel,omitempty"`
 }
 
-// Profanity structs
-type PostData struct {
-	Text string `json:"text"`
+// audioSource identifies an audio of the user when several are transcribed at once, a labeled track or one
+// channel of a stereo track
+type audioSource struct {
+	label   string
+	channel string
 }
 
-type PostResponse struct {
-	ProfanityScore float64 `json:"profanity_score"`
+// OfferRejected tells the client its offer was not answered, with the reason
+type OfferRejected struct {
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// UnsupportedCodec tells the client a track was rejected, its audio is not transcribed
+type UnsupportedCodec struct {
+	Type      string `json:"type"`
+	MimeType  string `json:"mime_type"`
+	ClockRate uint32 `json:"clock_rate"`
+	Channels  uint16 `json:"channels"`
 }
 
 type LLMAnalysis struct {
@@ -34,3 +60,76 @@ type LLMAnalysis struct {
 	UserMessage string `json:"user_message"`
 	Timestamp   string `json:"timestamp"`
 }
+
+// TelephonyMessage is an event of the media stream of a phone call, in both directions
+type TelephonyMessage struct {
+	Event          string          `json:"event"`
+	SequenceNumber string          `json:"sequenceNumber,omitempty"`
+	StreamSid      string          `json:"streamSid,omitempty"`
+	Start          *TelephonyStart `json:"start,omitempty"`
+	Media          *TelephonyMedia `json:"media,omitempty"`
+	Mark           *TelephonyMark  `json:"mark,omitempty"`
+
+	// Transcription or LLM analysis carried by a mark of the server
+	Result any `json:"result,omitempty"`
+}
+
+type TelephonyStart struct {
+	StreamSid string   `json:"streamSid"`
+	CallSid   string   `json:"callSid"`
+	Tracks    []string `json:"tracks"`
+	// Parameters given to the stream when the call was set up, the invite token among them
+	CustomParameters map[string]string `json:"customParameters"`
+	MediaFormat      struct {
+		Encoding   string `json:"encoding"`
+		SampleRate int    `json:"sampleRate"`
+		Channels   int    `json:"channels"`
+	} `json:"mediaFormat"`
+}
+
+type TelephonyMedia struct {
+	Track     string `json:"track"`
+	Chunk     string `json:"chunk"`
+	Timestamp string `json:"timestamp"`
+	Payload   string `json:"payload"`
+}
+
+type TelephonyMark struct {
+	Name string `json:"name"`
+}
+
+// ingestSession is a transcription ingested without our signaling, with WHIP or plain RTP.
+// Its results are read from its event stream.
+type ingestSession struct {
+	id     string
+	kind   string
+	roomID string
+	userID string
+	events *eventStream
+	cancel context.CancelCauseFunc
+
+	// release frees the transport of the session once it ended
+	release func()
+
+	// Peer connection of a WHIP session, the client trickles its candidates to it
+	peerConnection *webrtc.PeerConnection
+
+	// These clients only send the audio to transcribe, their tracks are always transcribed
+	tracks *peerTracks
+
+	// ended is closed once the session must be torn down
+	ended   chan struct{}
+	endOnce sync.Once
+}
+
+// rtpSession describes the UDP port allocated to a plain RTP session
+type rtpSession struct {
+	ID            string `json:"id"`
+	Host          string `json:"host,omitempty"`
+	Port          int    `json:"port"`
+	Codec         string `json:"codec"`
+	ClockRate     int    `json:"clock_rate"`
+	Channels      int    `json:"channels"`
+	Events        string `json:"events"`
+	MaxPacketSize int    `json:"max_packet_size"`
+}
@@ -0,0 +1,230 @@
+package webrtcserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/classifier"
+	"profanity.com/heartbeat"
+	"profanity.com/invite"
+	"profanity.com/metrics"
+	"profanity.com/moderation"
+	"profanity.com/transcription"
+)
+
+var (
+	// errNotStarted is the error of a media event received before the start of the stream
+	errNotStarted = errors.New("media received before the start of the stream")
+	// errStreamStopped ends the transcription of a call whose media stream stopped
+	errStreamStopped = errors.New("media stream stopped")
+)
+
+// audioTranscriber transcribes the audio of a call, and sends its results to the sink it was started with
+type audioTranscriber interface {
+	receive()
+	accept(samples []float32, sampleRate int)
+	stop(ctx context.Context)
+}
+
+// telephonyStream decodes the media stream of a phone call, the μ-law audio of the caller is resampled for
+// the recognizer
+type telephonyStream struct {
+	streamSid string
+	resampler *resampler
+}
+
+// handle parses an event of the stream, and returns the samples of the caller at MODEL_SAMPLE_RATE
+func (s *telephonyStream) handle(data []byte) (TelephonyMessage, []float32, error) {
+	var msg TelephonyMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return msg, nil, err
+	}
+
+	switch msg.Event {
+	case TELEPHONY_START:
+		if msg.Start == nil {
+			return msg, nil, fmt.Errorf("start event without its description")
+		}
+		format := msg.Start.MediaFormat
+		if format.Encoding != TELEPHONY_ENCODING_MULAW || format.Channels > 1 {
+			return msg, nil, fmt.Errorf("unsupported media format %s with %d channels", format.Encoding, format.Channels)
+		}
+		sampleRate := format.SampleRate
+		if sampleRate == 0 {
+			sampleRate = DEFAULT_TELEPHONY_SAMPLE_RATE
+		}
+		if sampleRate < MIN_PCM_SAMPLE_RATE || sampleRate > MAX_PCM_SAMPLE_RATE {
+			return msg, nil, fmt.Errorf("unsupported sample rate %d", sampleRate)
+		}
+
+		s.streamSid = msg.StreamSid
+		if s.streamSid == "" {
+			s.streamSid = msg.Start.StreamSid
+		}
+		s.resampler = newResampler(sampleRate, MODEL_SAMPLE_RATE)
+
+	case TELEPHONY_MEDIA:
+		if s.resampler == nil {
+			return msg, nil, errNotStarted
+		}
+		if msg.Media == nil {
+			return msg, nil, fmt.Errorf("media event without its payload")
+		}
+		// Only the caller is moderated, the outbound track of the calls streaming both is skipped
+		if msg.Media.Track != "" && msg.Media.Track != TELEPHONY_TRACK_INBOUND {
+			return msg, nil, nil
+		}
+		payload, err := base64.StdEncoding.DecodeString(msg.Media.Payload)
+		if err != nil {
+			return msg, nil, err
+		}
+		return msg, s.resampler.resample(PcmToFloat32(decodeMulaw(payload))), nil
+	}
+	return msg, nil, nil
+}
+
+// callAuthorizer returns the room and the user of a call, from the description of its stream
+type callAuthorizer func(start *TelephonyStart) (roomID string, userID string, err error)
+
+// authorizeCall returns the room and the user of the personal invite token passed with the start of the stream,
+// by the providers which cannot add it to the request. The token stands for the access token they cannot send.
+func authorizeCall(start *TelephonyStart) (string, string, error) {
+	claims, err := invite.Tokens.VerifyPersonal(start.CustomParameters[TELEPHONY_TOKEN_PARAMETER])
+	if err != nil {
+		return "", "", err
+	}
+	return claims.RoomID, claims.UserID, nil
+}
+
+// serveTelephony reads the events of the media stream until it stops or the connection fails, and cancels the
+// context with the cause. The call is authorized and its transcription started with the stream, the provider
+// sends its format and its parameters first.
+func serveTelephony(ctx context.Context, cancel context.CancelCauseFunc, conn *websocket.Conn, authorize callAuthorizer, start func(roomID string, userID string, sink resultSink) audioTranscriber) {
+	var (
+		connMu         sync.Mutex
+		t              audioTranscriber
+		connection     *transcription.Connection
+		roomID, userID string
+	)
+	defer func() {
+		if t != nil {
+			t.stop(ctx)
+		}
+		if connection != nil {
+			transcription.Connections.Unregister(connection)
+		}
+	}()
+	stream := &telephonyStream{}
+
+	// The connection is not authorized until the stream starts, it is not kept open without it
+	conn.SetReadDeadline(time.Now().Add(TELEPHONY_START_TIMEOUT))
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			slog.Info("Telephony stream ended", "roomID", roomID, "userID", userID, "err", err)
+			cancel(err)
+			return
+		}
+		if t != nil {
+			heartbeat.Extend(conn, pongTimeout)
+		}
+
+		msg, samples, err := stream.handle(message)
+		if err != nil {
+			slog.Warn("Invalid telephony event", "roomID", roomID, "userID", userID, "event", msg.Event, "err", err)
+			if msg.Event == TELEPHONY_START {
+				closeCall(conn, websocket.CloseUnsupportedData, err)
+				cancel(err)
+				return
+			}
+			continue
+		}
+
+		switch msg.Event {
+		case TELEPHONY_START:
+			if t != nil {
+				continue
+			}
+			if roomID, userID, err = authorize(msg.Start); err != nil {
+				slog.Info("Telephony stream refused", "streamSid", stream.streamSid, "err", err)
+				closeCall(conn, websocket.ClosePolicyViolation, err)
+				cancel(err)
+				return
+			}
+			slog.Info("Telephony stream started", "roomID", roomID, "userID", userID, "streamSid", stream.streamSid)
+
+			// The registry exposes the call to the admin API, which may close it
+			connection = transcription.Connections.Register(roomID, userID, func() {
+				cancel(errClosedByServer)
+				conn.Close()
+			})
+			transcription.Connections.SetCodec(roomID, userID, msg.Start.MediaFormat.Encoding)
+			transcription.Connections.SetStreaming(roomID, userID, true)
+			t = start(roomID, userID, telephonySink{conn: conn, mu: &connMu, streamSid: stream.streamSid})
+			heartbeat.Extend(conn, pongTimeout)
+		case TELEPHONY_MEDIA:
+			// Skip if the call is not started, or if a host muted the user or paused its transcription
+			if t == nil || len(samples) == 0 || !moderation.Participants.TranscriptionAllowed(roomID, userID) {
+				continue
+			}
+			t.receive()
+			t.accept(samples, MODEL_SAMPLE_RATE)
+		case TELEPHONY_MARK:
+			// The provider played the audio up to one of our marks
+			slog.Debug("Telephony mark reached", "streamSid", stream.streamSid, "mark", msg.Mark)
+		case TELEPHONY_STOP:
+			cancel(errStreamStopped)
+			return
+		}
+	}
+}
+
+// closeCall closes the media stream with the code and the reason
+func closeCall(conn *websocket.Conn, code int, reason error) {
+	closeMessage := websocket.FormatCloseMessage(code, reason.Error())
+	conn.WriteControl(websocket.CloseMessage, closeMessage, time.Now().Add(PING_WRITE_TIMEOUT))
+}
+
+// telephonySink writes the results to the media stream as marks. The audio queued for the caller is cleared
+// before the mark of a flagged transcription, a bot does not keep answering profanity.
+type telephonySink struct {
+	conn      *websocket.Conn
+	mu        *sync.Mutex
+	streamSid string
+}
+
+func (s telephonySink) send(v any) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := "result"
+	switch result := v.(type) {
+	case WebSocketTranscription:
+		name = result.Type + ":" + result.Uuid
+		if classifier.IsFlagged(result.ProfanityScore) {
+			if err := s.write(TelephonyMessage{Event: TELEPHONY_CLEAR, StreamSid: s.streamSid}); err != nil {
+				return err
+			}
+		}
+	case LLMAnalysis:
+		name = result.Type
+	}
+	return s.write(TelephonyMessage{Event: TELEPHONY_MARK, StreamSid: s.streamSid, Mark: &TelephonyMark{Name: name}, Result: v})
+}
+
+// write sends the event, the lock is held by the caller
+func (s telephonySink) write(msg TelephonyMessage) error {
+	s.conn.SetWriteDeadline(time.Now().Add(TELEPHONY_WRITE_TIMEOUT))
+	err := s.conn.WriteJSON(msg)
+	if err != nil {
+		metrics.WebSocketWriteErrors.WithLabelValues(metrics.ENDPOINT_TELEPHONY).Inc()
+	}
+	return err
+}
@@ -0,0 +1,58 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"profanity.com/heartbeat"
+	"profanity.com/invite"
+)
+
+// handleTelephony moderates a phone call streamed by a telephony provider, with the start, media and stop
+// events of its media stream. The results are written back as marks on the same websocket.
+// The invite token comes with the request, or with the start of the stream for the providers which cannot pass it.
+func handleTelephony(w http.ResponseWriter, r *http.Request) {
+	authorize := authorizeCall
+	if invite.FromRequest(r) != "" {
+		roomID, userID, ok := authorizeTranscription(w, r)
+		if !ok {
+			return
+		}
+		authorize = func(*TelephonyStart) (string, string, error) { return roomID, userID, nil }
+	} else if recognizerErr != nil {
+		http.Error(w, "Transcription is unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	if !startWork() {
+		http.Error(w, "Server is shutting down", http.StatusServiceUnavailable)
+		return
+	}
+	defer inFlight.Done()
+
+	wsConn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		slog.Error("WebSocket connection upgrade failed", "Error", err)
+		return
+	}
+	defer wsConn.Close()
+	wsConn.SetReadLimit(MAX_TELEPHONY_MESSAGE_SIZE)
+	heartbeat.ExpectPongs(wsConn, pongTimeout)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go sendPings(ctx, wsConn)
+
+	// A draining server stops the transcription once its last utterance is flushed
+	stopDrain := context.AfterFunc(drained, func() { cancel(errServerShutdown) })
+	defer stopDrain()
+	// The pending read returns once the context is done
+	stopRead := context.AfterFunc(ctx, func() { wsConn.SetReadDeadline(time.Now()) })
+	defer stopRead()
+
+	serveTelephony(ctx, cancel, wsConn, authorize, func(roomID string, userID string, sink resultSink) audioTranscriber {
+		return newTranscriber(roomID, userID, audioSource{}, sink)
+	})
+}
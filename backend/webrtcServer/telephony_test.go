@@ -0,0 +1,212 @@
+package webrtcserver
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"profanity.com/invite"
+)
+
+// TestResampler tests that the chunks are resampled without seams
+func TestResampler(t *testing.T) {
+	r := newResampler(8000, MODEL_SAMPLE_RATE)
+
+	var output []float32
+	for range 10 {
+		chunk := make([]float32, 160)
+		for i := range chunk {
+			chunk[i] = 0.5
+		}
+		output = append(output, r.resample(chunk)...)
+	}
+	if len(output) < 3198 || len(output) > 3200 {
+		t.Errorf("expected about 3200 samples, got %d", len(output))
+	}
+	for i, sample := range output {
+		if sample != 0.5 {
+			t.Fatalf("expected a constant signal, got %f at %d", sample, i)
+		}
+	}
+}
+
+// replayTranscriber counts the audio of the call, and flags its first utterance
+type replayTranscriber struct {
+	roomID   string
+	userID   string
+	sink     resultSink
+	received int
+	samples  int
+	stopped  bool
+}
+
+func (t *replayTranscriber) receive() {
+	t.received++
+}
+
+func (t *replayTranscriber) accept(samples []float32, sampleRate int) {
+	if t.samples == 0 {
+		t.sink.send(WebSocketTranscription{Type: "transcription", Text: "profane words", Uuid: "1", ProfanityScore: 0.99})
+	}
+	t.samples += len(samples)
+}
+
+func (t *replayTranscriber) stop(ctx context.Context) {
+	t.stopped = true
+}
+
+// replayCall serves the media stream of a call with the replay transcriber, authorized by the invite token of its
+// start, and returns the websocket of the provider. The transcriber and the cause of the end of the call are
+// sent once the handler returns.
+func replayCall(t *testing.T) (*websocket.Conn, <-chan *replayTranscriber, <-chan error) {
+	transcribers, causes := make(chan *replayTranscriber, 1), make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+
+		ctx, cancel := context.WithCancelCause(context.Background())
+		var replay *replayTranscriber
+		serveTelephony(ctx, cancel, conn, authorizeCall, func(roomID string, userID string, sink resultSink) audioTranscriber {
+			if replay != nil {
+				t.Error("expected a single transcription per call")
+			}
+			replay = &replayTranscriber{roomID: roomID, userID: userID, sink: sink}
+			return replay
+		})
+		transcribers <- replay
+		causes <- context.Cause(ctx)
+	}))
+	t.Cleanup(server.Close)
+
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return client, transcribers, causes
+}
+
+// TestTelephonyReplay replays a recorded call to the handler of the media streams, and tests that the call is
+// authorized by the token of its start, and the audio of the caller transcribed from the start to the stop of the
+// stream, with the results as marks
+func TestTelephonyReplay(t *testing.T) {
+	client, transcribers, causes := replayCall(t)
+	token, _, err := invite.Tokens.Sign("room", "caller", invite.ROLE_GUEST)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fixture, err := os.Open("testdata/telephony_call.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fixture.Close()
+	scanner := bufio.NewScanner(fixture)
+	for scanner.Scan() {
+		// The provider passes the parameters given to the stream when the call was set up
+		event := strings.Replace(scanner.Text(), `"customParameters":{}`, `"customParameters":{"token":"`+token+`"}`, 1)
+		if err := client.WriteMessage(websocket.TextMessage, []byte(event)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var clear, mark TelephonyMessage
+	if err := client.ReadJSON(&clear); err != nil {
+		t.Fatal(err)
+	}
+	if err := client.ReadJSON(&mark); err != nil {
+		t.Fatal(err)
+	}
+
+	var replay *replayTranscriber
+	select {
+	case replay = <-transcribers:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call did not end with its stop event")
+	}
+	if cause := <-causes; !errors.Is(cause, errStreamStopped) {
+		t.Errorf("expected the call to end with its stream, got %v", cause)
+	}
+	if replay == nil || !replay.stopped {
+		t.Fatalf("expected the transcription to start and stop with the stream, got %+v", replay)
+	}
+	if replay.roomID != "room" || replay.userID != "caller" {
+		t.Errorf("expected the call of the caller in the room of the token, got %q and %q", replay.roomID, replay.userID)
+	}
+	// 25 chunks of 20 ms at 8 kHz, resampled at 16 kHz, the mark of the provider is not audio
+	if replay.received != 25 || replay.samples < 7998 || replay.samples > 8000 {
+		t.Errorf("expected 25 chunks of about 8000 samples, got %d chunks of %d samples", replay.received, replay.samples)
+	}
+
+	streamSid := "MZ18ad3ab5a668481ce02b83e7395059f0"
+	if clear.Event != TELEPHONY_CLEAR || clear.StreamSid != streamSid {
+		t.Errorf("expected the flagged transcription to clear the audio of the stream, got %+v", clear)
+	}
+	if mark.Event != TELEPHONY_MARK || mark.StreamSid != streamSid || mark.Mark == nil || mark.Mark.Name != "transcription:1" || mark.Result == nil {
+		t.Errorf("expected the mark of the transcription, got %+v", mark)
+	}
+}
+
+// TestTelephonyUnsupportedStart tests that a call in an unsupported format is closed before any transcription
+func TestTelephonyUnsupportedStart(t *testing.T) {
+	client, transcribers, causes := replayCall(t)
+
+	start := `{"event":"start","start":{"streamSid":"MZ1","mediaFormat":{"encoding":"audio/l16","sampleRate":8000,"channels":1}}}`
+	if err := client.WriteMessage(websocket.TextMessage, []byte(start)); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	_, _, err := client.ReadMessage()
+	if !websocket.IsCloseError(err, websocket.CloseUnsupportedData) {
+		t.Errorf("expected the call to be closed as unsupported, got %v", err)
+	}
+
+	if replay := <-transcribers; replay != nil {
+		t.Error("expected no transcription of the unsupported call")
+	}
+	if cause := <-causes; cause == nil || errors.Is(cause, errStreamStopped) {
+		t.Errorf("expected the call to end with the format error, got %v", cause)
+	}
+}
+
+// TestTelephonyUnauthorizedStart tests that a call without a personal invite token is closed before any
+// transcription, the shared invite of the room is not enough
+func TestTelephonyUnauthorizedStart(t *testing.T) {
+	shared, _, err := invite.Tokens.SignInvite("room", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, parameters := range map[string]string{"missing": `{}`, "shared": `{"token":"` + shared + `"}`} {
+		t.Run(name, func(t *testing.T) {
+			client, transcribers, causes := replayCall(t)
+
+			start := `{"event":"start","start":{"streamSid":"MZ1","customParameters":` + parameters + `,"mediaFormat":{"encoding":"audio/x-mulaw","sampleRate":8000,"channels":1}}}`
+			if err := client.WriteMessage(websocket.TextMessage, []byte(start)); err != nil {
+				t.Fatal(err)
+			}
+			client.SetReadDeadline(time.Now().Add(5 * time.Second))
+			_, _, err := client.ReadMessage()
+			if !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+				t.Errorf("expected the call to be refused, got %v", err)
+			}
+
+			if replay := <-transcribers; replay != nil {
+				t.Error("expected no transcription of the unauthorized call")
+			}
+			if cause := <-causes; cause == nil || errors.Is(cause, errStreamStopped) {
+				t.Errorf("expected the call to end with the token error, got %v", cause)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package webrtcserver
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// errDuplicateLabel is the error of an offer giving the same label to several tracks
+var errDuplicateLabel = errors.New("label given to several tracks")
+
+// peerTracks holds the labels given by the client to the audio tracks of its peer connection, and whether each
+// of them is transcribed. The tracks are toggled on their own by label, or all together.
+type peerTracks struct {
+	mu sync.Mutex
+	// Labels by track ID or stream ID, and the streaming state by label
+	labels    map[string]string
+	streaming map[string]*atomic.Bool
+	// State of the tracks never toggled on their own
+	all bool
+	// Unlabeled tracks received, the first one has the empty label
+	unlabeled int
+}
+
+// newPeerTracks returns the tracks of a peer connection, transcribed from their start when streaming
+func newPeerTracks(streaming bool) *peerTracks {
+	return &peerTracks{labels: make(map[string]string), streaming: make(map[string]*atomic.Bool), all: streaming}
+}
+
+// setLabels records the labels sent by the client with its offer. The labels are refused when one of them is
+// given to several tracks, their transcriptions could not be told apart.
+func (p *peerTracks) setLabels(labels map[string]string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	owners := make(map[string]string, len(p.labels)+len(labels))
+	for id, label := range p.labels {
+		if _, relabeled := labels[id]; !relabeled {
+			owners[label] = id
+		}
+	}
+	for id, label := range labels {
+		if owner, ok := owners[label]; ok && owner != id {
+			return fmt.Errorf("%w: %q", errDuplicateLabel, label)
+		}
+		owners[label] = id
+	}
+
+	for id, label := range labels {
+		p.labels[id] = label
+	}
+	return nil
+}
+
+// label returns the label of the track. The first unlabeled track keeps the empty label of the clients with a
+// single track, the other ones are labeled by their order, the same on every connection of the client for its
+// transcriptions to resume.
+func (p *peerTracks) label(trackID string, streamID string) string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if label, ok := p.labels[trackID]; ok {
+		return label
+	}
+	if label, ok := p.labels[streamID]; ok {
+		return label
+	}
+	p.unlabeled++
+	if p.unlabeled == 1 {
+		return ""
+	}
+	return UNLABELED_TRACK_PREFIX + strconv.Itoa(p.unlabeled)
+}
+
+// countsTalkTime returns true if the voiced audio of the track with the label is talk time of the user, the
+// microphone or the single track of the clients without labels
+func countsTalkTime(label string) bool {
+	return label == "" || label == MIC_LABEL
+}
+
+// streamingOf returns the streaming state of the track with the label
+func (p *peerTracks) streamingOf(label string) *atomic.Bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.stateOf(label)
+}
+
+// stateOf returns the state of the label, starting it with the state of all the tracks. The lock is held.
+func (p *peerTracks) stateOf(label string) *atomic.Bool {
+	state, ok := p.streaming[label]
+	if !ok {
+		state = &atomic.Bool{}
+		state.Store(p.all)
+		p.streaming[label] = state
+	}
+	return state
+}
+
+// setStreaming starts or stops the transcription of the track with the label, or of every track without label
+func (p *peerTracks) setStreaming(label string, streaming bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if label != "" {
+		p.stateOf(label).Store(streaming)
+		return
+	}
+	p.all = streaming
+	for _, state := range p.streaming {
+		state.Store(streaming)
+	}
+}
+
+// anyStreaming returns true if a track is transcribed
+func (p *peerTracks) anyStreaming() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if len(p.streaming) == 0 {
+		return p.all
+	}
+	for _, state := range p.streaming {
+		if state.Load() {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,60 @@
+package webrtcserver
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestPeerTracks tests that the tracks keep the labels of the client, and are toggled on their own or all together
+func TestPeerTracks(t *testing.T) {
+	tracks := newPeerTracks(false)
+	if err := tracks.setLabels(map[string]string{"track-mic": "mic", "stream-tab": "shared tab audio"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracks.setLabels(map[string]string{"track-other": "mic"}); !errors.Is(err, errDuplicateLabel) {
+		t.Errorf("expected a label given to another track to be refused, got %v", err)
+	}
+	if err := tracks.setLabels(map[string]string{"track-mic": "mic"}); err != nil {
+		t.Errorf("expected a renegotiation to keep the labels, got %v", err)
+	}
+
+	if label := tracks.label("track-mic", "stream-mic"); label != "mic" {
+		t.Errorf("expected the label of the track, got %q", label)
+	}
+	if label := tracks.label("track-tab", "stream-tab"); label != "shared tab audio" {
+		t.Errorf("expected the label of the stream, got %q", label)
+	}
+	if label := tracks.label("track-a", "stream-a"); label != "" {
+		t.Errorf("expected the first unlabeled track to keep the empty label, got %q", label)
+	}
+	if label := tracks.label("track-b", "stream-b"); label != "track-2" {
+		t.Errorf("expected the next unlabeled track to be labeled by its order, got %q", label)
+	}
+	// A reconnecting client gets the same labels for its transcriptions to resume
+	reconnected := newPeerTracks(false)
+	reconnected.label("track-c", "stream-c")
+	if label := reconnected.label("track-d", "stream-d"); label != "track-2" {
+		t.Errorf("expected the order of the tracks to survive a reconnection, got %q", label)
+	}
+	if !countsTalkTime("") || !countsTalkTime("mic") || countsTalkTime("shared tab audio") || countsTalkTime("track-2") {
+		t.Error("expected only the microphone and the single unlabeled track to count as talk time")
+	}
+
+	mic, tab := tracks.streamingOf("mic"), tracks.streamingOf("shared tab audio")
+	if mic.Load() || tab.Load() || tracks.anyStreaming() {
+		t.Fatal("expected the tracks to start stopped")
+	}
+
+	tracks.setStreaming("", true)
+	if !mic.Load() || !tab.Load() {
+		t.Error("expected every track to stream")
+	}
+	if late := tracks.streamingOf("late"); !late.Load() {
+		t.Error("expected a new track to start with the state of every track")
+	}
+
+	tracks.setStreaming("shared tab audio", false)
+	if !mic.Load() || tab.Load() || !tracks.anyStreaming() {
+		t.Error("expected only the shared tab audio to stop")
+	}
+}
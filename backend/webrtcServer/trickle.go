@@ -0,0 +1,30 @@
+package webrtcserver
+
+import (
+	"strings"
+
+	"github.com/pion/webrtc/v4"
+)
+
+// parseTrickleCandidates returns the ICE candidates of a trickle ICE SDP fragment, each with the media section it belongs to
+func parseTrickleCandidates(fragment string) []webrtc.ICECandidateInit {
+	var candidates []webrtc.ICECandidateInit
+	var mid *string
+	for _, line := range strings.Split(fragment, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "a=mid:"):
+			value := strings.TrimPrefix(line, "a=mid:")
+			mid = &value
+		case strings.HasPrefix(line, "a=candidate:"):
+			candidate := webrtc.ICECandidateInit{Candidate: strings.TrimPrefix(line, "a="), SDPMid: mid}
+			if mid == nil {
+				// Without a media section, the candidate belongs to the first one
+				index := uint16(0)
+				candidate.SDPMLineIndex = &index
+			}
+			candidates = append(candidates, candidate)
+		}
+	}
+	return candidates
+}
@@ -1,5 +1,7 @@
 package webrtcserver
 
+import "math"
+
 // PcmToFloat32 converts PCM samples to normalized float32 samples
 func PcmToFloat32(pcm []int16) []float32 {
 	floatSamples := make([]float32, len(pcm))
@@ -9,3 +11,46 @@ func PcmToFloat32(pcm []int16) []float32 {
 	}
 	return floatSamples
 }
+
+// isVoiced returns true if the RMS energy of the samples is above the voice threshold
+func isVoiced(samples []float32) bool {
+	if len(samples) == 0 {
+		return false
+	}
+
+	var sum float64
+	for _, sample := range samples {
+		sum += float64(sample) * float64(sample)
+	}
+	return math.Sqrt(sum/float64(len(samples))) >= VOICE_RMS_THRESHOLD
+}
+
+// splitChannels returns the samples of every channel of the interleaved PCM samples.
+// Without split, the channels are downmixed to a single one.
+func splitChannels(pcm []int16, channels int, split bool) [][]int16 {
+	if channels <= 1 {
+		return [][]int16{pcm}
+	}
+
+	frames := len(pcm) / channels
+	if !split {
+		mono := make([]int16, frames)
+		for i := range mono {
+			var sum int
+			for c := range channels {
+				sum += int(pcm[i*channels+c])
+			}
+			mono[i] = int16(sum / channels)
+		}
+		return [][]int16{mono}
+	}
+
+	perChannel := make([][]int16, channels)
+	for c := range perChannel {
+		perChannel[c] = make([]int16, frames)
+		for i := range frames {
+			perChannel[c][i] = pcm[i*channels+c]
+		}
+	}
+	return perChannel
+}
@@ -5,60 +5,99 @@ package webrtcserver
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"log/slog"
 	"net/http"
 	"sync"
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v4"
+	"profanity.com/auth"
+	"profanity.com/heartbeat"
+	"profanity.com/metrics"
+	"profanity.com/transcription"
 )
 
 var upgrader = websocket.Upgrader{
-	CheckOrigin: func(r *http.Request) bool { return true }, // For development, REMOVE IN PRODUCTION
+	CheckOrigin: auth.CheckOrigin,
 }
 
-var peerConnections = make(map[*websocket.Conn]*webrtc.PeerConnection)
-var mu sync.Mutex
+var errICEFailed = errors.New("ICE connection failed")
 
 // AddWebRTCHandle starts the WebRTC server
 func AddWebRTCHandle() {
 	http.HandleFunc("/ws", handleWebSocket)
+
+	// WHIP ingestion, for the clients without our signaling
+	http.HandleFunc("OPTIONS /whip", handleWHIPOptions)
+	http.HandleFunc("POST /whip", handleWHIP)
+	http.HandleFunc("OPTIONS /whip/{id}", handleWHIPSessionOptions)
+	http.HandleFunc("PATCH /whip/{id}", handleWHIPPatch)
+	http.HandleFunc("DELETE /whip/{id}", handleWHIPDelete)
+	http.HandleFunc("GET /whip/{id}/events", handleWHIPEvents)
+
+	// Plain RTP and raw PCM ingestion, for the senders without WebRTC
+	http.HandleFunc("OPTIONS /v1/ingest/rtp", handleRTPOptions)
+	http.HandleFunc("POST /v1/ingest/rtp", handleRTP)
+	http.HandleFunc("OPTIONS /v1/ingest/rtp/{id}", handleRTPOptions)
+	http.HandleFunc("DELETE /v1/ingest/rtp/{id}", handleRTPDelete)
+	http.HandleFunc("GET /v1/ingest/rtp/{id}/events", handleRTPEvents)
+	http.HandleFunc("GET /v1/ingest/pcm", handlePCM)
+	http.HandleFunc("GET /v1/ingest/telephony", handleTelephony)
 }
 
 // handleWebSocket handles incoming WebRTC connections
 func handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	roomID, userID, ok := authorizeTranscription(w, r)
+	if !ok {
+		return
+	}
+
 	wsConn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		slog.Error("WebSocket connection upgrade failed", "Error", err)
 		return
 	}
 	defer wsConn.Close()
+	heartbeat.ExpectPongs(wsConn, pongTimeout)
 
-	// Register the MediaEngine
-	mediaEngine := webrtc.MediaEngine{}
-	if err := mediaEngine.RegisterDefaultCodecs(); err != nil {
-		slog.Error("Codec register failed", "Error", err)
-		return
-	}
+	// The writes of this websocket are serialized, they do not contend with the other connections
+	var wsMu sync.Mutex
+	sink := &peerSink{fallback: wsSink{conn: wsConn, mu: &wsMu}}
 
-	api := webrtc.NewAPI(webrtc.WithMediaEngine(&mediaEngine))
+	// Labels and streaming flags of the tracks, to start/stop their transcription
+	tracks := newPeerTracks(false)
 
-	// Create a new RTCPeerConnection
-	peerConnection, err := api.NewPeerConnection(webrtc.Configuration{})
+	// Done signal that stops the transcription and delete resources
+	ctx, cancel := context.WithCancelCause(context.Background())
+	go sendPings(ctx, wsConn)
+
+	// A draining server stops the transcription once its last utterance is flushed
+	stopDrain := context.AfterFunc(drained, func() { cancel(errServerShutdown) })
+	defer stopDrain()
+
+	// The registry exposes the connection to the admin API, which may close it
+	connection := transcription.Connections.Register(roomID, userID, func() {
+		cancel(errClosedByServer)
+		wsConn.Close()
+	})
+	defer transcription.Connections.Unregister(connection)
+
+	peerConnection, err := newPeerConnection(ctx, cancel, func() { wsConn.Close() }, roomID, userID, tracks, sink)
 	if err != nil {
 		slog.Error("New peer connection failed", "Error", err)
+		cancel(err)
 		return
 	}
-	mu.Lock()
-	peerConnections[wsConn] = peerConnection
-	mu.Unlock()
+	defer peerConnection.Close()
 
-	defer func() {
-		mu.Lock()
-		delete(peerConnections, wsConn)
-		mu.Unlock()
-		peerConnection.Close()
-	}()
+	// The results go through a data channel of the peer connection when the client negotiates it, the
+	// channel is opened before the offer for the tracks to use it
+	negotiated, channelID := true, uint16(DATA_CHANNEL_ID)
+	sink.channel, err = peerConnection.CreateDataChannel(DATA_CHANNEL_LABEL, &webrtc.DataChannelInit{Negotiated: &negotiated, ID: &channelID})
+	if err != nil {
+		slog.Warn("Data channel creation failed, the results go through the websocket", "err", err)
+	}
 
 	// Listen for ICE candidates and write them to the WebSocket
 	peerConnection.OnICECandidate(func(i *webrtc.ICECandidate) {
@@ -72,40 +111,24 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		mu.Lock()
-		defer mu.Unlock()
+		wsMu.Lock()
+		defer wsMu.Unlock()
 		if err := wsConn.WriteJSON(map[string]interface{}{"type": "iceCandidate", "candidate": string(candidate)}); err != nil {
 			slog.Error("Writing iceCandidate failed", "Error", err)
+			metrics.WebSocketWriteErrors.WithLabelValues(metrics.ENDPOINT_TRANSCRIPTION).Inc()
 			return
 		}
 	})
 
-	// Streaming flag to start/stop audio transcription
-	isStreaming := false
-
-	// Done signal that stops the transcription and delete resources
-	ctx, cancel := context.WithCancel(context.Background())
-
-	// Handle incoming audio
-	peerConnection.OnTrack(func(track *webrtc.TrackRemote, receiver *webrtc.RTPReceiver) {
-		codecName := track.Codec().MimeType
-		slog.Info("Got track, codec", "codecName", codecName)
-
-		if codecName == webrtc.MimeTypeOpus {
-			slog.Info("Track has started")
-
-			go handleAudioStream(ctx, track, &isStreaming, wsConn, &mu)
-		}
-	})
-
 	for {
 		_, message, err := wsConn.ReadMessage()
 		if err != nil {
 			slog.Error("Read message error", "error", err)
 			slog.Info("Cancel the context")
-			cancel()
+			cancel(err)
 			return
 		}
+		heartbeat.Extend(wsConn, pongTimeout)
 
 		var msg WebSocketMessage
 		if err := json.Unmarshal(message, &msg); err != nil {
@@ -115,19 +138,21 @@ func handleWebSocket(w http.ResponseWriter, r *http.Request) {
 
 		switch msg.Type {
 		case "offer":
-			parseOfferMessage(msg, peerConnection, wsConn, &mu)
+			if err := tracks.setLabels(msg.Tracks); err != nil {
+				slog.Warn("Offer rejected", "roomID", roomID, "userID", userID, "err", err)
+				sink.send(OfferRejected{Type: "offerRejected", Reason: err.Error()})
+				continue
+			}
+			parseOfferMessage(msg, peerConnection, wsConn, &wsMu)
 		case "iceCandidate":
 			parseIceCandidateMessage(msg, peerConnection)
 		case "streaming":
-			parseStreamingMessage(&isStreaming, msg)
-			message, err := json.Marshal(WebSocketMessage{Type: "streaming", IsStreaming: isStreaming})
-			if err != nil {
-				slog.Error("JSON marshal error", "Error", err)
-				continue
+			parseStreamingMessage(tracks, msg)
+			transcription.Connections.SetStreaming(roomID, userID, tracks.anyStreaming())
+			// A control event, it goes with the results
+			if err := sink.send(WebSocketMessage{Type: "streaming", IsStreaming: msg.IsStreaming, Label: msg.Label}); err != nil {
+				slog.Error("Writing streaming state failed", "Error", err)
 			}
-			mu.Lock()
-			wsConn.WriteMessage(websocket.TextMessage, message)
-			mu.Unlock()
 		}
 	}
 }
@@ -0,0 +1,155 @@
+//go:build !profanity
+
+package webrtcserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/pion/webrtc/v4"
+	"profanity.com/auth"
+)
+
+// handleWHIPOptions answers the preflight of the browsers and advertises the content type of the offers
+func handleWHIPOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Post", CONTENT_TYPE_SDP)
+	if !auth.AllowCORS(w, r) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleWHIPSessionOptions answers the preflight of the browsers on a session, and advertises the content type of
+// the trickled candidates
+func handleWHIPSessionOptions(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Accept-Patch", CONTENT_TYPE_TRICKLE_ICE)
+	if !auth.AllowCORS(w, r) {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// handleWHIP creates a WHIP session from the SDP offer of the client, and answers with the SDP answer.
+// The answer holds every candidate of the server, the client may trickle its own with PATCH.
+func handleWHIP(w http.ResponseWriter, r *http.Request) {
+	auth.AllowCORS(w, r)
+	w.Header().Set("Access-Control-Expose-Headers", "Location, Link")
+
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), CONTENT_TYPE_SDP) {
+		http.Error(w, "The offer must be "+CONTENT_TYPE_SDP, http.StatusUnsupportedMediaType)
+		return
+	}
+	roomID, userID, ok := authorizeTranscription(w, r)
+	if !ok {
+		return
+	}
+	offer, err := io.ReadAll(io.LimitReader(r.Body, MAX_SDP_SIZE))
+	if err != nil {
+		http.Error(w, "Unreadable offer", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	session := &ingestSession{
+		id:     uuid.New().String(),
+		kind:   SESSION_WHIP,
+		roomID: roomID,
+		userID: userID,
+		events: newEventStream(EVENT_QUEUE_SIZE),
+		cancel: cancel,
+		tracks: newPeerTracks(true),
+		ended:  make(chan struct{}),
+	}
+
+	peerConnection, err := newPeerConnection(ctx, cancel, func() {}, roomID, userID, session.tracks, session.events)
+	if err != nil {
+		slog.Error("New peer connection failed", "Error", err)
+		cancel(err)
+		http.Error(w, "Peer connection failed", http.StatusInternalServerError)
+		return
+	}
+	session.peerConnection = peerConnection
+	session.release = func() { peerConnection.Close() }
+
+	answer, err := negotiate(r.Context(), peerConnection, string(offer))
+	if err != nil {
+		slog.Info("WHIP offer refused", "roomID", roomID, "userID", userID, "err", err)
+		cancel(err)
+		peerConnection.Close()
+		http.Error(w, "Invalid offer", http.StatusBadRequest)
+		return
+	}
+
+	addSession(session)
+	go session.run(ctx)
+
+	slog.Info("WHIP session created", "id", session.id, "roomID", roomID, "userID", userID)
+	// Relative to the request, the locations stay valid behind the path prefix of the reverse proxy
+	location := "whip/" + session.id
+	w.Header().Set("Location", location)
+	w.Header().Set("Link", fmt.Sprintf("<%s/events>; rel=\"events\"", location))
+	w.Header().Set("Content-Type", CONTENT_TYPE_SDP)
+	w.WriteHeader(http.StatusCreated)
+	io.WriteString(w, answer)
+}
+
+// negotiate applies the offer, and returns the answer once the candidates of the server are gathered
+func negotiate(ctx context.Context, peerConnection *webrtc.PeerConnection, offer string) (string, error) {
+	if err := peerConnection.SetRemoteDescription(webrtc.SessionDescription{Type: webrtc.SDPTypeOffer, SDP: offer}); err != nil {
+		return "", err
+	}
+	answer, err := peerConnection.CreateAnswer(nil)
+	if err != nil {
+		return "", err
+	}
+
+	gathered := webrtc.GatheringCompletePromise(peerConnection)
+	if err := peerConnection.SetLocalDescription(answer); err != nil {
+		return "", err
+	}
+	select {
+	case <-gathered:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+	return peerConnection.LocalDescription().SDP, nil
+}
+
+// handleWHIPPatch adds the ICE candidates trickled by the client
+func handleWHIPPatch(w http.ResponseWriter, r *http.Request) {
+	session, ok := sessionOf(w, r, SESSION_WHIP)
+	if !ok {
+		return
+	}
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), CONTENT_TYPE_TRICKLE_ICE) {
+		http.Error(w, "The candidates must be "+CONTENT_TYPE_TRICKLE_ICE, http.StatusUnsupportedMediaType)
+		return
+	}
+	fragment, err := io.ReadAll(io.LimitReader(r.Body, MAX_SDP_SIZE))
+	if err != nil {
+		http.Error(w, "Unreadable candidates", http.StatusBadRequest)
+		return
+	}
+
+	for _, candidate := range parseTrickleCandidates(string(fragment)) {
+		if err := session.peerConnection.AddICECandidate(candidate); err != nil {
+			slog.Info("WHIP candidate refused", "id", session.id, "err", err)
+			http.Error(w, "Invalid candidate", http.StatusBadRequest)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleWHIPDelete ends the session, its last results stay readable until the event stream ends
+func handleWHIPDelete(w http.ResponseWriter, r *http.Request) {
+	deleteSession(w, r, SESSION_WHIP)
+}
+
+// handleWHIPEvents streams the results of the session as server-sent events
+func handleWHIPEvents(w http.ResponseWriter, r *http.Request) {
+	streamEvents(w, r, SESSION_WHIP)
+}
@@ -0,0 +1,53 @@
+package webrtcserver
+
+import (
+	"testing"
+)
+
+// TestParseTrickleCandidates tests that the candidates of a trickle ICE fragment keep their media section
+func TestParseTrickleCandidates(t *testing.T) {
+	fragment := "a=ice-ufrag:EsAw\r\n" +
+		"a=ice-pwd:P2uYro0UCOQ4zxjKXaWCBui1\r\n" +
+		"m=audio 9 RTP/AVP 0\r\n" +
+		"a=mid:0\r\n" +
+		"a=candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0 ufrag EsAw network-id 1\r\n" +
+		"a=candidate:3471623853 1 udp 2122194687 198.51.100.2 61765 typ host generation 0 ufrag EsAw network-id 2\r\n" +
+		"a=end-of-candidates\r\n"
+
+	candidates := parseTrickleCandidates(fragment)
+	if len(candidates) != 2 {
+		t.Fatalf("expected 2 candidates, got %d", len(candidates))
+	}
+	for _, candidate := range candidates {
+		if candidate.SDPMid == nil || *candidate.SDPMid != "0" {
+			t.Errorf("expected the candidate of the media 0, got %+v", candidate)
+		}
+	}
+	if expected := "candidate:1387637174 1 udp 2122260223 192.0.2.1 61764 typ host generation 0 ufrag EsAw network-id 1"; candidates[0].Candidate != expected {
+		t.Errorf("expected %q, got %q", expected, candidates[0].Candidate)
+	}
+
+	// A fragment without media section belongs to the first one
+	candidates = parseTrickleCandidates("a=candidate:1 1 udp 1 192.0.2.1 5000 typ host")
+	if len(candidates) != 1 || candidates[0].SDPMLineIndex == nil || *candidates[0].SDPMLineIndex != 0 {
+		t.Errorf("expected a candidate of the first media, got %+v", candidates)
+	}
+}
+
+// TestEventStream tests that a full event stream drops the results instead of blocking the transcription
+func TestEventStream(t *testing.T) {
+	events := newEventStream(1)
+	if err := events.send(WebSocketTranscription{Type: "transcription", Text: "hello"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := events.send(WebSocketTranscription{Type: "transcription", Text: "world"}); err != errEventsFull {
+		t.Errorf("expected the second result to be dropped, got %v", err)
+	}
+	if data := string(<-events.events); data != `{"type":"transcription","text":"hello","uuid":"","profanity_score":0}` {
+		t.Errorf("unexpected event %s", data)
+	}
+
+	events.close()
+	events.close()
+	<-events.done
+}
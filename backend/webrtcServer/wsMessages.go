@@ -7,6 +7,7 @@ import (
 
 	"github.com/gorilla/websocket"
 	"github.com/pion/webrtc/v4"
+	"profanity.com/metrics"
 )
 
 // parseOfferMessage parses the offer message
@@ -34,6 +35,7 @@ func parseOfferMessage(msg WebSocketMessage, peerConnection *webrtc.PeerConnecti
 	defer mu.Unlock()
 	if err := wsConn.WriteJSON(WebSocketMessage{Type: "answer", SDP: answer.SDP}); err != nil {
 		slog.Error("Error writing answer", "error", err)
+		metrics.WebSocketWriteErrors.WithLabelValues(metrics.ENDPOINT_TRANSCRIPTION).Inc()
 		return
 	}
 }
@@ -64,11 +66,11 @@ func parseIceCandidateMessage(msg WebSocketMessage, peerConnection *webrtc.PeerC
 	}
 }
 
-// parseStreamingMessage parses the streaming message
-func parseStreamingMessage(isStreaming *bool, msg WebSocketMessage) {
-	slog.Info("Streaming message received")
-	*isStreaming = msg.IsStreaming
-	if *isStreaming {
+// parseStreamingMessage parses the streaming message, of a single track when it has a label
+func parseStreamingMessage(tracks *peerTracks, msg WebSocketMessage) {
+	slog.Info("Streaming message received", "label", msg.Label)
+	tracks.setStreaming(msg.Label, msg.IsStreaming)
+	if msg.IsStreaming {
 		slog.Info("Starting streaming")
 	} else {
 		slog.Info("Stopping streaming")
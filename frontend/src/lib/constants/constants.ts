@@ -6,3 +6,7 @@ export const TRANSCRIPTION = 'transcription';
 export const HANG_UP = 'hangUp';
 export const LLM_ANALYSIS = 'llmAnalysis';
 export const EMOJI = 'emoji';
+export const MIC_LABEL = 'mic';
+// Data channel of the results, negotiated with the same ID by the server
+export const RESULTS_CHANNEL = 'results';
+export const RESULTS_CHANNEL_ID = 0;
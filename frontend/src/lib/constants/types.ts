@@ -24,3 +24,18 @@ export type LLMAnalysis = {
   userMessage: string;
   timestamp: string;
 };
+
+export type RoomCreation = {
+  room_id: string;
+  user_id: string;
+  host_token: string;
+  invite_token: string;
+  expires_at: string;
+};
+
+export type GuestToken = {
+  room_id: string;
+  user_id: string;
+  token: string;
+  expires_at: string;
+};
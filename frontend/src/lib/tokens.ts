@@ -0,0 +1,54 @@
+import { PUBLIC_SERVER_URL } from '$env/static/public';
+import type { RoomCreation, GuestToken } from '@/lib/constants/types';
+
+// The tokens are kept for the tab, a reload joins the room again as the same user
+const tokenKey = (roomID: string) => `room-token:${roomID}`;
+const inviteKey = (roomID: string) => `room-invite:${roomID}`;
+
+// saveRoom keeps the host token and the invite of a created room
+export function saveRoom(room: RoomCreation) {
+  sessionStorage.setItem(tokenKey(room.room_id), room.host_token);
+  sessionStorage.setItem(inviteKey(room.room_id), room.invite_token);
+}
+
+// inviteOf returns the invite shared with the guests of the room, if known
+export function inviteOf(roomID: string): string | null {
+  return sessionStorage.getItem(inviteKey(roomID));
+}
+
+// tokenOf returns the token joining the room, redeeming the invite for a user of our own the first time
+export async function tokenOf(roomID: string, invite: string | null): Promise<string | null> {
+  const saved = sessionStorage.getItem(tokenKey(roomID));
+  if (saved) {
+    return saved;
+  }
+  if (!invite) {
+    return null;
+  }
+  sessionStorage.setItem(inviteKey(roomID), invite);
+
+  let resp = await redeem(roomID, invite, '');
+  if (resp.status === 401) {
+    const password = window.prompt('Room password');
+    if (password === null) {
+      return null;
+    }
+    resp = await redeem(roomID, invite, password);
+  }
+  if (!resp.ok) {
+    console.error('Invite redemption failed:', resp.status);
+    return null;
+  }
+
+  const { token }: GuestToken = await resp.json();
+  sessionStorage.setItem(tokenKey(roomID), token);
+  return token;
+}
+
+// redeem exchanges the invite for a token of our own, the password is sent in the body
+function redeem(roomID: string, invite: string, password: string): Promise<Response> {
+  return fetch(
+    `${PUBLIC_SERVER_URL}/api/backend/v1/rooms/${roomID}/redeem?token=${encodeURIComponent(invite)}`,
+    { method: 'POST', body: new URLSearchParams({ password }) }
+  );
+}
@@ -6,6 +6,8 @@ interface IceCandidateMessage {
 interface OfferMessage {
   type: string;
   sdp: string;
+  // Labels of the audio tracks, by track ID or stream ID
+  tracks?: Record<string, string>;
 }
 
 interface AnswerMessage {
@@ -16,6 +18,8 @@ interface AnswerMessage {
 interface StreamingMessage {
   type: string;
   isStreaming: boolean;
+  // Label of the toggled track, every track when omitted
+  label?: string;
 }
 
 type WebSocketMessage = IceCandidateMessage | OfferMessage | AnswerMessage;